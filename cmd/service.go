@@ -1,11 +1,22 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
 
-	bugLog "github.com/bugfixes/go-bugfixes/logs"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
 	"github.com/k8sdeploy/key-service/internal/config"
+	"github.com/k8sdeploy/key-service/internal/key"
+	"github.com/k8sdeploy/key-service/internal/logging"
 	"github.com/k8sdeploy/key-service/internal/service"
+	"github.com/k8sdeploy/key-service/internal/tracing"
 )
 
 var (
@@ -15,21 +26,119 @@ var (
 )
 
 func main() {
-	bugLog.Local().Info(fmt.Sprintf("Starting %s", ServiceName))
-	bugLog.Local().Info(fmt.Sprintf("Version: %s, Hash: %s", BuildVersion, BuildHash))
+	checkConfig := flag.Bool("check-config", false, "print the effective config with secrets redacted, check it and exit")
+	migrateLegacyKeys := flag.Bool("migrate-legacy-keys", false, "copy the bundles of service keys into mongodb.legacy_db and legacy_keys_collection and exit")
+	migrateFromDB := flag.String("migrate-from-db", "keys", "database the bundles are copied from")
+	migrateFromCollection := flag.String("migrate-from-collection", "keys", "collection the bundles are copied from")
+	flag.Parse()
 
-	cfg, err := config.Build()
+	logger := logging.New(os.Stdout, false)
+	if *checkConfig {
+		os.Exit(runCheckConfig(logger))
+	}
+	if *migrateLegacyKeys {
+		os.Exit(runMigrateLegacyKeys(logger, config.DB{Database: *migrateFromDB, KeysCollection: *migrateFromCollection}))
+	}
+	_ = level.Info(logger).Log("msg", "starting", "service", ServiceName, "version", BuildVersion, "hash", BuildHash)
+
+	tracingCfg, err := config.BuildTracing()
+	if err != nil {
+		_ = level.Error(logger).Log("msg", "building tracing config", "err", err)
+		return
+	}
+	shutdownTracing, err := tracing.Setup(context.Background(), tracingCfg.Endpoint, tracingCfg.Insecure, tracingCfg.SampleRatio, ServiceName, BuildVersion)
+	if err != nil {
+		_ = level.Error(logger).Log("msg", "setting up tracing", "err", err)
+		return
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			_ = level.Warn(logger).Log("msg", "shutting down tracing", "err", err)
+		}
+	}()
+
+	cfg, err := config.Build(logger)
 	if err != nil {
-		_ = bugLog.Errorf("config: %v", err)
+		_ = level.Error(logger).Log("msg", "building config", "err", err)
+		return
+	}
+	if err := cfg.Validate(); err != nil {
+		_ = level.Error(logger).Log("msg", "validating config", "err", err)
 		return
 	}
 
+	if cfg.Development {
+		logger = logging.New(os.Stdout, true)
+	}
+
 	s := &service.Service{
 		Config: cfg,
+		Logger: logger,
 	}
 
-	if err := s.Start(); err != nil {
-		_ = bugLog.Errorf("start service: %v", err)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	if err := s.Start(ctx); err != nil {
+		_ = level.Error(logger).Log("msg", "running service", "err", err)
 		return
 	}
 }
+
+// runCheckConfig prints the config the service would run with and whether it is valid, the exit code
+// is non zero if it isn't
+func runCheckConfig(logger log.Logger) int {
+	cfg, err := config.Check(logger)
+
+	out, merr := json.MarshalIndent(cfg.Summary(), "", "  ")
+	if merr != nil {
+		_ = level.Error(logger).Log("msg", "printing config", "err", merr)
+		return 1
+	}
+	fmt.Println(string(out))
+
+	if err != nil {
+		var invalid *config.ValidationError
+		if !errors.As(err, &invalid) {
+			_ = level.Error(logger).Log("msg", "checking config", "err", err)
+			return 1
+		}
+		for _, problem := range invalid.Problems {
+			fmt.Fprintln(os.Stderr, problem)
+		}
+		return 1
+	}
+	fmt.Fprintln(os.Stderr, "config is valid")
+
+	return 0
+}
+
+// runMigrateLegacyKeys is the one off move of the bundles of service keys from the hard coded keys.keys
+// into wherever the config says they live now
+func runMigrateLegacyKeys(logger log.Logger, from config.DB) int {
+	cfg, err := config.Build(logger)
+	if err != nil {
+		_ = level.Error(logger).Log("msg", "building config", "err", err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	m := key.NewMongo(cfg)
+	m.Logger = logger
+	to := cfg.MongoConfig().Legacy
+	copied, skipped, err := m.MigrateLegacyKeys(ctx, from)
+	if err != nil {
+		_ = level.Error(logger).Log("msg", "migrating legacy keys", "copied", copied, "skipped", skipped, "err", err)
+		return 1
+	}
+	_ = level.Info(logger).Log(
+		"msg", "migrated legacy keys",
+		"from", from.Database+"."+from.KeysCollection,
+		"to", to.Database+"."+to.KeysCollection,
+		"copied", copied,
+		"skipped", skipped)
+
+	return 0
+}
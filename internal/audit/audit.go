@@ -0,0 +1,151 @@
+package audit
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Actions
+const (
+	Create           = "create"
+	Rotate           = "rotate"
+	Revoke           = "revoke"
+	ValidationFailed = "validation_failed"
+)
+
+// Outcomes
+const (
+	Success = "success"
+	Failure = "failure"
+)
+
+var ErrTampered = errors.New("audit chain has been tampered with")
+
+// Record is one entry in the audit chain, it never holds a secret, only the id of the key it is about
+type Record struct {
+	Seq      int64     `json:"seq" bson:"_id"`
+	Time     time.Time `json:"time" bson:"time"`
+	Action   string    `json:"action" bson:"action"`
+	Actor    string    `json:"actor" bson:"actor"`
+	KeyType  string    `json:"key_type" bson:"key_type"`
+	Owner    string    `json:"owner" bson:"owner"`
+	KeyID    string    `json:"key_id" bson:"key_id"`
+	Outcome  string    `json:"outcome" bson:"outcome"`
+	PrevHash string    `json:"prev_hash" bson:"prev_hash"`
+	Hash     string    `json:"hash" bson:"hash"`
+}
+
+type Filter struct {
+	Owner string
+	From  time.Time
+	To    time.Time
+}
+
+type Store interface {
+	// Append chains the record onto the last one and stores it, the stored record is returned
+	Append(ctx context.Context, r Record) (Record, error)
+	Query(ctx context.Context, f Filter) ([]Record, error)
+	// Range returns the records from seq from to seq to in order, so a stretch of the chain can be verified
+	Range(ctx context.Context, from, to int64) ([]Record, error)
+}
+
+// Chain links r onto prev, prev is nil for the first record. the hash is keyed so the chain can't be
+// rewritten by anyone without the key
+func Chain(key []byte, prev *Record, r Record) Record {
+	r.Seq = 1
+	r.PrevHash = ""
+	if prev != nil {
+		r.Seq = prev.Seq + 1
+		r.PrevHash = prev.Hash
+	}
+	// mongo only keeps milliseconds, hash what will actually be stored
+	r.Time = r.Time.UTC().Truncate(time.Millisecond)
+	r.Hash = Hash(key, r)
+
+	return r
+}
+
+// Hash is the hmac-sha256 of every field in the record apart from the hash itself
+func Hash(key []byte, r Record) string {
+	r.Hash = ""
+	r.Time = r.Time.UTC()
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		// a struct of strings, ints and a time always marshals
+		panic(err)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(b)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the whole chain link by link from the first record, every record has to match its hash
+// and link onto the one before, so edited, removed or inserted records are all caught
+func Verify(key []byte, records []Record) error {
+	if len(records) > 0 && records[0].Seq != 1 {
+		return fmt.Errorf("record %d: %w", records[0].Seq, ErrTampered)
+	}
+
+	return VerifyRange(key, records)
+}
+
+// VerifyRange checks a stretch of the chain link by link. the first record is the anchor the rest hang off,
+// it only has to match its hash as only the key holder could have written it, so the stretch doesn't need
+// checking back to the first record
+func VerifyRange(key []byte, records []Record) error {
+	var prev *Record
+	for i := range records {
+		r := records[i]
+		if !hmac.Equal([]byte(Hash(key, r)), []byte(r.Hash)) {
+			return fmt.Errorf("record %d: %w", r.Seq, ErrTampered)
+		}
+		if prev == nil {
+			if r.Seq < 1 || (r.Seq == 1 && r.PrevHash != "") {
+				return fmt.Errorf("record %d: %w", r.Seq, ErrTampered)
+			}
+		} else if r.Seq != prev.Seq+1 || r.PrevHash != prev.Hash {
+			return fmt.Errorf("record %d: %w", r.Seq, ErrTampered)
+		}
+		prev = &records[i]
+	}
+
+	return nil
+}
+
+// Contains checks every record is the one at its place in a verified stretch of the chain, so records that
+// were queried on their own can be trusted as far as the chain can
+func Contains(chain, records []Record) error {
+	for _, r := range records {
+		i := int64(-1)
+		if len(chain) > 0 {
+			i = r.Seq - chain[0].Seq
+		}
+		if i < 0 || i >= int64(len(chain)) || chain[i].Hash != r.Hash {
+			return fmt.Errorf("record %d: %w", r.Seq, ErrTampered)
+		}
+	}
+
+	return nil
+}
+
+func (f Filter) Matches(r Record) bool {
+	if f.Owner != "" && r.Owner != f.Owner {
+		return false
+	}
+	if !f.From.IsZero() && r.Time.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && r.Time.After(f.To) {
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,207 @@
+package audit_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/k8sdeploy/key-service/internal/audit"
+)
+
+var testKey = []byte("audit-hmac-key")
+
+func chain(t *testing.T) []audit.Record {
+	t.Helper()
+
+	m := audit.NewMemory(testKey)
+	start := time.Unix(1670000000, 0)
+	for i, r := range []audit.Record{
+		{Action: audit.Create, Actor: "hooks-service", KeyType: "hooks", Owner: "company-1", KeyID: "key-1", Outcome: audit.Success},
+		{Action: audit.Rotate, Actor: "hooks-service", KeyType: "hooks", Owner: "company-1", KeyID: "key-2", Outcome: audit.Success},
+		{Action: audit.ValidationFailed, Actor: "orchestrator", KeyType: "hooks", Owner: "company-2", KeyID: "key-3", Outcome: audit.Failure},
+		{Action: audit.Revoke, Actor: "orchestrator", KeyType: "hooks", Owner: "company-1", KeyID: "key-2", Outcome: audit.Success},
+	} {
+		r.Time = start.Add(time.Duration(i) * time.Minute)
+		if _, err := m.Append(context.Background(), r); err != nil {
+			t.Fatalf("Append() = %v", err)
+		}
+	}
+
+	records, err := m.Range(context.Background(), 1, 4)
+	if err != nil {
+		t.Fatalf("Range() = %v", err)
+	}
+
+	return records
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func([]audit.Record) []audit.Record
+		want   error
+	}{
+		{
+			name:   "untouched",
+			tamper: func(r []audit.Record) []audit.Record { return r },
+		},
+		{
+			name: "edited actor",
+			tamper: func(r []audit.Record) []audit.Record {
+				r[1].Actor = "someone-else"
+				return r
+			},
+			want: audit.ErrTampered,
+		},
+		{
+			name: "edited and rehashed",
+			tamper: func(r []audit.Record) []audit.Record {
+				r[1].Actor = "someone-else"
+				r[1].Hash = audit.Hash(testKey, r[1])
+				return r
+			},
+			want: audit.ErrTampered,
+		},
+		{
+			name: "rewritten without the key",
+			tamper: func(r []audit.Record) []audit.Record {
+				r[1].Actor = "someone-else"
+				var prev *audit.Record
+				for i := range r {
+					r[i] = audit.Chain([]byte("guessed-key"), prev, r[i])
+					prev = &r[i]
+				}
+				return r
+			},
+			want: audit.ErrTampered,
+		},
+		{
+			name: "removed record",
+			tamper: func(r []audit.Record) []audit.Record {
+				return append(r[:1], r[2:]...)
+			},
+			want: audit.ErrTampered,
+		},
+		{
+			name: "inserted record",
+			tamper: func(r []audit.Record) []audit.Record {
+				forged := audit.Chain(testKey, &r[0], audit.Record{Action: audit.Create, Owner: "company-1"})
+				return append([]audit.Record{r[0], forged}, r[1:]...)
+			},
+			want: audit.ErrTampered,
+		},
+		{
+			name: "removed first record",
+			tamper: func(r []audit.Record) []audit.Record {
+				return r[1:]
+			},
+			want: audit.ErrTampered,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := audit.Verify(testKey, tt.tamper(chain(t))); !errors.Is(err, tt.want) {
+				t.Errorf("Verify() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyRange(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func([]audit.Record) []audit.Record
+		want   error
+	}{
+		{
+			name:   "anchored on a later record",
+			tamper: func(r []audit.Record) []audit.Record { return r[1:] },
+		},
+		{
+			name: "edited anchor",
+			tamper: func(r []audit.Record) []audit.Record {
+				r[1].Actor = "someone-else"
+				return r[1:]
+			},
+			want: audit.ErrTampered,
+		},
+		{
+			name: "anchor written without the key",
+			tamper: func(r []audit.Record) []audit.Record {
+				r[1] = audit.Chain([]byte("guessed-key"), &r[0], r[1])
+				return r[1:]
+			},
+			want: audit.ErrTampered,
+		},
+		{
+			name: "removed record after the anchor",
+			tamper: func(r []audit.Record) []audit.Record {
+				return append(r[1:2], r[3:]...)
+			},
+			want: audit.ErrTampered,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := audit.VerifyRange(testKey, tt.tamper(chain(t))); !errors.Is(err, tt.want) {
+				t.Errorf("VerifyRange() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemory_Query(t *testing.T) {
+	m := audit.NewMemory(testKey)
+	start := time.Unix(1670000000, 0)
+	for i, owner := range []string{"company-1", "company-2", "company-1", "company-1"} {
+		if _, err := m.Append(context.Background(), audit.Record{
+			Time:   start.Add(time.Duration(i) * time.Minute),
+			Action: audit.Create,
+			Owner:  owner,
+		}); err != nil {
+			t.Fatalf("Append() = %v", err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter audit.Filter
+		want   []int64
+	}{
+		{
+			name:   "company",
+			filter: audit.Filter{Owner: "company-1"},
+			want:   []int64{1, 3, 4},
+		},
+		{
+			name:   "company and range",
+			filter: audit.Filter{Owner: "company-1", From: start.Add(time.Minute), To: start.Add(2 * time.Minute)},
+			want:   []int64{3},
+		},
+		{
+			name:   "other company",
+			filter: audit.Filter{Owner: "company-2"},
+			want:   []int64{2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := m.Query(context.Background(), tt.filter)
+			if err != nil {
+				t.Fatalf("Query() = %v", err)
+			}
+			if len(records) != len(tt.want) {
+				t.Fatalf("Query() returned %d records, want %d", len(records), len(tt.want))
+			}
+			for i, r := range records {
+				if r.Seq != tt.want[i] {
+					t.Errorf("Query()[%d].Seq = %d, want %d", i, r.Seq, tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package audit
+
+import (
+	"context"
+	"sync"
+)
+
+type Memory struct {
+	Key []byte
+
+	mu      sync.Mutex
+	records []Record
+}
+
+func NewMemory(key []byte) *Memory {
+	return &Memory{
+		Key: key,
+	}
+}
+
+func (m *Memory) Append(ctx context.Context, r Record) (Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var prev *Record
+	if len(m.records) > 0 {
+		prev = &m.records[len(m.records)-1]
+	}
+	r = Chain(m.Key, prev, r)
+	m.records = append(m.records, r)
+
+	return r, nil
+}
+
+func (m *Memory) Query(ctx context.Context, f Filter) ([]Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var records []Record
+	for _, r := range m.records {
+		if f.Matches(r) {
+			records = append(records, r)
+		}
+	}
+
+	return records, nil
+}
+
+func (m *Memory) Range(ctx context.Context, from, to int64) ([]Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var records []Record
+	for _, r := range m.records {
+		if r.Seq >= from && r.Seq <= to {
+			records = append(records, r)
+		}
+	}
+
+	return records, nil
+}
@@ -0,0 +1,39 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// Result is a cached validation, State holds why a key that matched can't be used, eg revoked
+type Result struct {
+	Valid bool   `json:"valid"`
+	State string `json:"state,omitempty"`
+}
+
+// Cache holds validation results by the hash of what was presented, entries are grouped by owner so
+// they can all be dropped when the owner's key is rotated or revoked
+type Cache interface {
+	Get(ctx context.Context, key string) (Result, bool, error)
+	// Generation is the owner's current generation, every Invalidate moves it on
+	Generation(ctx context.Context, owner string) (int64, error)
+	// Set only stores r if the owner is still at generation, so a result looked up before an
+	// invalidation isn't kept after it
+	Set(ctx context.Context, owner, key string, generation int64, r Result, ttl time.Duration) error
+	Invalidate(ctx context.Context, owner string) error
+}
+
+// Key hashes everything that was presented so the secret never sits in the cache
+func Key(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// Owner is the invalidation group for a type of key belonging to an owner
+func Owner(keyType, owner string) string {
+	return keyType + ":" + owner
+}
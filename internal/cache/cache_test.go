@@ -0,0 +1,134 @@
+package cache_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/k8sdeploy/key-service/internal/cache"
+)
+
+func backends(t *testing.T) map[string]cache.Cache {
+	t.Helper()
+
+	m, err := cache.NewMemory(10)
+	if err != nil {
+		t.Fatalf("NewMemory() = %v", err)
+	}
+
+	mr := miniredis.RunT(t)
+
+	return map[string]cache.Cache{
+		"memory": m,
+		"redis":  cache.NewRedis(mr.Addr(), "", 0, time.Minute),
+	}
+}
+
+func TestCache(t *testing.T) {
+	ctx := context.Background()
+	owner := cache.Owner("hooks", "company-1")
+	other := cache.Owner("hooks", "company-2")
+	valid := cache.Key("hooks", "company-1", "key", "secret")
+	invalid := cache.Key("hooks", "company-1", "key", "wrong")
+	otherValid := cache.Key("hooks", "company-2", "key", "secret")
+
+	for name, c := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			if _, hit, _ := c.Get(ctx, valid); hit {
+				t.Fatalf("Get() hit on an empty cache")
+			}
+
+			_ = c.Set(ctx, owner, valid, 0, cache.Result{Valid: true}, time.Minute)
+			_ = c.Set(ctx, owner, invalid, 0, cache.Result{}, time.Minute)
+			_ = c.Set(ctx, other, otherValid, 0, cache.Result{Valid: true}, time.Minute)
+
+			if r, hit, err := c.Get(ctx, valid); err != nil || !hit || !r.Valid {
+				t.Errorf("Get(valid) = %v, %v, %v, want a valid hit", r, hit, err)
+			}
+			if r, hit, err := c.Get(ctx, invalid); err != nil || !hit || r.Valid {
+				t.Errorf("Get(invalid) = %v, %v, %v, want an invalid hit", r, hit, err)
+			}
+
+			if err := c.Invalidate(ctx, owner); err != nil {
+				t.Fatalf("Invalidate() = %v", err)
+			}
+			if _, hit, _ := c.Get(ctx, valid); hit {
+				t.Errorf("Get(valid) hit after invalidation")
+			}
+			if _, hit, _ := c.Get(ctx, invalid); hit {
+				t.Errorf("Get(invalid) hit after invalidation")
+			}
+			if _, hit, _ := c.Get(ctx, otherValid); !hit {
+				t.Errorf("Get() for another owner missed after invalidation")
+			}
+		})
+	}
+}
+
+func TestCache_Generation(t *testing.T) {
+	ctx := context.Background()
+	owner := cache.Owner("hooks", "company-1")
+	key := cache.Key("hooks", "company-1", "key", "secret")
+
+	for name, c := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			// a lookup starts, the key is rotated while it runs, then it tries to cache what it found
+			before, err := c.Generation(ctx, owner)
+			if err != nil {
+				t.Fatalf("Generation() = %v", err)
+			}
+			if err := c.Invalidate(ctx, owner); err != nil {
+				t.Fatalf("Invalidate() = %v", err)
+			}
+			if err := c.Set(ctx, owner, key, before, cache.Result{Valid: true}, time.Minute); err != nil {
+				t.Fatalf("Set() = %v", err)
+			}
+			if _, hit, _ := c.Get(ctx, key); hit {
+				t.Errorf("Get() hit a result looked up before the invalidation")
+			}
+
+			after, err := c.Generation(ctx, owner)
+			if err != nil {
+				t.Fatalf("Generation() = %v", err)
+			}
+			if after == before {
+				t.Fatalf("Generation() = %d after Invalidate(), want it moved on", after)
+			}
+			_ = c.Set(ctx, owner, key, after, cache.Result{Valid: true}, time.Minute)
+			if _, hit, _ := c.Get(ctx, key); !hit {
+				t.Errorf("Get() missed a result looked up after the invalidation")
+			}
+		})
+	}
+}
+
+func TestMemory_Expiry(t *testing.T) {
+	now := time.Unix(1670000000, 0)
+	m, _ := cache.NewMemory(10)
+	m.Now = func() time.Time { return now }
+
+	_ = m.Set(context.Background(), "owner", "key", 0, cache.Result{Valid: true}, time.Second)
+	if _, hit, _ := m.Get(context.Background(), "key"); !hit {
+		t.Fatalf("Get() missed before expiry")
+	}
+
+	now = now.Add(2 * time.Second)
+	if _, hit, _ := m.Get(context.Background(), "key"); hit {
+		t.Errorf("Get() hit after expiry")
+	}
+}
+
+func TestMemory_Evicts(t *testing.T) {
+	m, _ := cache.NewMemory(2)
+	for _, k := range []string{"a", "b", "c"} {
+		_ = m.Set(context.Background(), "owner", k, 0, cache.Result{Valid: true}, time.Minute)
+	}
+
+	if _, hit, _ := m.Get(context.Background(), "a"); hit {
+		t.Errorf("Get() hit on the least recently used entry after it was evicted")
+	}
+	if _, hit, _ := m.Get(context.Background(), "c"); !hit {
+		t.Errorf("Get() missed the newest entry")
+	}
+}
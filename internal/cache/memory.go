@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+)
+
+type entry struct {
+	owner   string
+	result  Result
+	expires time.Time
+}
+
+// Memory is an in process LRU, the least recently used entries go first once it is full
+type Memory struct {
+	// gen orders Set against Invalidate, mu only guards the owners index as evictions take it from
+	// inside the lru
+	gen         sync.Mutex
+	generations map[string]int64
+
+	mu     sync.Mutex
+	lru    *lru.Cache[string, entry]
+	owners map[string]map[string]struct{}
+	Now    func() time.Time
+}
+
+func NewMemory(size int) (*Memory, error) {
+	m := &Memory{
+		generations: make(map[string]int64),
+		owners:      make(map[string]map[string]struct{}),
+		Now:         time.Now,
+	}
+
+	l, err := lru.NewWithEvict[string, entry](size, m.evicted)
+	if err != nil {
+		return nil, err
+	}
+	m.lru = l
+
+	return m, nil
+}
+
+// evicted is called by the lru with its own lock held, the owners index has its own lock
+func (m *Memory) evicted(key string, e entry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if keys, ok := m.owners[e.owner]; ok {
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(m.owners, e.owner)
+		}
+	}
+}
+
+func (m *Memory) Get(ctx context.Context, key string) (Result, bool, error) {
+	e, ok := m.lru.Get(key)
+	if !ok {
+		return Result{}, false, nil
+	}
+	if !e.expires.After(m.Now()) {
+		m.lru.Remove(key)
+		return Result{}, false, nil
+	}
+
+	return e.result, true, nil
+}
+
+func (m *Memory) Generation(ctx context.Context, owner string) (int64, error) {
+	m.gen.Lock()
+	defer m.gen.Unlock()
+
+	return m.generations[owner], nil
+}
+
+func (m *Memory) Set(ctx context.Context, owner, key string, generation int64, r Result, ttl time.Duration) error {
+	m.gen.Lock()
+	defer m.gen.Unlock()
+	if m.generations[owner] != generation {
+		return nil
+	}
+
+	m.mu.Lock()
+	if _, ok := m.owners[owner]; !ok {
+		m.owners[owner] = make(map[string]struct{})
+	}
+	m.owners[owner][key] = struct{}{}
+	m.mu.Unlock()
+
+	m.lru.Add(key, entry{
+		owner:   owner,
+		result:  r,
+		expires: m.Now().Add(ttl),
+	})
+
+	return nil
+}
+
+func (m *Memory) Invalidate(ctx context.Context, owner string) error {
+	m.gen.Lock()
+	defer m.gen.Unlock()
+	m.generations[owner]++
+
+	m.mu.Lock()
+	keys := m.owners[owner]
+	delete(m.owners, owner)
+	m.mu.Unlock()
+
+	for key := range keys {
+		m.lru.Remove(key)
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const prefix = "key-service:validation:"
+
+// Redis shares results between replicas, so a rotate or revoke on one replica invalidates them all
+type Redis struct {
+	Client *redis.Client
+	// MaxTTL is the longest any entry is kept, the owner index is kept that long
+	MaxTTL time.Duration
+}
+
+func NewRedis(address, password string, db int, maxTTL time.Duration) *Redis {
+	return &Redis{
+		Client: redis.NewClient(&redis.Options{
+			Addr:     address,
+			Password: password,
+			DB:       db,
+		}),
+		MaxTTL: maxTTL,
+	}
+}
+
+func ownerKey(owner string) string {
+	return prefix + "owner:" + owner
+}
+
+func generationKey(owner string) string {
+	return prefix + "generation:" + owner
+}
+
+// set stores the entry and adds it to the owner's index, only if the owner's generation hasn't moved on
+var set = redis.NewScript(`
+local generation = redis.call("GET", KEYS[1]) or "0"
+if generation ~= ARGV[1] then
+	return 0
+end
+redis.call("SET", KEYS[2], ARGV[2], "PX", ARGV[3])
+redis.call("SADD", KEYS[3], ARGV[4])
+redis.call("PEXPIRE", KEYS[3], ARGV[5])
+return 1
+`)
+
+// invalidate moves the owner's generation on and drops every entry in its index, as one step so nothing
+// can be set in between
+var invalidate = redis.NewScript(`
+redis.call("INCR", KEYS[1])
+redis.call("PEXPIRE", KEYS[1], ARGV[1])
+for _, key in ipairs(redis.call("SMEMBERS", KEYS[2])) do
+	redis.call("DEL", ARGV[2] .. key)
+end
+redis.call("DEL", KEYS[2])
+return 1
+`)
+
+func (r *Redis) Get(ctx context.Context, key string) (Result, bool, error) {
+	b, err := r.Client.Get(ctx, prefix+key).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return Result{}, false, nil
+		}
+		return Result{}, false, err
+	}
+
+	var result Result
+	if err := json.Unmarshal(b, &result); err != nil {
+		return Result{}, false, err
+	}
+
+	return result, true, nil
+}
+
+func (r *Redis) Generation(ctx context.Context, owner string) (int64, error) {
+	generation, err := r.Client.Get(ctx, generationKey(owner)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+
+	return generation, err
+}
+
+func (r *Redis) Set(ctx context.Context, owner, key string, generation int64, result Result, ttl time.Duration) error {
+	b, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	// the index only has to outlive the entries in it
+	return set.Run(ctx, r.Client,
+		[]string{generationKey(owner), prefix + key, ownerKey(owner)},
+		generation, b, ttl.Milliseconds(), key, r.MaxTTL.Milliseconds()).Err()
+}
+
+// Invalidate leaves the generation set for MaxTTL, it only has to outlive the lookups under way, which are
+// far shorter
+func (r *Redis) Invalidate(ctx context.Context, owner string) error {
+	return invalidate.Run(ctx, r.Client,
+		[]string{generationKey(owner), ownerKey(owner)},
+		r.MaxTTL.Milliseconds(), prefix).Err()
+}
+
+func (r *Redis) Close() error {
+	return r.Client.Close()
+}
@@ -0,0 +1,15 @@
+package compare
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+)
+
+// Equal reports whether a and b match in constant time, both sides are hashed first
+// so the comparison doesn't leak the length of the stored value either
+func Equal(a, b string) bool {
+	ah := sha256.Sum256([]byte(a))
+	bh := sha256.Sum256([]byte(b))
+
+	return subtle.ConstantTimeCompare(ah[:], bh[:]) == 1
+}
@@ -0,0 +1,49 @@
+package compare_test
+
+import (
+	"testing"
+
+	"github.com/k8sdeploy/key-service/internal/compare"
+)
+
+func TestEqual(t *testing.T) {
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		want bool
+	}{
+		{
+			name: "same",
+			a:    "abcdefghijklmnopqrstuvwxyz",
+			b:    "abcdefghijklmnopqrstuvwxyz",
+			want: true,
+		},
+		{
+			name: "different",
+			a:    "abcdefghijklmnopqrstuvwxyz",
+			b:    "abcdefghijklmnopqrstuvwxyZ",
+			want: false,
+		},
+		{
+			name: "prefix",
+			a:    "abcdefghijklmnopqrstuvwxyz",
+			b:    "abcdefghijklm",
+			want: false,
+		},
+		{
+			name: "empty",
+			a:    "",
+			b:    "",
+			want: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := compare.Equal(tt.a, tt.b); got != tt.want {
+				t.Errorf("Equal() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
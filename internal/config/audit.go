@@ -0,0 +1,37 @@
+package config
+
+import (
+	"context"
+
+	"github.com/caarlos0/env/v6"
+)
+
+type Audit struct {
+	Backend    string `env:"AUDIT_BACKEND" envDefault:"mongo"`
+	Database   string `env:"AUDIT_DB" envDefault:"key-service"`
+	Collection string `env:"AUDIT_COLLECTION" envDefault:"audit"`
+
+	// HMACKey keys the chain hashes, it comes from the audit secret so rewriting the chain needs it too
+	HMACKey string
+}
+
+func BuildAudit(c *Config) error {
+	a := &Audit{}
+
+	if err := env.Parse(a); err != nil {
+		return err
+	}
+
+	// development keeps its audit log in memory, it has nothing worth keying
+	if !c.Development {
+		values, err := c.Source().Values(context.Background(), AuditSecret)
+		if err != nil {
+			return err
+		}
+		a.HMACKey = values["hmac_key"]
+	}
+
+	c.Audit = *a
+
+	return nil
+}
@@ -0,0 +1,29 @@
+package config
+
+import (
+	"time"
+
+	"github.com/caarlos0/env/v6"
+)
+
+type Cache struct {
+	Backend       string        `env:"CACHE_BACKEND" envDefault:"memory"`
+	Size          int           `env:"CACHE_SIZE" envDefault:"10000"`
+	TTL           time.Duration `env:"CACHE_TTL" envDefault:"30s"`
+	NegativeTTL   time.Duration `env:"CACHE_NEGATIVE_TTL" envDefault:"10s"`
+	RedisAddress  string        `env:"CACHE_REDIS_ADDRESS" envDefault:"redis.redis:6379"`
+	RedisPassword string        `env:"CACHE_REDIS_PASSWORD" envDefault:""`
+	RedisDB       int           `env:"CACHE_REDIS_DB" envDefault:"0"`
+}
+
+func BuildCache(c *Config) error {
+	cache := &Cache{}
+
+	if err := env.Parse(cache); err != nil {
+		return err
+	}
+
+	c.Cache = *cache
+
+	return nil
+}
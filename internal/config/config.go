@@ -1,33 +1,119 @@
 package config
 
 import (
-	bugLog "github.com/bugfixes/go-bugfixes/logs"
+	"errors"
+	"fmt"
+	"sync"
+
 	"github.com/caarlos0/env/v6"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	vaultAPI "github.com/hashicorp/vault/api"
 )
 
 type Config struct {
 	Local
 	Mongo
 	Vault
+	RateLimit
+	Tracing
+	Audit
+	Events
+	Cache
+	Sources
+
+	source Source
+
+	// secrets guards what WatchSecrets can swap while running
+	secrets      sync.RWMutex
+	mongoChanged chan struct{}
+	mongoLease   *vaultAPI.Secret
 }
 
-func Build() (*Config, error) {
+func Build(logger log.Logger) (*Config, error) {
 	cfg := &Config{}
 
 	if err := env.Parse(cfg); err != nil {
-		return nil, bugLog.Error(err)
+		return nil, fmt.Errorf("parse env: %w", err)
 	}
 
-	if err := BuildMongo(cfg); err != nil {
-		return nil, bugLog.Error(err)
+	if err := BuildSources(cfg); err != nil {
+		return nil, fmt.Errorf("sources: %w", err)
 	}
+	_ = level.Debug(logger).Log("msg", "loaded config sources", "sources", cfg.Source().Name())
 
+	// vault goes before anything that reads secrets
 	if err := BuildVault(cfg); err != nil {
-		return nil, bugLog.Error(err)
+		return nil, fmt.Errorf("vault: %w", err)
+	}
+	_ = level.Debug(logger).Log("msg", "loaded vault config", "auth_method", cfg.Vault.AuthMethod)
+
+	if err := BuildMongo(cfg); err != nil {
+		return nil, fmt.Errorf("mongo: %w", err)
 	}
+	_ = level.Debug(logger).Log("msg", "loaded mongo config", "host", cfg.Mongo.Host)
 
 	if err := BuildLocal(cfg); err != nil {
-		return nil, bugLog.Error(err)
+		return nil, fmt.Errorf("local: %w", err)
+	}
+	_ = level.Debug(logger).Log("msg", "loaded service keys", "development", cfg.Development)
+
+	if err := BuildRateLimit(cfg); err != nil {
+		return nil, fmt.Errorf("rate limit: %w", err)
+	}
+
+	if err := BuildAudit(cfg); err != nil {
+		return nil, fmt.Errorf("audit: %w", err)
+	}
+
+	if err := BuildEvents(cfg); err != nil {
+		return nil, fmt.Errorf("events: %w", err)
+	}
+
+	if err := BuildCache(cfg); err != nil {
+		return nil, fmt.Errorf("cache: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// Check builds the config the way Build does, but carries on past a step that fails so --check-config can
+// report every problem at once, along with whatever Validate finds in what did build
+func Check(logger log.Logger) (*Config, error) {
+	cfg := &Config{}
+	v := &validator{}
+
+	// the same steps as Build and in the same order, vault goes before anything that reads secrets
+	for _, step := range []struct {
+		name  string
+		build func(c *Config) error
+	}{
+		{"parse env", func(c *Config) error { return env.Parse(c) }},
+		{"sources", BuildSources},
+		{"vault", BuildVault},
+		{"mongo", BuildMongo},
+		{"local", BuildLocal},
+		{"rate limit", BuildRateLimit},
+		{"audit", BuildAudit},
+		{"events", BuildEvents},
+		{"cache", BuildCache},
+	} {
+		if err := step.build(cfg); err != nil {
+			_ = level.Debug(logger).Log("msg", "building config", "step", step.name, "err", err)
+			v.add("%s: %s", step.name, err)
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		var invalid *ValidationError
+		if !errors.As(err, &invalid) {
+			return cfg, err
+		}
+		v.problems = append(v.problems, invalid.Problems...)
+	}
+
+	if len(v.problems) > 0 {
+		return cfg, &ValidationError{Problems: v.problems}
 	}
 
 	return cfg, nil
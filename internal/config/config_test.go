@@ -1,10 +1,14 @@
 package config_test
 
 import (
+	"errors"
+	"io"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/caarlos0/env/v6"
+	"github.com/go-kit/log"
 	"github.com/k8sdeploy/key-service/internal/config"
 )
 
@@ -74,3 +78,86 @@ func TestServiceKey(t *testing.T) {
 		})
 	}
 }
+
+func TestBuild_Development(t *testing.T) {
+	tests := []struct {
+		name         string
+		env          map[string]string
+		wantErr      bool
+		wantHooksKey string
+	}{
+		{
+			name: "no vault and no secrets",
+			env: map[string]string{
+				"DEVELOPMENT": "true",
+			},
+		},
+		{
+			name: "service keys from env",
+			env: map[string]string{
+				"DEVELOPMENT":    "true",
+				"CONFIG_SOURCES": "env",
+				"API_KEYS_HOOKS": "dev-hooks",
+			},
+			wantHooksKey: "dev-hooks",
+		},
+		{
+			name:    "production still needs the secrets",
+			env:     map[string]string{},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// nothing listens here, so anything that reads from vault fails
+			t.Setenv("VAULT_ADDRESS", "http://127.0.0.1:1")
+			t.Setenv("VAULT_MAX_RETRIES", "0")
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			c, err := config.Build(log.NewLogfmtLogger(io.Discard))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Build() = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if err := c.Validate(); err != nil {
+				t.Errorf("Validate() = %v", err)
+			}
+			if got := c.ServiceKeys().HooksService.Key; got != tt.wantHooksKey {
+				t.Errorf("hooks key = %q, want %q", got, tt.wantHooksKey)
+			}
+		})
+	}
+}
+
+func TestCheck_ReportsEveryProblem(t *testing.T) {
+	// nothing listens here, so anything that reads from vault fails
+	t.Setenv("VAULT_ADDRESS", "http://127.0.0.1:1")
+	t.Setenv("VAULT_MAX_RETRIES", "0")
+	t.Setenv("CONFIG_SOURCES", "env,bogus")
+	t.Setenv("HTTP_PORT", "3000")
+	t.Setenv("GRPC_PORT", "3000")
+
+	c, err := config.Check(log.NewLogfmtLogger(io.Discard))
+	if c == nil {
+		t.Fatal("Check() config = nil, want what could be built to summarise")
+	}
+	var invalid *config.ValidationError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("Check() = %v, want a ValidationError", err)
+	}
+
+	for _, want := range []string{"sources: unknown config source: bogus", "HTTP_PORT and GRPC_PORT are both 3000"} {
+		found := false
+		for _, problem := range invalid.Problems {
+			found = found || strings.Contains(problem, want)
+		}
+		if !found {
+			t.Errorf("problems = %q, want one about %q", invalid.Problems, want)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package config
+
+import (
+	"time"
+
+	"github.com/caarlos0/env/v6"
+)
+
+// Events is where key lifecycle events go. publishing or watching them writes the outbox in a transaction,
+// which needs mongo to run as a replica set, so both are off unless asked for
+type Events struct {
+	Publisher        string        `env:"EVENTS_PUBLISHER" envDefault:"none"`
+	NATSURL          string        `env:"EVENTS_NATS_URL" envDefault:"nats://nats.nats:4222"`
+	NATSSubject      string        `env:"EVENTS_NATS_SUBJECT" envDefault:"keys"`
+	WebhookURL       string        `env:"EVENTS_WEBHOOK_URL" envDefault:""`
+	WebhookSecret    string        `env:"EVENTS_WEBHOOK_SECRET" envDefault:""`
+	WebhookTimeout   time.Duration `env:"EVENTS_WEBHOOK_TIMEOUT" envDefault:"5s"`
+	OutboxDatabase   string        `env:"EVENTS_OUTBOX_DB" envDefault:"key-service"`
+	OutboxCollection string        `env:"EVENTS_OUTBOX_COLLECTION" envDefault:"outbox"`
+	OutboxRetention  time.Duration `env:"EVENTS_OUTBOX_RETENTION" envDefault:"24h"`
+	RelayInterval    time.Duration `env:"EVENTS_RELAY_INTERVAL" envDefault:"1s"`
+	BatchSize        int           `env:"EVENTS_BATCH_SIZE" envDefault:"100"`
+	MaxAttempts      int           `env:"EVENTS_MAX_ATTEMPTS" envDefault:"25"`
+	Watch            bool          `env:"EVENTS_WATCH" envDefault:"false"`
+}
+
+func BuildEvents(c *Config) error {
+	e := &Events{}
+
+	if err := env.Parse(e); err != nil {
+		return err
+	}
+
+	c.Events = *e
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package config_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/k8sdeploy/key-service/internal/config"
+)
+
+func TestConfig_CheckVault(t *testing.T) {
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/auth/token/lookup-self" || r.Header.Get("X-Vault-Token") != "good-token" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data":{"ttl":3600}}`))
+	}))
+	defer vault.Close()
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{
+			name:  "valid token",
+			token: "good-token",
+		},
+		{
+			name:    "revoked token",
+			token:   "bad-token",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &config.Config{}
+			c.Vault.Address = vault.URL
+			c.Vault.Token = tt.token
+
+			if err := c.CheckVault(context.Background()); (err != nil) != tt.wantErr {
+				t.Errorf("CheckVault() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfig_CheckServiceKeys(t *testing.T) {
+	c := &config.Config{}
+	if err := c.CheckServiceKeys(); err == nil {
+		t.Errorf("CheckServiceKeys() = nil with no keys loaded")
+	}
+
+	c.HooksService.Key = "hooks"
+	c.Orchestrator.Key = "orchestrator"
+	if err := c.CheckServiceKeys(); err != nil {
+		t.Errorf("CheckServiceKeys() = %v, want nil", err)
+	}
+}
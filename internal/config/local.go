@@ -1,11 +1,12 @@
 package config
 
 import (
+	"context"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/caarlos0/env/v6"
-
-	bugLog "github.com/bugfixes/go-bugfixes/logs"
 )
 
 type UserService struct {
@@ -48,6 +49,15 @@ type Local struct {
 	HTTPPort    int  `env:"HTTP_PORT" envDefault:"3000" json:"port,omitempty"`
 	GRPCPort    int  `env:"GRPC_PORT" envDefault:"8001" json:"grpc_port,omitempty"`
 
+	SignatureWindow    time.Duration `env:"SIGNATURE_WINDOW" envDefault:"5m" json:"signature_window,omitempty"`
+	ActiveKeysInterval time.Duration `env:"ACTIVE_KEYS_INTERVAL" envDefault:"1m" json:"active_keys_interval,omitempty"`
+	KeyLifetime        time.Duration `env:"KEY_LIFETIME" envDefault:"0s" json:"key_lifetime,omitempty"`
+	KeyExpiryInterval  time.Duration `env:"KEY_EXPIRY_INTERVAL" envDefault:"1m" json:"key_expiry_interval,omitempty"`
+	ShutdownDelay      time.Duration `env:"SHUTDOWN_DELAY" envDefault:"5s" json:"shutdown_delay,omitempty"`
+	ShutdownTimeout    time.Duration `env:"SHUTDOWN_TIMEOUT" envDefault:"25s" json:"shutdown_timeout,omitempty"`
+	HealthInterval     time.Duration `env:"HEALTH_INTERVAL" envDefault:"5s" json:"health_interval,omitempty"`
+	HealthTimeout      time.Duration `env:"HEALTH_TIMEOUT" envDefault:"2s" json:"health_timeout,omitempty"`
+
 	OnePasswordKey  string `env:"ONE_PASSWORD_KEY" json:"one_password_key,omitempty"`
 	OnePasswordPath string `env:"ONE_PASSWORD_PATH" json:"one_password_path,omitempty"`
 
@@ -62,44 +72,114 @@ func BuildLocal(cfg *Config) error {
 	cfg.Local = *local
 
 	if err := BuildServiceKeys(cfg); err != nil {
-		return bugLog.Errorf("failed to build service keys: %s", err.Error())
+		return fmt.Errorf("failed to build service keys: %w", err)
 	}
 
 	return nil
 }
 
-// nolint:gocyclo
+// CheckServiceKeys makes sure the keys of the services that call us have been loaded
+func (c *Config) CheckServiceKeys() error {
+	services := c.ServiceKeys()
+	var missing []string
+	if services.HooksService.Key == "" {
+		missing = append(missing, "hooks")
+	}
+	if services.Orchestrator.Key == "" {
+		missing = append(missing, "orchestrator")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("service keys not loaded: %s", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// BuildServiceKeys loads the keys of the services that call us. development doesn't need them to start, it
+// only refuses calls until they are set, e.g. with CONFIG_SOURCES=env and API_KEYS_HOOKS
 func BuildServiceKeys(cfg *Config) error {
-	vaultSecrets, err := cfg.getVaultSecrets("kv/data/k8sdeploy/api-keys")
+	values, err := cfg.Source().Values(context.Background(), ServiceKeysSecret)
+	if err == nil && len(values) == 0 {
+		err = fmt.Errorf("api keys not found in %s", cfg.Source().Name())
+	}
 	if err != nil {
+		if cfg.Development {
+			return nil
+		}
 		return err
 	}
 
-	if vaultSecrets == nil {
-		return fmt.Errorf("api keys not found in vault")
-	}
+	_, err = cfg.reloadServiceKeys(values)
+	return err
+}
 
-	secrets, err := ParseKVSecrets(vaultSecrets)
-	if err != nil {
-		return err
+// ServiceKeys are the keys of the services that call us, WatchSecrets can swap them at any time
+func (c *Config) ServiceKeys() Services {
+	c.secrets.RLock()
+	defer c.secrets.RUnlock()
+
+	return c.Local.Services
+}
+
+// reloadServiceKeys swaps in the keys, returning the names of the ones that changed
+func (c *Config) reloadServiceKeys(values map[string]string) ([]string, error) {
+	c.secrets.Lock()
+	defer c.secrets.Unlock()
+
+	// a key missing from the secret has been deleted, so only the addresses carry over and never an old key
+	old := c.Local.Services
+	services := Services{
+		UserService:       UserService{Address: old.UserService.Address},
+		CompanyService:    CompanyService{Address: old.CompanyService.Address},
+		HooksService:      HooksService{Address: old.HooksService.Address},
+		BillingService:    BillingService{Address: old.BillingService.Address},
+		PermissionService: PermissionService{Address: old.PermissionService.Address},
+		Orchestrator:      Orchestrator{Address: old.Orchestrator.Address},
 	}
+	setServiceKeys(&services, values)
+	changed := changedServiceKeys(old, services)
+	c.Local.Services = services
+
+	return changed, nil
+}
 
-	for _, secret := range secrets {
-		switch secret.Key {
+// nolint:gocyclo
+func setServiceKeys(services *Services, values map[string]string) {
+	for key, value := range values {
+		switch key {
 		case "hooks":
-			cfg.Local.Services.HooksService.Key = secret.Value
+			services.HooksService.Key = value
 		case "user":
-			cfg.Local.Services.UserService.Key = secret.Value
+			services.UserService.Key = value
 		case "company":
-			cfg.Local.Services.CompanyService.Key = secret.Value
+			services.CompanyService.Key = value
 		case "billing":
-			cfg.Local.Services.BillingService.Key = secret.Value
+			services.BillingService.Key = value
 		case "permission":
-			cfg.Local.Services.PermissionService.Key = secret.Value
+			services.PermissionService.Key = value
 		case "orchestrator":
-			cfg.Local.Services.Orchestrator.Key = secret.Value
+			services.Orchestrator.Key = value
 		}
 	}
+}
 
-	return nil
+func changedServiceKeys(old, new Services) []string {
+	var changed []string
+	for _, k := range []struct {
+		name     string
+		old, new string
+	}{
+		{"hooks", old.HooksService.Key, new.HooksService.Key},
+		{"user", old.UserService.Key, new.UserService.Key},
+		{"company", old.CompanyService.Key, new.CompanyService.Key},
+		{"billing", old.BillingService.Key, new.BillingService.Key},
+		{"permission", old.PermissionService.Key, new.PermissionService.Key},
+		{"orchestrator", old.Orchestrator.Key, new.Orchestrator.Key},
+	} {
+		if k.old != k.new {
+			changed = append(changed, k.name)
+		}
+	}
+
+	return changed
 }
@@ -0,0 +1,130 @@
+package config_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/k8sdeploy/key-service/internal/config"
+)
+
+func TestBuildMongo_Connection(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    config.MongoConnection
+		wantErr bool
+	}{
+		{
+			name: "nothing set keeps the srv connection",
+			env: map[string]string{
+				"MONGODB_HOSTNAME": "cluster.example.com",
+			},
+		},
+		{
+			name: "structured options",
+			env: map[string]string{
+				"MONGODB_HOSTNAME":                 "mongo-0:27017,mongo-1:27017",
+				"MONGODB_SCHEME":                   "mongodb",
+				"MONGODB_AUTH_SOURCE":              "admin",
+				"MONGODB_REPLICA_SET":              "rs0",
+				"MONGODB_TLS":                      "true",
+				"MONGODB_TLS_CA_FILE":              "/etc/mongo/ca.pem",
+				"MONGODB_READ_PREFERENCE":          "secondaryPreferred",
+				"MONGODB_CONNECT_TIMEOUT":          "5s",
+				"MONGODB_SERVER_SELECTION_TIMEOUT": "10s",
+			},
+			want: config.MongoConnection{
+				Scheme:                 "mongodb",
+				AuthSource:             "admin",
+				ReplicaSet:             "rs0",
+				TLS:                    true,
+				TLSCAFile:              "/etc/mongo/ca.pem",
+				ReadPreference:         "secondaryPreferred",
+				ConnectTimeout:         5 * time.Second,
+				ServerSelectionTimeout: 10 * time.Second,
+			},
+		},
+		{
+			name: "bad timeout",
+			env: map[string]string{
+				"MONGODB_HOSTNAME":        "mongo",
+				"MONGODB_CONNECT_TIMEOUT": "soon",
+			},
+			wantErr: true,
+		},
+		{
+			name: "bad tls flag",
+			env: map[string]string{
+				"MONGODB_HOSTNAME": "mongo",
+				"MONGODB_TLS":      "maybe",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CONFIG_SOURCES", "env")
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			c := &config.Config{}
+			if err := config.BuildSources(c); err != nil {
+				t.Fatal(err)
+			}
+			err := config.BuildMongo(c)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("BuildMongo() = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := c.MongoConfig().Connection; got != tt.want {
+				t.Errorf("connection = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildMongo_Legacy(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want config.DB
+	}{
+		{
+			name: "where the bundles have always been",
+			want: config.DB{Database: "keys", KeysCollection: "keys"},
+		},
+		{
+			name: "moved",
+			env: map[string]string{
+				"MONGODB_LEGACY_DB":              "bundles",
+				"MONGODB_LEGACY_KEYS_COLLECTION": "service_keys",
+			},
+			want: config.DB{Database: "bundles", KeysCollection: "service_keys"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CONFIG_SOURCES", "env")
+			t.Setenv("MONGODB_HOSTNAME", "mongo")
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			c := &config.Config{}
+			if err := config.BuildSources(c); err != nil {
+				t.Fatal(err)
+			}
+			if err := config.BuildMongo(c); err != nil {
+				t.Fatalf("BuildMongo() = %v", err)
+			}
+			if got := c.MongoConfig().Legacy; got != tt.want {
+				t.Errorf("legacy = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
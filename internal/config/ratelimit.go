@@ -0,0 +1,34 @@
+package config
+
+import (
+	"time"
+
+	"github.com/caarlos0/env/v6"
+)
+
+type RateLimit struct {
+	Backend         string        `env:"RATE_LIMIT_BACKEND" envDefault:"memory"`
+	CompanyRequests int           `env:"RATE_LIMIT_COMPANY_REQUESTS" envDefault:"600"`
+	KeyRequests     int           `env:"RATE_LIMIT_KEY_REQUESTS" envDefault:"300"`
+	Window          time.Duration `env:"RATE_LIMIT_WINDOW" envDefault:"1m"`
+
+	MaxFailures  int           `env:"RATE_LIMIT_MAX_FAILURES" envDefault:"5"`
+	FailureTTL   time.Duration `env:"RATE_LIMIT_FAILURE_TTL" envDefault:"15m"`
+	LockoutBase  time.Duration `env:"RATE_LIMIT_LOCKOUT" envDefault:"30s"`
+	LockoutLimit time.Duration `env:"RATE_LIMIT_LOCKOUT_MAX" envDefault:"1h"`
+
+	Database   string `env:"RATE_LIMIT_DB" envDefault:"key-service"`
+	Collection string `env:"RATE_LIMIT_COLLECTION" envDefault:"rate_limits"`
+}
+
+func BuildRateLimit(c *Config) error {
+	r := &RateLimit{}
+
+	if err := env.Parse(r); err != nil {
+		return err
+	}
+
+	c.RateLimit = *r
+
+	return nil
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// reloadable is a secret that is read again while running
+type reloadable struct {
+	secret string
+	reload func(values map[string]string) ([]string, error)
+}
+
+func (c *Config) reloadables() []reloadable {
+	r := []reloadable{
+		{secret: ServiceKeysSecret, reload: c.reloadServiceKeys},
+	}
+	// development never connects to mongo, so there is nothing to reload
+	if !c.Development {
+		r = append(r, reloadable{secret: MongoSecret, reload: c.reloadMongo})
+	}
+
+	return r
+}
+
+// WatchSecrets reads the service keys and mongo details from their source again whenever their version
+// changes, so rotating one doesn't need a restart. a change to the mongo details closes MongoChanged, which
+// the connected store waits on to reopen its shared client with them
+func (c *Config) WatchSecrets(ctx context.Context, logger log.Logger) {
+	if c.Vault.ReloadInterval <= 0 {
+		return
+	}
+
+	// nothing is known about versions yet, so the first check reads everything once
+	versions := make(map[string]int64)
+	ticker := time.NewTicker(c.Vault.ReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, r := range c.reloadables() {
+			c.reloadSecret(ctx, r, versions, logger)
+		}
+	}
+}
+
+// reloadSecret only reads the secret when its version moved, if the version can't be read it is read
+// every time and only swapped if it differs
+func (c *Config) reloadSecret(ctx context.Context, r reloadable, versions map[string]int64, logger log.Logger) {
+	source := c.Source()
+	version, versionErr := source.Version(ctx, r.secret)
+	if versionErr == nil && version == versions[r.secret] {
+		return
+	}
+
+	values, err := source.Values(ctx, r.secret)
+	if err != nil {
+		_ = level.Warn(logger).Log("msg", "reloading secret", "secret", r.secret, "err", err)
+		return
+	}
+	changed, err := r.reload(values)
+	if err != nil {
+		_ = level.Warn(logger).Log("msg", "reloading secret", "secret", r.secret, "err", err)
+		return
+	}
+	if versionErr == nil {
+		versions[r.secret] = version
+	}
+
+	if len(changed) > 0 {
+		_ = level.Info(logger).Log("msg", "reloaded secret", "secret", r.secret, "source", source.Name(), "version", version, "changed", strings.Join(changed, ","))
+	}
+}
@@ -0,0 +1,214 @@
+package config_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/k8sdeploy/key-service/internal/config"
+	"github.com/k8sdeploy/key-service/internal/key"
+)
+
+// kvVault serves the api keys and mongo kv v2 secrets, with or without their metadata
+type kvVault struct {
+	mu       sync.Mutex
+	version  int
+	hooksKey string
+	password string
+	metadata bool
+}
+
+func (v *kvVault) rotate(hooksKey, password string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.version++
+	v.hooksKey = hooksKey
+	v.password = password
+}
+
+func (v *kvVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	switch r.URL.Path {
+	case "/v1/kv/metadata/k8sdeploy/api-keys", "/v1/kv/metadata/k8sdeploy/key-service/mongodb":
+		if !v.metadata {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		_, _ = fmt.Fprintf(w, `{"data":{"current_version":%d}}`, v.version)
+	case "/v1/kv/data/k8sdeploy/api-keys":
+		// an empty hooks key is one deleted from the secret
+		hooks := ""
+		if v.hooksKey != "" {
+			hooks = fmt.Sprintf(`"hooks":%q,`, v.hooksKey)
+		}
+		_, _ = fmt.Fprintf(w, `{"data":{"data":{%s"orchestrator":"orchestrator-key"},"metadata":{"version":%d}}}`, hooks, v.version)
+	case "/v1/kv/data/k8sdeploy/key-service/mongodb":
+		_, _ = fmt.Fprintf(w, `{"data":{"data":{"username":"key-service","password":%q,"hostname":"mongo"},"metadata":{"version":%d}}}`, v.password, v.version)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
+
+func TestConfig_WatchSecrets(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata bool
+	}{
+		{
+			name:     "version change",
+			metadata: true,
+		},
+		{
+			name: "no metadata access",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vault := &kvVault{version: 1, hooksKey: "hooks-key-1", password: "password-1", metadata: tt.metadata}
+			server := httptest.NewServer(vault)
+			defer server.Close()
+
+			c := &config.Config{}
+			c.Vault.Address = server.URL
+			c.Vault.Token = "token"
+			c.Vault.ReloadInterval = 10 * time.Millisecond
+			if err := config.BuildServiceKeys(c); err != nil {
+				t.Fatalf("BuildServiceKeys() = %v", err)
+			}
+			if err := config.BuildMongo(c); err != nil {
+				t.Fatalf("BuildMongo() = %v", err)
+			}
+			mongoChanged := c.MongoChanged()
+			if got := c.ServiceKeys().HooksService.Key; got != "hooks-key-1" {
+				t.Fatalf("hooks key = %q, want hooks-key-1", got)
+			}
+
+			logs := &syncBuffer{}
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				c.WatchSecrets(ctx, log.NewLogfmtLogger(logs))
+				close(done)
+			}()
+			defer func() {
+				cancel()
+				<-done
+			}()
+
+			vault.rotate("hooks-key-2", "password-2")
+			deadline := time.Now().Add(5 * time.Second)
+			for c.ServiceKeys().HooksService.Key != "hooks-key-2" {
+				if time.Now().After(deadline) {
+					t.Fatalf("hooks key was never reloaded")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			if got := c.ServiceKeys().Orchestrator.Key; got != "orchestrator-key" {
+				t.Errorf("orchestrator key = %q, want it kept", got)
+			}
+			select {
+			case <-mongoChanged:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("mongo credentials were never reloaded")
+			}
+			if got := c.MongoConfig().Password; got != "password-2" {
+				t.Errorf("mongo password = %q, want password-2", got)
+			}
+
+			cancel()
+			<-done
+			out := logs.String()
+			if !strings.Contains(out, "changed=hooks") {
+				t.Errorf("logs = %q, want the reload logged", out)
+			}
+			if !strings.Contains(out, "changed=password") {
+				t.Errorf("logs = %q, want the mongo reload logged", out)
+			}
+			if strings.Contains(out, "hooks-key") || strings.Contains(out, "password-") {
+				t.Errorf("logs = %q, want no key values", out)
+			}
+		})
+	}
+}
+
+func TestConfig_WatchSecrets_DeletedKey(t *testing.T) {
+	vault := &kvVault{version: 1, hooksKey: "hooks-key-1", password: "password-1", metadata: true}
+	server := httptest.NewServer(vault)
+	defer server.Close()
+
+	c := &config.Config{}
+	c.Vault.Address = server.URL
+	c.Vault.Token = "token"
+	c.Vault.ReloadInterval = 10 * time.Millisecond
+	c.HooksService.Address = "https://hooks-service"
+	if err := config.BuildServiceKeys(c); err != nil {
+		t.Fatalf("BuildServiceKeys() = %v", err)
+	}
+	if err := c.CheckServiceKeys(); err != nil {
+		t.Fatalf("CheckServiceKeys() = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.WatchSecrets(ctx, log.NewNopLogger())
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	vault.rotate("", "password-1")
+	deadline := time.Now().Add(5 * time.Second)
+	for c.ServiceKeys().HooksService.Key != "" {
+		if time.Now().After(deadline) {
+			t.Fatalf("deleted hooks key was never dropped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := c.CheckServiceKeys(); err == nil {
+		t.Errorf("CheckServiceKeys() = nil, want the deleted hooks key reported")
+	}
+	if got := (&key.Server{Config: c}).Principal("hooks-key-1"); got != "" {
+		t.Errorf("Principal(deleted key) = %q, want it rejected", got)
+	}
+	if got := c.ServiceKeys().HooksService.Address; got != "https://hooks-service" {
+		t.Errorf("hooks address = %q, want it kept", got)
+	}
+	if got := c.ServiceKeys().Orchestrator.Key; got != "orchestrator-key" {
+		t.Errorf("orchestrator key = %q, want it kept", got)
+	}
+}
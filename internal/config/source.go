@@ -0,0 +1,298 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/caarlos0/env/v6"
+	"gopkg.in/yaml.v3"
+)
+
+// Secrets the service reads, every source uses the same keys inside them, e.g. username, hostname,
+// hooks_db, agent_keys_collection for mongodb, hooks, orchestrator for api-keys and hmac_key for audit
+const (
+	ServiceKeysSecret = "api-keys"
+	MongoSecret       = "mongodb"
+	AuditSecret       = "audit"
+)
+
+// Source names
+const (
+	SourceVault = "vault"
+	SourceEnv   = "env"
+	SourceFile  = "file"
+)
+
+// Source is somewhere the secrets come from
+type Source interface {
+	Name() string
+	// Values are the keys set in the secret, a source that doesn't have the secret returns none
+	Values(ctx context.Context, secret string) (map[string]string, error)
+	// Version changes whenever the secret does
+	Version(ctx context.Context, secret string) (int64, error)
+}
+
+type Sources struct {
+	// highest precedence first
+	Names []string `env:"CONFIG_SOURCES" envDefault:"env,vault" envSeparator:","`
+	File  string   `env:"CONFIG_FILE" envDefault:""`
+}
+
+func BuildSources(c *Config) error {
+	s := &Sources{}
+	if err := env.Parse(s); err != nil {
+		return err
+	}
+	c.Sources = *s
+
+	var layers []Source
+	for _, name := range s.Names {
+		switch strings.TrimSpace(name) {
+		case SourceVault:
+			layers = append(layers, &VaultSource{Config: c})
+		case SourceEnv:
+			layers = append(layers, EnvSource{})
+		case SourceFile:
+			if s.File == "" {
+				return errors.New("file source needs CONFIG_FILE")
+			}
+			layers = append(layers, FileSource{Path: s.File})
+		default:
+			return fmt.Errorf("unknown config source: %s", name)
+		}
+	}
+	c.source = Layered(layers)
+
+	return nil
+}
+
+// Source is where the secrets are read from, vault unless BuildSources says otherwise
+func (c *Config) Source() Source {
+	if c.source == nil {
+		return &VaultSource{Config: c}
+	}
+
+	return c.source
+}
+
+// UsesVault is whether any secrets come from vault
+func (c *Config) UsesVault() bool {
+	for _, s := range Layers(c.Source()) {
+		if s.Name() == SourceVault {
+			return true
+		}
+	}
+
+	return false
+}
+
+// VaultSource reads kv secrets from under VAULT_KV_MOUNT/VAULT_KV_PREFIX
+type VaultSource struct {
+	Config *Config
+}
+
+func (v *VaultSource) path(secret string) string {
+	name := secret
+	if secret == MongoSecret || secret == AuditSecret {
+		name = "key-service/" + secret
+	}
+
+	// a config that didn't come from env gets the same default prefix
+	prefix := v.Config.Vault.KVPrefix
+	if prefix == "" {
+		prefix = "k8sdeploy"
+	}
+
+	var parts []string
+	for _, p := range []string{v.Config.Vault.kvMount(), prefix, name} {
+		if p = strings.Trim(p, "/"); p != "" {
+			parts = append(parts, p)
+		}
+	}
+
+	return strings.Join(parts, "/")
+}
+
+func (v *VaultSource) Name() string {
+	return SourceVault
+}
+
+func (v *VaultSource) Values(ctx context.Context, secret string) (map[string]string, error) {
+	var opts []ReadOption
+	if version := v.Config.Vault.KVVersions[secret]; version > 0 {
+		opts = append(opts, WithSecretVersion(version))
+	}
+
+	// a secret that was never written, or whose latest version was deleted, just has no values, like it
+	// would in any other source
+	data, err := v.Config.getVaultSecrets(v.path(secret), opts...)
+	if errors.Is(err, ErrSecretNotFound) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if data["data"] == nil {
+		return map[string]string{}, nil
+	}
+	kvs, err := ParseKVSecrets(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return KVStrings(kvs), nil
+}
+
+// Version is the current version from the secret's metadata, or the version it is pinned to
+func (v *VaultSource) Version(ctx context.Context, secret string) (int64, error) {
+	if version := v.Config.Vault.KVVersions[secret]; version > 0 {
+		return int64(version), nil
+	}
+
+	metadata, err := v.Config.getVaultSecrets(v.path(secret), WithMetadata())
+	if errors.Is(err, ErrSecretNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	switch version := metadata["current_version"].(type) {
+	case json.Number:
+		return version.Int64()
+	case float64:
+		return int64(version), nil
+	}
+
+	return 0, fmt.Errorf("no current_version for %s", secret)
+}
+
+// EnvSource reads SECRET_KEY variables, e.g. MONGODB_HOOKS_DB or API_KEYS_HOOKS
+type EnvSource struct{}
+
+func envPrefix(secret string) string {
+	return strings.ToUpper(strings.ReplaceAll(secret, "-", "_")) + "_"
+}
+
+func (EnvSource) Name() string {
+	return SourceEnv
+}
+
+func (EnvSource) Values(ctx context.Context, secret string) (map[string]string, error) {
+	prefix := envPrefix(secret)
+	values := make(map[string]string)
+	for _, kv := range os.Environ() {
+		k, v, _ := strings.Cut(kv, "=")
+		if strings.HasPrefix(k, prefix) && len(k) > len(prefix) {
+			values[strings.ToLower(strings.TrimPrefix(k, prefix))] = v
+		}
+	}
+
+	return values, nil
+}
+
+// Version is always the same, the environment can't change under a running process
+func (EnvSource) Version(ctx context.Context, secret string) (int64, error) {
+	return 0, nil
+}
+
+// FileSource reads a yaml or json file with a map of keys under each secret name
+type FileSource struct {
+	Path string
+}
+
+func (f FileSource) Name() string {
+	return SourceFile
+}
+
+func (f FileSource) Values(ctx context.Context, secret string) (map[string]string, error) {
+	b, err := os.ReadFile(f.Path)
+	if err != nil {
+		return nil, fmt.Errorf("config file: %w", err)
+	}
+
+	var secrets map[string]map[string]interface{}
+	switch strings.ToLower(filepath.Ext(f.Path)) {
+	case ".json":
+		err = json.Unmarshal(b, &secrets)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &secrets)
+	default:
+		return nil, fmt.Errorf("config file %s isn't yaml or json", f.Path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config file: %w", err)
+	}
+
+	values := make(map[string]string)
+	for k, v := range secrets[secret] {
+		values[k] = fmt.Sprintf("%v", v)
+	}
+
+	return values, nil
+}
+
+// Version is the file's modification time
+func (f FileSource) Version(ctx context.Context, secret string) (int64, error) {
+	info, err := os.Stat(f.Path)
+	if err != nil {
+		return 0, fmt.Errorf("config file: %w", err)
+	}
+
+	return info.ModTime().UnixNano(), nil
+}
+
+// Layered merges sources, a key from an earlier source wins over the same key from a later one
+type Layered []Source
+
+// Layers are the sources inside s
+func Layers(s Source) []Source {
+	if l, ok := s.(Layered); ok {
+		return l
+	}
+
+	return []Source{s}
+}
+
+func (l Layered) Name() string {
+	names := make([]string, 0, len(l))
+	for _, s := range l {
+		names = append(names, s.Name())
+	}
+
+	return strings.Join(names, ",")
+}
+
+func (l Layered) Values(ctx context.Context, secret string) (map[string]string, error) {
+	values := make(map[string]string)
+	for i := len(l) - 1; i >= 0; i-- {
+		layer, err := l[i].Values(ctx, secret)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", l[i].Name(), err)
+		}
+		for k, v := range layer {
+			values[k] = v
+		}
+	}
+
+	return values, nil
+}
+
+// Version adds up the versions of every layer, each of them only goes up
+func (l Layered) Version(ctx context.Context, secret string) (int64, error) {
+	var version int64
+	for _, s := range l {
+		v, err := s.Version(ctx, secret)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", s.Name(), err)
+		}
+		version += v
+	}
+
+	return version, nil
+}
@@ -0,0 +1,205 @@
+package config_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/k8sdeploy/key-service/internal/config"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestSources(t *testing.T) {
+	yamlFile := writeFile(t, "config.yaml", `
+mongodb:
+  username: file-user
+  hostname: file-host
+  hooks_db: hooks
+api-keys:
+  hooks: file-hooks
+`)
+	jsonFile := writeFile(t, "config.json", `{"mongodb":{"username":"json-user","agent_keys_collection":"agents"}}`)
+
+	tests := []struct {
+		name   string
+		source config.Source
+		env    map[string]string
+		secret string
+		want   map[string]string
+	}{
+		{
+			name:   "env",
+			source: config.EnvSource{},
+			env: map[string]string{
+				"MONGODB_USERNAME": "env-user",
+				"MONGODB_HOOKS_DB": "hooks",
+				"API_KEYS_HOOKS":   "env-hooks",
+			},
+			secret: config.MongoSecret,
+			want: map[string]string{
+				"username": "env-user",
+				"hooks_db": "hooks",
+			},
+		},
+		{
+			name:   "env with a dash in the secret",
+			source: config.EnvSource{},
+			env: map[string]string{
+				"API_KEYS_HOOKS": "env-hooks",
+			},
+			secret: config.ServiceKeysSecret,
+			want: map[string]string{
+				"hooks": "env-hooks",
+			},
+		},
+		{
+			name:   "yaml file",
+			source: config.FileSource{Path: yamlFile},
+			secret: config.MongoSecret,
+			want: map[string]string{
+				"username": "file-user",
+				"hostname": "file-host",
+				"hooks_db": "hooks",
+			},
+		},
+		{
+			name:   "json file",
+			source: config.FileSource{Path: jsonFile},
+			secret: config.MongoSecret,
+			want: map[string]string{
+				"username":              "json-user",
+				"agent_keys_collection": "agents",
+			},
+		},
+		{
+			name:   "missing secret",
+			source: config.FileSource{Path: jsonFile},
+			secret: config.ServiceKeysSecret,
+			want:   map[string]string{},
+		},
+		{
+			name:   "earlier layers win",
+			source: config.Layered{config.EnvSource{}, config.FileSource{Path: yamlFile}},
+			env: map[string]string{
+				"MONGODB_USERNAME": "env-user",
+			},
+			secret: config.MongoSecret,
+			want: map[string]string{
+				"username": "env-user",
+				"hostname": "file-host",
+				"hooks_db": "hooks",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			got, err := tt.source.Values(context.Background(), tt.secret)
+			if err != nil {
+				t.Fatalf("Values() = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Values() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildSources(t *testing.T) {
+	file := writeFile(t, "config.yml", `
+mongodb:
+  username: file-user
+  hostname: file-host
+api-keys:
+  hooks: file-hooks
+  orchestrator: file-orchestrator
+`)
+
+	tests := []struct {
+		name          string
+		env           map[string]string
+		wantErr       bool
+		wantVault     bool
+		wantHost      string
+		wantHooksKey  string
+		wantMongoUser string
+	}{
+		{
+			name: "env and file without vault",
+			env: map[string]string{
+				"CONFIG_SOURCES":   "env,file",
+				"CONFIG_FILE":      file,
+				"MONGODB_USERNAME": "env-user",
+			},
+			wantHost:      "file-host",
+			wantHooksKey:  "file-hooks",
+			wantMongoUser: "env-user",
+		},
+		{
+			name: "file without a path",
+			env: map[string]string{
+				"CONFIG_SOURCES": "file",
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown source",
+			env: map[string]string{
+				"CONFIG_SOURCES": "consul",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			c := &config.Config{}
+			err := config.BuildSources(c)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("BuildSources() = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if c.UsesVault() != tt.wantVault {
+				t.Errorf("UsesVault() = %v, want %v", c.UsesVault(), tt.wantVault)
+			}
+
+			if err := config.BuildMongo(c); err != nil {
+				t.Fatalf("BuildMongo() = %v", err)
+			}
+			if err := config.BuildServiceKeys(c); err != nil {
+				t.Fatalf("BuildServiceKeys() = %v", err)
+			}
+			if got := c.MongoConfig().Host; got != tt.wantHost {
+				t.Errorf("mongo host = %q, want %q", got, tt.wantHost)
+			}
+			if got := c.MongoConfig().Username; got != tt.wantMongoUser {
+				t.Errorf("mongo username = %q, want %q", got, tt.wantMongoUser)
+			}
+			if got := c.ServiceKeys().HooksService.Key; got != tt.wantHooksKey {
+				t.Errorf("hooks key = %q, want %q", got, tt.wantHooksKey)
+			}
+		})
+	}
+}
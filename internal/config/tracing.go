@@ -0,0 +1,22 @@
+package config
+
+import (
+	"github.com/caarlos0/env/v6"
+)
+
+// Tracing uses the standard OTEL_ variables, tracing is exported when an otlp endpoint is set
+type Tracing struct {
+	Endpoint    string  `env:"OTEL_EXPORTER_OTLP_ENDPOINT" envDefault:""`
+	Insecure    bool    `env:"OTEL_EXPORTER_OTLP_INSECURE" envDefault:"false"`
+	SampleRatio float64 `env:"OTEL_TRACES_SAMPLER_ARG" envDefault:"1"`
+}
+
+func BuildTracing() (*Tracing, error) {
+	t := &Tracing{}
+
+	if err := env.Parse(t); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
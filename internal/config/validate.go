@@ -0,0 +1,312 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// ValidationError lists everything wrong with the config, so it can all be fixed in one go
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid config: %s", strings.Join(e.Problems, "; "))
+}
+
+type validator struct {
+	problems []string
+}
+
+func (v *validator) add(format string, args ...interface{}) {
+	v.problems = append(v.problems, fmt.Sprintf(format, args...))
+}
+
+func (v *validator) required(name, value string) {
+	if strings.TrimSpace(value) == "" {
+		v.add("%s is not set", name)
+	}
+}
+
+func (v *validator) oneOf(name, value string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	v.add("%s is %q, want one of %s", name, value, strings.Join(allowed, ", "))
+}
+
+func (v *validator) positive(name string, d time.Duration) {
+	if d <= 0 {
+		v.add("%s is %s, want more than 0", name, d)
+	}
+}
+
+func (v *validator) port(name string, port int) {
+	if port < 1 || port > 65535 {
+		v.add("%s is %d, want 1-65535", name, port)
+	}
+}
+
+// Validate checks everything the service needs before it starts, rather than it failing on the first
+// request that needs a missing value
+func (c *Config) Validate() error {
+	v := &validator{}
+
+	v.port("HTTP_PORT", c.HTTPPort)
+	v.port("GRPC_PORT", c.GRPCPort)
+	if c.HTTPPort == c.GRPCPort {
+		v.add("HTTP_PORT and GRPC_PORT are both %d", c.HTTPPort)
+	}
+	v.positive("SIGNATURE_WINDOW", c.SignatureWindow)
+	v.positive("ACTIVE_KEYS_INTERVAL", c.ActiveKeysInterval)
+	v.positive("HEALTH_INTERVAL", c.HealthInterval)
+	v.positive("HEALTH_TIMEOUT", c.HealthTimeout)
+	v.positive("SHUTDOWN_TIMEOUT", c.ShutdownTimeout)
+	if c.KeyLifetime < 0 {
+		v.add("KEY_LIFETIME is %s, want 0 for keys that don't expire or more", c.KeyLifetime)
+	}
+	if c.KeyLifetime > 0 {
+		v.positive("KEY_EXPIRY_INTERVAL", c.KeyExpiryInterval)
+	}
+
+	// development keeps everything in memory, so there is nothing to connect to or be called by, and
+	// nothing it can't start without in vault
+	if c.UsesVault() && !c.Development {
+		c.validateVault(v)
+	}
+	if !c.Development {
+		c.validateMongo(v)
+
+		services := c.ServiceKeys()
+		v.required(ServiceKeysSecret+".hooks", services.HooksService.Key)
+		v.required(ServiceKeysSecret+".orchestrator", services.Orchestrator.Key)
+		v.required(AuditSecret+".hmac_key", c.Audit.HMACKey)
+	}
+
+	c.validateBackends(v)
+
+	if len(v.problems) > 0 {
+		return &ValidationError{Problems: v.problems}
+	}
+
+	return nil
+}
+
+func (c *Config) validateVault(v *validator) {
+	v.required("VAULT_ADDRESS", c.Vault.Address)
+	v.oneOf("VAULT_AUTH_METHOD", c.Vault.AuthMethod, VaultAuthToken, VaultAuthKubernetes, VaultAuthAppRole)
+	if c.Vault.KVVersion != 0 && c.Vault.KVVersion != 1 && c.Vault.KVVersion != 2 {
+		v.add("VAULT_KV_VERSION is %d, want 1, 2 or 0 to ask vault", c.Vault.KVVersion)
+	}
+	switch c.Vault.AuthMethod {
+	case VaultAuthToken:
+		v.required("VAULT_TOKEN", c.Vault.Token)
+	case VaultAuthKubernetes:
+		v.required("VAULT_ROLE", c.Vault.Role)
+	case VaultAuthAppRole:
+		v.required("VAULT_ROLE_ID", c.Vault.RoleID)
+		v.required("VAULT_SECRET_ID", c.Vault.SecretID)
+	}
+}
+
+func (c *Config) validateMongo(v *validator) {
+	m := c.MongoConfig()
+	conn := m.Connection
+
+	v.positive("MONGO_QUERY_TIMEOUT", m.QueryTimeout)
+	v.positive("MONGO_WRITE_TIMEOUT", m.WriteTimeout)
+
+	// a uri carries its own hosts and credentials, x509 needs neither a username nor a password
+	if conn.URI == "" {
+		v.required(MongoSecret+".hostname", m.Host)
+		if conn.AuthMechanism != "MONGODB-X509" {
+			v.required(MongoSecret+".username", m.Username)
+			v.required(MongoSecret+".password", m.Password)
+		}
+	}
+	if conn.Scheme != "" {
+		v.oneOf(MongoSecret+".scheme", conn.Scheme, "mongodb+srv", "mongodb")
+	}
+	if conn.ReadPreference != "" {
+		v.oneOf(MongoSecret+".read_preference", conn.ReadPreference,
+			"primary", "primaryPreferred", "secondary", "secondaryPreferred", "nearest")
+	}
+	if conn.AuthMechanism == "MONGODB-X509" {
+		v.required(MongoSecret+".tls_certificate_key_file", conn.TLSCertificateKeyFile)
+	}
+	for _, f := range []struct {
+		key  string
+		file string
+	}{
+		{"tls_ca_file", conn.TLSCAFile},
+		{"tls_certificate_key_file", conn.TLSCertificateKeyFile},
+	} {
+		if f.file == "" {
+			continue
+		}
+		if _, err := os.Stat(f.file); err != nil {
+			v.add("%s.%s can't be read: %s", MongoSecret, f.key, err)
+		}
+	}
+
+	for _, f := range []struct {
+		key   string
+		value string
+	}{
+		{"user_db", m.User.Database},
+		{"user_keys_collection", m.User.KeysCollection},
+		{"hooks_db", m.Hooks.Database},
+		{"hooks_keys_collection", m.Hooks.KeysCollection},
+		{"agent_db", m.Agent.Database},
+		{"agent_keys_collection", m.Agent.KeysCollection},
+	} {
+		v.required(MongoSecret+"."+f.key, f.value)
+	}
+}
+
+func (c *Config) validateBackends(v *validator) {
+	v.oneOf("RATE_LIMIT_BACKEND", c.RateLimit.Backend, "memory", "mongo")
+	v.positive("RATE_LIMIT_WINDOW", c.RateLimit.Window)
+	v.oneOf("AUDIT_BACKEND", c.Audit.Backend, "mongo", "memory")
+
+	v.oneOf("CACHE_BACKEND", c.Cache.Backend, "none", "memory", "redis")
+	if c.Cache.Backend != "none" {
+		v.positive("CACHE_TTL", c.Cache.TTL)
+	}
+	if c.Cache.Backend == "redis" {
+		v.required("CACHE_REDIS_ADDRESS", c.Cache.RedisAddress)
+	}
+
+	v.oneOf("EVENTS_PUBLISHER", c.Events.Publisher, "none", "nats", "webhook", "memory")
+	switch c.Events.Publisher {
+	case "nats":
+		v.required("EVENTS_NATS_URL", c.Events.NATSURL)
+	case "webhook":
+		v.required("EVENTS_WEBHOOK_URL", c.Events.WebhookURL)
+		v.required("EVENTS_WEBHOOK_SECRET", c.Events.WebhookSecret)
+	}
+	if c.Events.Publisher != "none" {
+		v.positive("EVENTS_RELAY_INTERVAL", c.Events.RelayInterval)
+	}
+}
+
+// redactURI keeps everything but the password, which mongo uris can carry
+func redactURI(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return redact(uri)
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "redacted")
+	}
+
+	return u.String()
+}
+
+// redactURL keeps where a url goes but not the credentials it can carry in its userinfo or query
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return redact(raw)
+	}
+	if u.User != nil {
+		u.User = url.User("redacted")
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = "redacted"
+	}
+
+	return u.String()
+}
+
+func redact(value string) string {
+	if value == "" {
+		return ""
+	}
+
+	return "[redacted]"
+}
+
+// Summary is the effective config with every secret redacted, for --check-config
+func (c *Config) Summary() map[string]map[string]interface{} {
+	m := c.MongoConfig()
+	services := c.ServiceKeys()
+
+	return map[string]map[string]interface{}{
+		"local": {
+			"development":      c.Development,
+			"http_port":        c.HTTPPort,
+			"grpc_port":        c.GRPCPort,
+			"signature_window": c.SignatureWindow.String(),
+			"key_lifetime":     c.KeyLifetime.String(),
+			"shutdown_timeout": c.ShutdownTimeout.String(),
+		},
+		"sources": {
+			"sources": c.Source().Name(),
+			"file":    c.Sources.File,
+		},
+		"vault": {
+			"address":         c.Vault.Address,
+			"auth_method":     c.Vault.AuthMethod,
+			"token":           redact(c.Vault.Token),
+			"role":            c.Vault.Role,
+			"role_id":         c.Vault.RoleID,
+			"secret_id":       redact(c.Vault.SecretID),
+			"database_role":   c.Vault.DatabaseRole,
+			"kv_mount":        c.Vault.KVMount,
+			"kv_prefix":       c.Vault.KVPrefix,
+			"kv_version":      c.Vault.KVVersion,
+			"reload_interval": c.Vault.ReloadInterval.String(),
+		},
+		MongoSecret: {
+			"hostname":                 m.Host,
+			"username":                 m.Username,
+			"password":                 redact(m.Password),
+			"query_timeout":            m.QueryTimeout.String(),
+			"write_timeout":            m.WriteTimeout.String(),
+			"user_db":                  m.User.Database,
+			"user_keys_collection":     m.User.KeysCollection,
+			"hooks_db":                 m.Hooks.Database,
+			"hooks_keys_collection":    m.Hooks.KeysCollection,
+			"agent_db":                 m.Agent.Database,
+			"agent_keys_collection":    m.Agent.KeysCollection,
+			"legacy_db":                m.Legacy.Database,
+			"legacy_keys_collection":   m.Legacy.KeysCollection,
+			"uri":                      redactURI(m.Connection.URI),
+			"scheme":                   m.Connection.Scheme,
+			"auth_source":              m.Connection.AuthSource,
+			"auth_mechanism":           m.Connection.AuthMechanism,
+			"replica_set":              m.Connection.ReplicaSet,
+			"tls":                      m.Connection.TLS,
+			"tls_ca_file":              m.Connection.TLSCAFile,
+			"tls_certificate_key_file": m.Connection.TLSCertificateKeyFile,
+			"tls_insecure":             m.Connection.TLSInsecure,
+			"read_preference":          m.Connection.ReadPreference,
+			"connect_timeout":          m.Connection.ConnectTimeout.String(),
+			"server_selection_timeout": m.Connection.ServerSelectionTimeout.String(),
+		},
+		ServiceKeysSecret: {
+			"hooks":        redact(services.HooksService.Key),
+			"user":         redact(services.UserService.Key),
+			"company":      redact(services.CompanyService.Key),
+			"billing":      redact(services.BillingService.Key),
+			"permission":   redact(services.PermissionService.Key),
+			"orchestrator": redact(services.Orchestrator.Key),
+		},
+		"backends": {
+			"rate_limit":       c.RateLimit.Backend,
+			"audit":            c.Audit.Backend,
+			"audit_hmac_key":   redact(c.Audit.HMACKey),
+			"cache":            c.Cache.Backend,
+			"cache_redis_auth": redact(c.Cache.RedisPassword),
+			"events":           c.Events.Publisher,
+			"webhook_url":      redactURL(c.Events.WebhookURL),
+			"webhook_secret":   redact(c.Events.WebhookSecret),
+		},
+	}
+}
@@ -1,15 +1,47 @@
 package config
 
 import (
+	"context"
 	"fmt"
+	"time"
 
 	"github.com/caarlos0/env/v6"
 	vaultAPI "github.com/hashicorp/vault/api"
+	"github.com/k8sdeploy/key-service/internal/metrics"
+	"github.com/k8sdeploy/key-service/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type Vault struct {
-	Address string `env:"VAULT_ADDRESS" envDefault:"http://vault.vault:8200"`
-	Token   string `env:"VAULT_TOKEN" envDefault:""`
+	Address    string        `env:"VAULT_ADDRESS" envDefault:"http://vault.vault:8200"`
+	Token      string        `env:"VAULT_TOKEN" envDefault:""`
+	AuthMethod string        `env:"VAULT_AUTH_METHOD" envDefault:"token"`
+	AuthMount  string        `env:"VAULT_AUTH_MOUNT" envDefault:""`
+	LoginRetry time.Duration `env:"VAULT_LOGIN_RETRY" envDefault:"5s"`
+
+	// secrets are read from <mount>/<prefix>/<secret>, the kv version is asked for when it isn't set,
+	// versions pins secrets to an older version e.g. api-keys:3
+	KVMount    string         `env:"VAULT_KV_MOUNT" envDefault:"kv"`
+	KVPrefix   string         `env:"VAULT_KV_PREFIX" envDefault:"k8sdeploy"`
+	KVVersion  int            `env:"VAULT_KV_VERSION" envDefault:"0"`
+	KVVersions map[string]int `env:"VAULT_KV_SECRET_VERSIONS" envDefault:""`
+
+	// how often the kv versions of the secrets we read are checked, 0 turns reloading off
+	ReloadInterval time.Duration `env:"VAULT_RELOAD_INTERVAL" envDefault:"1m"`
+
+	// kubernetes auth
+	Role                    string `env:"VAULT_ROLE" envDefault:""`
+	ServiceAccountTokenPath string `env:"VAULT_SERVICE_ACCOUNT_TOKEN_PATH" envDefault:"/var/run/secrets/kubernetes.io/serviceaccount/token"`
+
+	// approle auth
+	RoleID   string `env:"VAULT_ROLE_ID" envDefault:""`
+	SecretID string `env:"VAULT_SECRET_ID" envDefault:""`
+
+	// database secrets engine, with no role the mongo username and password come from kv
+	DatabaseRole  string `env:"VAULT_DATABASE_ROLE" envDefault:""`
+	DatabaseMount string `env:"VAULT_DATABASE_MOUNT" envDefault:"database"`
+
+	session *vaultSession
 }
 
 type KVSecret struct {
@@ -21,9 +53,17 @@ type KVSecretData struct {
 	Data map[string]interface{} `json:"data"`
 }
 
-func GetVaultSecrets(vaultAddress, vaultToken, secretPath string) (map[string]interface{}, error) {
+// GetVaultSecrets reads a kv secret from either kv version, always returning it in the kv v2 shape with
+// the values under data. the path is the mount followed by the secret, e.g. kv/k8sdeploy/api-keys, an
+// explicit kv/data/... path still works
+func GetVaultSecrets(vaultAddress, vaultToken, secretPath string, opts ...ReadOption) (map[string]interface{}, error) {
 	var m = make(map[string]interface{})
 
+	o := readOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	cfg := vaultAPI.DefaultConfig()
 	cfg.Address = vaultAddress
 	client, err := vaultAPI.NewClient(cfg)
@@ -33,27 +73,67 @@ func GetVaultSecrets(vaultAddress, vaultToken, secretPath string) (map[string]in
 
 	client.SetToken(vaultToken)
 
-	data, err := client.Logical().Read(secretPath)
+	ctx, span := tracing.Start(context.Background(), "vault.read", attribute.String("vault.path", secretPath))
+	timer := metrics.VaultTimer("read")
+	data, err := readKV(ctx, client, secretPath, o)
+	timer.ObserveDuration()
+	tracing.End(span, err)
 	if err != nil {
+		metrics.VaultError("read")
 		return m, err
 	}
 
-	if data == nil {
-		return m, fmt.Errorf("no data at path: %s", secretPath)
-	}
-
-	return data.Data, nil
+	return data, nil
 }
 
-func (c *Config) getVaultSecrets(secretPath string) (map[string]interface{}, error) {
+func (c *Config) getVaultSecrets(secretPath string, opts ...ReadOption) (map[string]interface{}, error) {
 	if c.Vault.Address == "" {
 		return nil, fmt.Errorf("vault address not set")
 	}
-	if c.Vault.Token == "" {
+	token := c.vaultToken()
+	if token == "" {
 		return nil, fmt.Errorf("vault token not set")
 	}
+	if c.Vault.KVVersion != 0 {
+		opts = append([]ReadOption{WithKVMount(c.Vault.kvMount(), c.Vault.KVVersion)}, opts...)
+	}
 
-	return GetVaultSecrets(c.Vault.Address, c.Vault.Token, secretPath)
+	return GetVaultSecrets(c.Vault.Address, token, secretPath, opts...)
+}
+
+func (v *Vault) kvMount() string {
+	if v.KVMount == "" {
+		return "kv"
+	}
+
+	return v.KVMount
+}
+
+// vaultClient talks to vault with the current token
+func (c *Config) vaultClient() (*vaultAPI.Client, error) {
+	cfg := vaultAPI.DefaultConfig()
+	cfg.Address = c.Vault.Address
+	client, err := vaultAPI.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	client.SetToken(c.vaultToken())
+
+	return client, nil
+}
+
+// CheckVault makes sure vault still accepts the token
+func (c *Config) CheckVault(ctx context.Context) error {
+	client, err := c.vaultClient()
+	if err != nil {
+		return err
+	}
+
+	if _, err := client.Auth().Token().LookupSelfWithContext(ctx); err != nil {
+		return fmt.Errorf("vault token: %w", err)
+	}
+
+	return nil
 }
 
 func BuildVault(c *Config) error {
@@ -63,6 +143,16 @@ func BuildVault(c *Config) error {
 		return err
 	}
 
+	// no need to log in if nothing is read from vault
+	if v.AuthMethod != VaultAuthToken && c.UsesVault() {
+		auth, err := v.login(context.Background())
+		if err != nil {
+			return err
+		}
+		v.session = &vaultSession{}
+		v.session.set(auth)
+	}
+
 	c.Vault = *v
 
 	return nil
@@ -73,7 +163,11 @@ func ParseKVSecrets(data map[string]interface{}) ([]KVSecret, error) {
 
 	for ik, iv := range data {
 		if ik == "data" {
-			for k, v := range iv.(map[string]interface{}) {
+			values, ok := iv.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("secret data is a %T, not a map", iv)
+			}
+			for k, v := range values {
 				secrets = append(secrets, KVSecret{
 					Key:   k,
 					Value: fmt.Sprintf("%v", v),
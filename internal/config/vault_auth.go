@@ -0,0 +1,211 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	vaultAPI "github.com/hashicorp/vault/api"
+	"github.com/k8sdeploy/key-service/internal/metrics"
+	"github.com/k8sdeploy/key-service/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Vault auth methods
+const (
+	VaultAuthToken      = "token"
+	VaultAuthKubernetes = "kubernetes"
+	VaultAuthAppRole    = "approle"
+)
+
+// vaultSession is the token we got from logging in, it changes every time we have to log in again
+type vaultSession struct {
+	mu    sync.RWMutex
+	token string
+	auth  *vaultAPI.Secret
+}
+
+func (s *vaultSession) get() (string, *vaultAPI.Secret) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.token, s.auth
+}
+
+func (s *vaultSession) set(auth *vaultAPI.Secret) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.token = auth.Auth.ClientToken
+	s.auth = auth
+}
+
+// vaultToken is the token to send to vault, from the last login or VAULT_TOKEN
+func (c *Config) vaultToken() string {
+	if c.Vault.session == nil {
+		return c.Vault.Token
+	}
+
+	token, _ := c.Vault.session.get()
+	return token
+}
+
+func (v *Vault) authMount() string {
+	if v.AuthMount != "" {
+		return strings.Trim(v.AuthMount, "/")
+	}
+
+	return v.AuthMethod
+}
+
+// loginData is what the auth method wants to see, the service account token is read every time as
+// kubelet rotates it
+func (v *Vault) loginData() (map[string]interface{}, error) {
+	switch v.AuthMethod {
+	case VaultAuthKubernetes:
+		if v.Role == "" {
+			return nil, errors.New("vault role not set")
+		}
+		jwt, err := os.ReadFile(v.ServiceAccountTokenPath)
+		if err != nil {
+			return nil, fmt.Errorf("service account token: %w", err)
+		}
+		return map[string]interface{}{
+			"role": v.Role,
+			"jwt":  strings.TrimSpace(string(jwt)),
+		}, nil
+	case VaultAuthAppRole:
+		if v.RoleID == "" || v.SecretID == "" {
+			return nil, errors.New("vault role id and secret id not set")
+		}
+		return map[string]interface{}{
+			"role_id":   v.RoleID,
+			"secret_id": v.SecretID,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown vault auth method: %s", v.AuthMethod)
+}
+
+func (v *Vault) login(ctx context.Context) (*vaultAPI.Secret, error) {
+	data, err := v.loginData()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := vaultAPI.DefaultConfig()
+	cfg.Address = v.Address
+	client, err := vaultAPI.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	client.ClearToken()
+
+	ctx, span := tracing.Start(ctx, "vault.login", attribute.String("vault.auth_method", v.AuthMethod))
+	timer := metrics.VaultTimer("login")
+	auth, err := client.Logical().WriteWithContext(ctx, fmt.Sprintf("auth/%s/login", v.authMount()), data)
+	timer.ObserveDuration()
+	if err == nil && (auth == nil || auth.Auth == nil || auth.Auth.ClientToken == "") {
+		err = errors.New("no token in login response")
+	}
+	tracing.End(span, err)
+	if err != nil {
+		metrics.VaultError("login")
+		return nil, fmt.Errorf("vault %s login: %w", v.AuthMethod, err)
+	}
+
+	return auth, nil
+}
+
+// RenewVault keeps the token from the last login alive, once it can't be renewed any more it logs in
+// again, with a static VAULT_TOKEN there is nothing to do
+func (c *Config) RenewVault(ctx context.Context, logger log.Logger) {
+	session := c.Vault.session
+	if session == nil {
+		return
+	}
+
+	cfg := vaultAPI.DefaultConfig()
+	cfg.Address = c.Vault.Address
+
+	for {
+		token, auth := session.get()
+		client, err := vaultAPI.NewClient(cfg)
+		if err != nil {
+			_ = level.Error(logger).Log("msg", "creating vault client", "err", err)
+			return
+		}
+		client.SetToken(token)
+
+		watcher, err := client.NewLifetimeWatcher(&vaultAPI.LifetimeWatcherInput{
+			Secret: auth,
+		})
+		if err != nil {
+			_ = level.Error(logger).Log("msg", "watching vault token", "err", err)
+			return
+		}
+		go watcher.Start()
+
+		if !watchLease(ctx, watcher, "token", logger) {
+			watcher.Stop()
+			return
+		}
+		watcher.Stop()
+		_ = level.Info(logger).Log("msg", "vault token expiring, logging in again", "auth_method", c.Vault.AuthMethod)
+
+		if !c.relogin(ctx, logger) {
+			return
+		}
+	}
+}
+
+// watchLease waits for a token or lease to run out, false means ctx is done
+func watchLease(ctx context.Context, watcher *vaultAPI.LifetimeWatcher, lease string, logger log.Logger) bool {
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case renewal := <-watcher.RenewCh():
+			_ = level.Debug(logger).Log("msg", "renewed vault lease", "lease", lease, "ttl", leaseDuration(renewal.Secret))
+		case err := <-watcher.DoneCh():
+			if err != nil {
+				metrics.VaultError("renew")
+				_ = level.Warn(logger).Log("msg", "renewing vault lease", "lease", lease, "err", err)
+			}
+			return true
+		}
+	}
+}
+
+func leaseDuration(secret *vaultAPI.Secret) int {
+	if secret.Auth != nil {
+		return secret.Auth.LeaseDuration
+	}
+
+	return secret.LeaseDuration
+}
+
+// relogin logs in until it works, false means ctx is done
+func (c *Config) relogin(ctx context.Context, logger log.Logger) bool {
+	for {
+		auth, err := c.Vault.login(ctx)
+		if err == nil {
+			c.Vault.session.set(auth)
+			_ = level.Info(logger).Log("msg", "logged in to vault", "auth_method", c.Vault.AuthMethod, "ttl", auth.Auth.LeaseDuration)
+			return true
+		}
+		_ = level.Error(logger).Log("msg", "logging in to vault", "err", err)
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(c.Vault.LoginRetry):
+		}
+	}
+}
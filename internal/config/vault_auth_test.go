@@ -0,0 +1,237 @@
+package config_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/k8sdeploy/key-service/internal/config"
+)
+
+// stubVault does just enough of the kubernetes and approle login, token renewal and kv reads
+type stubVault struct {
+	mu        sync.Mutex
+	logins    int
+	renewals  int
+	token     string
+	lease     int
+	renewable bool
+}
+
+func (v *stubVault) counts() (int, int) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	return v.logins, v.renewals
+}
+
+func (v *stubVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	var body map[string]string
+	_ = json.NewDecoder(r.Body).Decode(&body)
+
+	w.Header().Set("Content-Type", "application/json")
+	switch r.URL.Path {
+	case "/v1/auth/kubernetes/login", "/v1/auth/approle/login":
+		if body["jwt"] != "sa-token" && body["secret_id"] != "secret-id" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		v.logins++
+		v.token = fmt.Sprintf("token-%d", v.logins)
+		_, _ = fmt.Fprintf(w, `{"auth":{"client_token":%q,"lease_duration":%d,"renewable":%t}}`, v.token, v.lease, v.renewable)
+	case "/v1/auth/token/renew-self":
+		v.renewals++
+		_, _ = fmt.Fprintf(w, `{"auth":{"client_token":%q,"lease_duration":%d,"renewable":%t}}`, v.token, v.lease, v.renewable)
+	default:
+		if r.Header.Get("X-Vault-Token") != v.token {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":{"data":{"username":"tester"}}}`))
+	}
+}
+
+func serviceAccountToken(t *testing.T) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("sa-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestBuildVault_Login(t *testing.T) {
+	tokenPath := serviceAccountToken(t)
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr bool
+	}{
+		{
+			name: "kubernetes",
+			env: map[string]string{
+				"VAULT_AUTH_METHOD":                "kubernetes",
+				"VAULT_ROLE":                       "key-service",
+				"VAULT_SERVICE_ACCOUNT_TOKEN_PATH": tokenPath,
+			},
+		},
+		{
+			name: "kubernetes without a role",
+			env: map[string]string{
+				"VAULT_AUTH_METHOD":                "kubernetes",
+				"VAULT_SERVICE_ACCOUNT_TOKEN_PATH": tokenPath,
+			},
+			wantErr: true,
+		},
+		{
+			name: "kubernetes without a service account token",
+			env: map[string]string{
+				"VAULT_AUTH_METHOD":                "kubernetes",
+				"VAULT_ROLE":                       "key-service",
+				"VAULT_SERVICE_ACCOUNT_TOKEN_PATH": filepath.Join(t.TempDir(), "missing"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "approle",
+			env: map[string]string{
+				"VAULT_AUTH_METHOD": "approle",
+				"VAULT_ROLE_ID":     "role-id",
+				"VAULT_SECRET_ID":   "secret-id",
+			},
+		},
+		{
+			name: "approle rejected",
+			env: map[string]string{
+				"VAULT_AUTH_METHOD": "approle",
+				"VAULT_ROLE_ID":     "role-id",
+				"VAULT_SECRET_ID":   "wrong",
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown method",
+			env: map[string]string{
+				"VAULT_AUTH_METHOD": "ldap",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vault := httptest.NewServer(&stubVault{lease: 3600, renewable: true})
+			defer vault.Close()
+
+			t.Setenv("VAULT_ADDRESS", vault.URL)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			c := &config.Config{}
+			err := config.BuildVault(c)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("BuildVault() = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if err := config.BuildMongo(c); err != nil {
+				t.Fatalf("BuildMongo() = %v, want the login token to be used", err)
+			}
+			if c.Mongo.Username != "tester" {
+				t.Errorf("mongo username = %q, want tester", c.Mongo.Username)
+			}
+		})
+	}
+}
+
+func TestConfig_RenewVault(t *testing.T) {
+	tests := []struct {
+		name         string
+		vault        *stubVault
+		wantLogins   int
+		wantRenewals int
+	}{
+		{
+			name:         "renewable token is renewed",
+			vault:        &stubVault{lease: 60, renewable: true},
+			wantLogins:   1,
+			wantRenewals: 1,
+		},
+		{
+			name:       "expiring token logs in again",
+			vault:      &stubVault{lease: 1},
+			wantLogins: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vault := httptest.NewServer(tt.vault)
+			defer vault.Close()
+
+			t.Setenv("VAULT_ADDRESS", vault.URL)
+			t.Setenv("VAULT_AUTH_METHOD", "kubernetes")
+			t.Setenv("VAULT_ROLE", "key-service")
+			t.Setenv("VAULT_SERVICE_ACCOUNT_TOKEN_PATH", serviceAccountToken(t))
+
+			c := &config.Config{}
+			if err := config.BuildVault(c); err != nil {
+				t.Fatalf("BuildVault() = %v", err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				c.RenewVault(ctx, log.NewNopLogger())
+				close(done)
+			}()
+			defer func() {
+				cancel()
+				<-done
+			}()
+
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				logins, renewals := tt.vault.counts()
+				if logins >= tt.wantLogins && renewals >= tt.wantRenewals {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("logins = %d, renewals = %d, want at least %d and %d", logins, renewals, tt.wantLogins, tt.wantRenewals)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			// the stub counts a login before the new token is stored, so give it a moment
+			for {
+				err := config.BuildMongo(c)
+				if err == nil {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("BuildMongo() = %v, want the current token to be used", err)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	vaultAPI "github.com/hashicorp/vault/api"
+	"github.com/k8sdeploy/key-service/internal/metrics"
+	"github.com/k8sdeploy/key-service/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// leaseMongoCredentials gets a short lived mongo user from the vault database secrets engine
+func (c *Config) leaseMongoCredentials(ctx context.Context) (*vaultAPI.Secret, error) {
+	client, err := c.vaultClient()
+	if err != nil {
+		return nil, err
+	}
+
+	path := fmt.Sprintf("%s/creds/%s", strings.Trim(c.Vault.DatabaseMount, "/"), c.Vault.DatabaseRole)
+	ctx, span := tracing.Start(ctx, "vault.lease", attribute.String("vault.path", path))
+	timer := metrics.VaultTimer("lease")
+	lease, err := client.Logical().ReadWithContext(ctx, path)
+	timer.ObserveDuration()
+	if err == nil && (lease == nil || lease.Data["username"] == nil || lease.Data["password"] == nil) {
+		err = errors.New("no credentials in lease")
+	}
+	tracing.End(span, err)
+	if err != nil {
+		metrics.VaultError("lease")
+		return nil, fmt.Errorf("mongo credentials: %w", err)
+	}
+
+	return lease, nil
+}
+
+// setMongoLease swaps in the leased credentials, the old lease is left to expire so operations already
+// using it can finish
+func (c *Config) setMongoLease(lease *vaultAPI.Secret) {
+	c.secrets.Lock()
+	defer c.secrets.Unlock()
+
+	c.mongoLease = lease
+	c.Mongo.Username = fmt.Sprintf("%v", lease.Data["username"])
+	c.Mongo.Password = fmt.Sprintf("%v", lease.Data["password"])
+	c.notifyMongoChanged()
+}
+
+// RenewMongoCredentials keeps the leased mongo credentials alive, and leases new ones before they expire
+func (c *Config) RenewMongoCredentials(ctx context.Context, logger log.Logger) {
+	for {
+		c.secrets.RLock()
+		lease := c.mongoLease
+		c.secrets.RUnlock()
+		if lease == nil {
+			return
+		}
+
+		client, err := c.vaultClient()
+		if err != nil {
+			_ = level.Error(logger).Log("msg", "creating vault client", "err", err)
+			return
+		}
+		watcher, err := client.NewLifetimeWatcher(&vaultAPI.LifetimeWatcherInput{
+			Secret: lease,
+		})
+		if err != nil {
+			_ = level.Error(logger).Log("msg", "watching mongo credentials", "err", err)
+			return
+		}
+		go watcher.Start()
+
+		if !watchLease(ctx, watcher, "mongo", logger) {
+			watcher.Stop()
+			return
+		}
+		watcher.Stop()
+		_ = level.Info(logger).Log("msg", "mongo credentials expiring, leasing new ones", "role", c.Vault.DatabaseRole)
+
+		if !c.replaceMongoLease(ctx, logger) {
+			return
+		}
+	}
+}
+
+// replaceMongoLease leases new credentials until it works, false means ctx is done
+func (c *Config) replaceMongoLease(ctx context.Context, logger log.Logger) bool {
+	for {
+		lease, err := c.leaseMongoCredentials(ctx)
+		if err == nil {
+			c.setMongoLease(lease)
+			_ = level.Info(logger).Log("msg", "leased mongo credentials", "role", c.Vault.DatabaseRole, "ttl", lease.LeaseDuration)
+			return true
+		}
+		_ = level.Error(logger).Log("msg", "leasing mongo credentials", "err", err)
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(c.Vault.LoginRetry):
+		}
+	}
+}
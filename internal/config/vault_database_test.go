@@ -0,0 +1,137 @@
+package config_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/k8sdeploy/key-service/internal/config"
+)
+
+// databaseVault serves the mongo kv secret and leases mongo users from the database secrets engine
+type databaseVault struct {
+	mu        sync.Mutex
+	leases    int
+	renewals  int
+	lease     int
+	renewable bool
+}
+
+func (v *databaseVault) counts() (int, int) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	return v.leases, v.renewals
+}
+
+func (v *databaseVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	switch r.URL.Path {
+	case "/v1/kv/data/k8sdeploy/key-service/mongodb":
+		_, _ = w.Write([]byte(`{"data":{"data":{"username":"static","password":"static","hostname":"mongo"}}}`))
+	case "/v1/database/creds/key-service":
+		v.leases++
+		_, _ = fmt.Fprintf(w, `{"lease_id":"database/creds/key-service/%d","lease_duration":%d,"renewable":%t,"data":{"username":"v-key-service-%d","password":"leased"}}`, v.leases, v.lease, v.renewable, v.leases)
+	case "/v1/sys/leases/renew":
+		v.renewals++
+		_, _ = fmt.Fprintf(w, `{"lease_id":"database/creds/key-service/%d","lease_duration":%d,"renewable":%t}`, v.leases, v.lease, v.renewable)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestBuildMongo_DatabaseSecrets(t *testing.T) {
+	tests := []struct {
+		name         string
+		role         string
+		vault        *databaseVault
+		wantUsername string
+		wantLeases   int
+		wantRenewals int
+	}{
+		{
+			name:         "kv credentials without a role",
+			vault:        &databaseVault{},
+			wantUsername: "static",
+		},
+		{
+			name:         "renewable lease is renewed",
+			role:         "key-service",
+			vault:        &databaseVault{lease: 60, renewable: true},
+			wantUsername: "v-key-service-1",
+			wantLeases:   1,
+			wantRenewals: 1,
+		},
+		{
+			name:         "expiring lease is replaced",
+			role:         "key-service",
+			vault:        &databaseVault{lease: 1},
+			wantUsername: "v-key-service-2",
+			wantLeases:   2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(tt.vault)
+			defer server.Close()
+
+			c := &config.Config{}
+			c.Vault.Address = server.URL
+			c.Vault.Token = "token"
+			c.Vault.DatabaseRole = tt.role
+			c.Vault.DatabaseMount = "database"
+			c.Vault.LoginRetry = 10 * time.Millisecond
+			if err := config.BuildMongo(c); err != nil {
+				t.Fatalf("BuildMongo() = %v", err)
+			}
+			changed := c.MongoChanged()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				c.RenewMongoCredentials(ctx, log.NewNopLogger())
+				close(done)
+			}()
+			defer func() {
+				cancel()
+				<-done
+			}()
+
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				leases, renewals := tt.vault.counts()
+				if leases >= tt.wantLeases && renewals >= tt.wantRenewals {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("leases = %d, renewals = %d, want at least %d and %d", leases, renewals, tt.wantLeases, tt.wantRenewals)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			if tt.wantLeases > 1 {
+				select {
+				case <-changed:
+				case <-time.After(5 * time.Second):
+					t.Fatalf("MongoChanged() never closed after the credentials were replaced")
+				}
+			}
+			mongo := c.MongoConfig()
+			if mongo.Username != tt.wantUsername {
+				t.Errorf("username = %q, want %q", mongo.Username, tt.wantUsername)
+			}
+			if mongo.Host != "mongo" {
+				t.Errorf("host = %q, want it from kv", mongo.Host)
+			}
+		})
+	}
+}
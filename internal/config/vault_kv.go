@@ -0,0 +1,154 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	vaultAPI "github.com/hashicorp/vault/api"
+)
+
+// ErrSecretNotFound is a secret that isn't in vault, which isn't the same as vault failing to say
+var ErrSecretNotFound = errors.New("secret not found")
+
+// ReadOption changes how GetVaultSecrets reads a secret
+type ReadOption func(o *readOptions)
+
+type readOptions struct {
+	version  int
+	mount    kvMount
+	metadata bool
+}
+
+// WithSecretVersion reads an older version of a kv v2 secret, to roll back to it
+func WithSecretVersion(version int) ReadOption {
+	return func(o *readOptions) {
+		o.version = version
+	}
+}
+
+// WithKVMount skips asking vault which mount the secret is in and its kv version
+func WithKVMount(mount string, version int) ReadOption {
+	return func(o *readOptions) {
+		o.mount = kvMount{
+			path:    strings.Trim(mount, "/") + "/",
+			version: version,
+		}
+	}
+}
+
+// WithMetadata reads the kv v2 metadata of the secret rather than its data
+func WithMetadata() ReadOption {
+	return func(o *readOptions) {
+		o.metadata = true
+	}
+}
+
+// kvMount is the kv engine a secret lives in
+type kvMount struct {
+	path    string
+	version int
+}
+
+// kvMounts remembers what has been detected, keyed by vault address and secret path
+var kvMounts sync.Map
+
+// detectKVMount asks vault which mount a secret is in and its kv version, like the vault cli does. if
+// vault won't say the mount is taken to be the first part of the path and kv v2
+func detectKVMount(ctx context.Context, client *vaultAPI.Client, secretPath string) kvMount {
+	cacheKey := client.Address() + "|" + secretPath
+	if m, ok := kvMounts.Load(cacheKey); ok {
+		return m.(kvMount)
+	}
+
+	fallback := kvMount{
+		path:    strings.SplitN(secretPath, "/", 2)[0] + "/",
+		version: 2,
+	}
+	secret, err := client.Logical().ReadWithContext(ctx, "sys/internal/ui/mounts/"+secretPath)
+	if err != nil || secret == nil {
+		return fallback
+	}
+	mountPath, ok := secret.Data["path"].(string)
+	if !ok || mountPath == "" || !strings.HasPrefix(secretPath, mountPath) {
+		return fallback
+	}
+
+	m := kvMount{
+		path:    mountPath,
+		version: 1,
+	}
+	if options, ok := secret.Data["options"].(map[string]interface{}); ok {
+		if v, ok := options["version"].(string); ok && v == "2" {
+			m.version = 2
+		}
+	}
+	kvMounts.Store(cacheKey, m)
+
+	return m
+}
+
+// kvPath is the api path for a secret, kv v2 keeps data and metadata under their own prefixes
+func kvPath(m kvMount, secretPath string, metadata bool) (string, error) {
+	if m.version != 2 {
+		if metadata {
+			return "", errors.New("kv v1 secrets have no metadata")
+		}
+		return secretPath, nil
+	}
+
+	rel := strings.TrimPrefix(secretPath, m.path)
+	for _, prefix := range []string{"data/", "metadata/"} {
+		rel = strings.TrimPrefix(rel, prefix)
+	}
+	if metadata {
+		return m.path + "metadata/" + rel, nil
+	}
+
+	return m.path + "data/" + rel, nil
+}
+
+// readKV reads a secret from either kv version, a kv v1 secret is wrapped so it looks like kv v2
+func readKV(ctx context.Context, client *vaultAPI.Client, secretPath string, o readOptions) (map[string]interface{}, error) {
+	m := o.mount
+	if m.version == 0 {
+		m = detectKVMount(ctx, client, secretPath)
+	}
+
+	path, err := kvPath(m, secretPath, o.metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	var data *vaultAPI.Secret
+	if o.version > 0 && !o.metadata {
+		if m.version != 2 {
+			return nil, fmt.Errorf("kv v1 secrets have no versions: %s", secretPath)
+		}
+		data, err = client.Logical().ReadWithDataWithContext(ctx, path, map[string][]string{
+			"version": {strconv.Itoa(o.version)},
+		})
+	} else {
+		data, err = client.Logical().ReadWithContext(ctx, path)
+	}
+	var response *vaultAPI.ResponseError
+	if errors.As(err, &response) && response.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %s", ErrSecretNotFound, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, fmt.Errorf("%w: %s", ErrSecretNotFound, path)
+	}
+
+	if m.version != 2 {
+		return map[string]interface{}{"data": data.Data}, nil
+	}
+
+	return data.Data, nil
+}
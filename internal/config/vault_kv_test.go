@@ -0,0 +1,245 @@
+package config_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/k8sdeploy/key-service/internal/config"
+)
+
+// kvMountsVault has a kv v1 mount at legacy/ and a kv v2 mount at team/kv/ with two versions of
+// every secret
+func kvMountsVault() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		path := strings.TrimPrefix(r.URL.Path, "/v1/")
+		switch {
+		case strings.HasPrefix(path, "sys/internal/ui/mounts/legacy/"):
+			_, _ = w.Write([]byte(`{"data":{"path":"legacy/","type":"kv","options":{"version":"1"}}}`))
+		case strings.HasPrefix(path, "sys/internal/ui/mounts/team/kv/"):
+			_, _ = w.Write([]byte(`{"data":{"path":"team/kv/","type":"kv","options":{"version":"2"}}}`))
+		case strings.HasPrefix(path, "legacy/"):
+			_, _ = w.Write([]byte(`{"data":{"hooks":"v1-hooks"}}`))
+		case strings.HasPrefix(path, "team/kv/data/"):
+			version := r.URL.Query().Get("version")
+			if version == "" {
+				version = "2"
+			}
+			_, _ = fmt.Fprintf(w, `{"data":{"data":{"hooks":"v2-hooks-%s"},"metadata":{"version":%s}}}`, version, version)
+		case strings.HasPrefix(path, "team/kv/metadata/"):
+			_, _ = w.Write([]byte(`{"data":{"current_version":2}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestGetVaultSecrets_KV(t *testing.T) {
+	vault := kvMountsVault()
+	defer vault.Close()
+
+	tests := []struct {
+		name      string
+		path      string
+		opts      []config.ReadOption
+		wantHooks string
+		wantErr   bool
+	}{
+		{
+			name:      "kv v1",
+			path:      "legacy/k8sdeploy/api-keys",
+			wantHooks: "v1-hooks",
+		},
+		{
+			name:      "kv v2 on a nested mount",
+			path:      "team/kv/k8sdeploy/api-keys",
+			wantHooks: "v2-hooks-2",
+		},
+		{
+			name:      "kv v2 with an explicit data path",
+			path:      "team/kv/data/k8sdeploy/api-keys",
+			wantHooks: "v2-hooks-2",
+		},
+		{
+			name:      "older version",
+			path:      "team/kv/k8sdeploy/api-keys",
+			opts:      []config.ReadOption{config.WithSecretVersion(1)},
+			wantHooks: "v2-hooks-1",
+		},
+		{
+			name:    "kv v1 has no versions",
+			path:    "legacy/k8sdeploy/api-keys",
+			opts:    []config.ReadOption{config.WithSecretVersion(1)},
+			wantErr: true,
+		},
+		{
+			name:    "kv v1 has no metadata",
+			path:    "legacy/k8sdeploy/api-keys",
+			opts:    []config.ReadOption{config.WithMetadata()},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secrets, err := config.GetVaultSecrets(vault.URL, "token", tt.path, tt.opts...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetVaultSecrets() = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			kvs, err := config.ParseKVSecrets(secrets)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := config.KVStrings(kvs)["hooks"]; got != tt.wantHooks {
+				t.Errorf("hooks = %q, want %q", got, tt.wantHooks)
+			}
+		})
+	}
+}
+
+func TestVaultSource_Mounts(t *testing.T) {
+	vault := kvMountsVault()
+	defer vault.Close()
+
+	tests := []struct {
+		name        string
+		mount       string
+		kvVersion   int
+		versions    map[string]int
+		wantHooks   string
+		wantVersion int64
+	}{
+		{
+			name:        "custom mount and prefix",
+			mount:       "team/kv",
+			wantHooks:   "v2-hooks-2",
+			wantVersion: 2,
+		},
+		{
+			name:        "pinned version",
+			mount:       "team/kv",
+			versions:    map[string]int{config.ServiceKeysSecret: 1},
+			wantHooks:   "v2-hooks-1",
+			wantVersion: 1,
+		},
+		{
+			name:      "kv v1 set rather than detected",
+			mount:     "legacy",
+			kvVersion: 1,
+			wantHooks: "v1-hooks",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &config.Config{}
+			c.Vault.Address = vault.URL
+			c.Vault.Token = "token"
+			c.Vault.KVMount = tt.mount
+			c.Vault.KVPrefix = "team-a"
+			c.Vault.KVVersion = tt.kvVersion
+			c.Vault.KVVersions = tt.versions
+
+			if err := config.BuildServiceKeys(c); err != nil {
+				t.Fatalf("BuildServiceKeys() = %v", err)
+			}
+			if got := c.ServiceKeys().HooksService.Key; got != tt.wantHooks {
+				t.Errorf("hooks key = %q, want %q", got, tt.wantHooks)
+			}
+
+			if tt.wantVersion == 0 {
+				return
+			}
+			version, err := (&config.VaultSource{Config: c}).Version(context.Background(), config.ServiceKeysSecret)
+			if err != nil {
+				t.Fatalf("Version() = %v", err)
+			}
+			if version != tt.wantVersion {
+				t.Errorf("Version() = %d, want %d", version, tt.wantVersion)
+			}
+		})
+	}
+}
+
+func TestVaultSource_MissingSecret(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		body        string
+		wantValues  map[string]string
+		wantVersion int64
+		wantErr     bool
+	}{
+		{
+			name:       "not found",
+			status:     http.StatusNotFound,
+			body:       `{"errors":[]}`,
+			wantValues: map[string]string{},
+		},
+		{
+			name:        "latest version deleted",
+			status:      http.StatusOK,
+			body:        `{"data":{"data":null,"metadata":{"version":3},"current_version":3}}`,
+			wantValues:  map[string]string{},
+			wantVersion: 3,
+		},
+		{
+			name:    "vault failing",
+			status:  http.StatusInternalServerError,
+			body:    `{"errors":["internal error"]}`,
+			wantErr: true,
+		},
+		{
+			name:    "not allowed",
+			status:  http.StatusForbidden,
+			body:    `{"errors":["permission denied"]}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("VAULT_MAX_RETRIES", "0")
+			vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer vault.Close()
+
+			c := &config.Config{}
+			c.Vault.Address = vault.URL
+			c.Vault.Token = "token"
+			c.Vault.KVVersion = 2
+			source := &config.VaultSource{Config: c}
+
+			values, err := source.Values(context.Background(), config.ServiceKeysSecret)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Values() = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(values, tt.wantValues) {
+				t.Errorf("Values() = %v, want %v", values, tt.wantValues)
+			}
+
+			version, err := source.Version(context.Background(), config.ServiceKeysSecret)
+			if err != nil {
+				t.Fatalf("Version() = %v", err)
+			}
+			if version != tt.wantVersion {
+				t.Errorf("Version() = %d, want %d", version, tt.wantVersion)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package config_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/k8sdeploy/key-service/internal/config"
+	"github.com/k8sdeploy/key-service/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestGetVaultSecrets_Traced(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := tracing.NewProvider(exporter, "key-service", "test", 1)
+	otel.SetTracerProvider(tp)
+
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data":{"data":{"username":"tester"}}}`))
+	}))
+	defer vault.Close()
+
+	secrets, err := config.GetVaultSecrets(vault.URL, "token", "kv/data/k8sdeploy/key-service/mongodb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kvs, err := config.ParseKVSecrets(secrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := config.KVStrings(kvs)["username"]; got != "tester" {
+		t.Errorf("username = %v, want tester", got)
+	}
+
+	if err := tp.ForceFlush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	spans := exporter.GetSpans()
+	if len(spans) != 1 || spans[0].Name != "vault.read" {
+		t.Fatalf("spans = %+v, want one vault.read span", spans)
+	}
+	found := false
+	for _, a := range spans[0].Attributes {
+		if a.Key == "vault.path" && a.Value.AsString() == "kv/data/k8sdeploy/key-service/mongodb" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("vault.read span has no vault.path attribute")
+	}
+}
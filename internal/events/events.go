@@ -0,0 +1,159 @@
+package events
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/k8sdeploy/key-service/internal/metrics"
+)
+
+// Types
+const (
+	KeyCreated = "KeyCreated"
+	KeyRotated = "KeyRotated"
+	KeyRevoked = "KeyRevoked"
+	KeyExpired = "KeyExpired"
+)
+
+// Event is a change to a key, like the audit log it only ever carries the key id and never the secret
+type Event struct {
+	ID      string    `json:"id" bson:"_id"`
+	Type    string    `json:"type" bson:"type"`
+	KeyType string    `json:"key_type" bson:"key_type"`
+	Owner   string    `json:"owner" bson:"owner"`
+	KeyID   string    `json:"key_id" bson:"key_id"`
+	Time    time.Time `json:"time" bson:"time"`
+}
+
+func New(eventType, keyType, owner, keyID string) Event {
+	id := make([]byte, 16)
+	// crypto/rand only fails if the OS has no entropy source at all
+	_, _ = rand.Read(id)
+
+	return Event{
+		ID:      hex.EncodeToString(id),
+		Type:    eventType,
+		KeyType: keyType,
+		Owner:   owner,
+		KeyID:   keyID,
+		Time:    time.Now().UTC(),
+	}
+}
+
+// Publisher delivers events to whoever is listening, an event can be delivered more than once so
+// consumers should use the id to drop duplicates
+type Publisher interface {
+	Publish(ctx context.Context, e Event) error
+	Close() error
+}
+
+// Outbox holds events written alongside the key changes that caused them until they have been published.
+// Pending leaves out events that have been dead lettered, they are kept but never published
+type Outbox interface {
+	Pending(ctx context.Context, limit int) ([]Event, error)
+	MarkPublished(ctx context.Context, id string) error
+	// MarkFailed counts a failed publish, returning how many there have been for the event
+	MarkFailed(ctx context.Context, id string) (int, error)
+	DeadLetter(ctx context.Context, id string) error
+}
+
+// Relay moves events from the outbox to the publisher, an event is only marked published once the
+// publisher has accepted it. an event that has failed MaxAttempts times is dead lettered so it can't hold up
+// the ones after it, zero keeps retrying it forever
+type Relay struct {
+	Outbox      Outbox
+	Publisher   Publisher
+	Interval    time.Duration
+	BatchSize   int
+	MaxAttempts int
+	Logger      log.Logger
+}
+
+func NewRelay(outbox Outbox, publisher Publisher, interval time.Duration, batchSize int, logger log.Logger) *Relay {
+	return &Relay{
+		Outbox:    outbox,
+		Publisher: publisher,
+		Interval:  interval,
+		BatchSize: batchSize,
+		Logger:    logger,
+	}
+}
+
+// Run flushes the outbox every interval until the context is done
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := r.Flush(ctx); err != nil {
+			_ = level.Warn(r.Logger).Log("msg", "relaying events", "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Flush publishes pending events in order, it stops at the first failure so events for a key are never
+// delivered out of order, the failed event is retried on the next flush until it is dead lettered
+func (r *Relay) Flush(ctx context.Context) (int, error) {
+	published := 0
+	for {
+		pending, err := r.Outbox.Pending(ctx, r.BatchSize)
+		if err != nil {
+			return published, err
+		}
+		if len(pending) == 0 {
+			return published, nil
+		}
+
+		for _, e := range pending {
+			if err := r.Publisher.Publish(ctx, e); err != nil {
+				metrics.EventFailed(e.Type)
+				if deadErr := r.failed(ctx, e, err); deadErr != nil {
+					return published, deadErr
+				}
+				continue
+			}
+			metrics.EventPublished(e.Type)
+
+			if err := r.Outbox.MarkPublished(ctx, e.ID); err != nil {
+				// it will go out again next time, which at least once allows for
+				return published, err
+			}
+			published++
+		}
+
+		if len(pending) < r.BatchSize {
+			return published, nil
+		}
+	}
+}
+
+// failed counts a failed publish, it returns the error to stop the flush with unless the event has now
+// failed too often and been set aside
+func (r *Relay) failed(ctx context.Context, e Event, err error) error {
+	attempts, markErr := r.Outbox.MarkFailed(ctx, e.ID)
+	if markErr != nil {
+		_ = level.Warn(r.Logger).Log("msg", "counting failed publish", "event_id", e.ID, "err", markErr)
+		return err
+	}
+	if r.MaxAttempts <= 0 || attempts < r.MaxAttempts {
+		return err
+	}
+
+	if deadErr := r.Outbox.DeadLetter(ctx, e.ID); deadErr != nil {
+		return deadErr
+	}
+	metrics.EventDeadLettered(e.Type)
+	_ = level.Error(r.Logger).Log("msg", "dead lettered event", "event_id", e.ID, "type", e.Type, "attempts", attempts, "err", err)
+
+	return nil
+}
@@ -0,0 +1,180 @@
+package events_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/k8sdeploy/key-service/internal/events"
+	"github.com/k8sdeploy/key-service/internal/signature"
+)
+
+// flaky fails every publish while down is set
+type flaky struct {
+	*events.Memory
+	down bool
+}
+
+func (f *flaky) Publish(ctx context.Context, e events.Event) error {
+	if f.down {
+		return errors.New("bus down")
+	}
+
+	return f.Memory.Publish(ctx, e)
+}
+
+func TestRelay_Flush(t *testing.T) {
+	outbox := events.NewMemoryOutbox()
+	created := events.New(events.KeyCreated, "hooks", "company", "key-1")
+	rotated := events.New(events.KeyRotated, "hooks", "company", "key-2")
+	revoked := events.New(events.KeyRevoked, "hooks", "company", "key-2")
+	for _, e := range []events.Event{created, rotated, revoked} {
+		_ = outbox.Add(context.Background(), e)
+	}
+
+	bus := &flaky{Memory: events.NewMemory(), down: true}
+	r := events.NewRelay(outbox, bus, time.Second, 2, log.NewNopLogger())
+
+	if n, err := r.Flush(context.Background()); err == nil || n != 0 {
+		t.Fatalf("Flush() with the bus down = %d, %v, want 0 and an error", n, err)
+	}
+	if pending, _ := outbox.Pending(context.Background(), 10); len(pending) != 3 {
+		t.Fatalf("pending after failed flush = %d, want 3", len(pending))
+	}
+
+	bus.down = false
+	if n, err := r.Flush(context.Background()); err != nil || n != 3 {
+		t.Fatalf("Flush() = %d, %v, want 3 and nil", n, err)
+	}
+
+	published := bus.Published()
+	want := []string{created.ID, rotated.ID, revoked.ID}
+	if len(published) != len(want) {
+		t.Fatalf("published %d events, want %d", len(published), len(want))
+	}
+	for i, e := range published {
+		if e.ID != want[i] {
+			t.Errorf("published[%d] = %s, want %s", i, e.ID, want[i])
+		}
+	}
+
+	if n, _ := r.Flush(context.Background()); n != 0 {
+		t.Errorf("second Flush() = %d, want 0", n)
+	}
+}
+
+// poisoned refuses one event and publishes the rest
+type poisoned struct {
+	*events.Memory
+	id string
+}
+
+func (p *poisoned) Publish(ctx context.Context, e events.Event) error {
+	if e.ID == p.id {
+		return errors.New("rejected")
+	}
+
+	return p.Memory.Publish(ctx, e)
+}
+
+func TestRelay_DeadLetter(t *testing.T) {
+	outbox := events.NewMemoryOutbox()
+	poison := events.New(events.KeyCreated, "hooks", "company", "key-1")
+	next := events.New(events.KeyRotated, "hooks", "company", "key-2")
+	for _, e := range []events.Event{poison, next} {
+		_ = outbox.Add(context.Background(), e)
+	}
+
+	bus := &poisoned{Memory: events.NewMemory(), id: poison.ID}
+	r := events.NewRelay(outbox, bus, time.Second, 10, log.NewNopLogger())
+	r.MaxAttempts = 2
+
+	if n, err := r.Flush(context.Background()); err == nil || n != 0 {
+		t.Fatalf("first Flush() = %d, %v, want 0 and an error", n, err)
+	}
+	if n, err := r.Flush(context.Background()); err != nil || n != 1 {
+		t.Fatalf("second Flush() = %d, %v, want the poison dead lettered and 1 published", n, err)
+	}
+
+	published := bus.Published()
+	if len(published) != 1 || published[0].ID != next.ID {
+		t.Errorf("published = %v, want only %s", published, next.ID)
+	}
+	if pending, _ := outbox.Pending(context.Background(), 10); len(pending) != 0 {
+		t.Errorf("pending after dead letter = %d, want 0", len(pending))
+	}
+}
+
+func TestMemory_Subscribe(t *testing.T) {
+	bus := events.NewMemory()
+	sub := bus.Subscribe(1)
+
+	e := events.New(events.KeyExpired, "user", "user-1", "key")
+	_ = bus.Publish(context.Background(), e)
+
+	if got := <-sub; got.ID != e.ID {
+		t.Errorf("Subscribe() got %s, want %s", got.ID, e.ID)
+	}
+
+	_ = bus.Close()
+	if _, ok := <-sub; ok {
+		t.Errorf("subscription still open after Close()")
+	}
+}
+
+func TestWebhook_Publish(t *testing.T) {
+	secret := "webhook-secret"
+	e := events.New(events.KeyCreated, "hooks", "company", "key-1")
+
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{
+			name:   "accepted",
+			status: http.StatusAccepted,
+		},
+		{
+			name:    "rejected",
+			status:  http.StatusInternalServerError,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+
+				var got events.Event
+				if err := json.Unmarshal(body, &got); err != nil || got.ID != e.ID {
+					t.Errorf("webhook body = %s, want event %s", body, e.ID)
+				}
+				if r.Header.Get(events.EventIDHeader) != e.ID {
+					t.Errorf("%s = %s, want %s", events.EventIDHeader, r.Header.Get(events.EventIDHeader), e.ID)
+				}
+
+				ts, _ := strconv.ParseInt(r.Header.Get(events.TimestampHeader), 10, 64)
+				if r.Header.Get(events.SignatureHeader) != signature.Sign(secret, ts, e.ID, body) {
+					t.Errorf("webhook signature doesn't match the body")
+				}
+
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			w := events.NewWebhook(srv.URL, secret, time.Second)
+			if err := w.Publish(context.Background(), e); (err != nil) != tt.wantErr {
+				t.Errorf("Webhook.Publish() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
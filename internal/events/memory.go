@@ -0,0 +1,135 @@
+package events
+
+import (
+	"context"
+	"sync"
+)
+
+// Memory is an in process bus, subscribers get every event published after they subscribed
+type Memory struct {
+	mu          sync.Mutex
+	published   []Event
+	subscribers []chan Event
+}
+
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+func (m *Memory) Publish(ctx context.Context, e Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.published = append(m.published, e)
+	for _, s := range m.subscribers {
+		select {
+		case s <- e:
+		default:
+			// a slow subscriber doesn't hold up publishing
+		}
+	}
+
+	return nil
+}
+
+// Subscribe returns a channel of events, events are dropped if the buffer is full
+func (m *Memory) Subscribe(buffer int) <-chan Event {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := make(chan Event, buffer)
+	m.subscribers = append(m.subscribers, s)
+
+	return s
+}
+
+// Published returns every event published so far
+func (m *Memory) Published() []Event {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	published := make([]Event, len(m.published))
+	copy(published, m.published)
+
+	return published
+}
+
+func (m *Memory) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.subscribers {
+		close(s)
+	}
+	m.subscribers = nil
+
+	return nil
+}
+
+type MemoryOutbox struct {
+	mu       sync.Mutex
+	events   []Event
+	done     map[string]bool
+	attempts map[string]int
+}
+
+func NewMemoryOutbox() *MemoryOutbox {
+	return &MemoryOutbox{
+		done:     make(map[string]bool),
+		attempts: make(map[string]int),
+	}
+}
+
+func (m *MemoryOutbox) Add(ctx context.Context, e Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.events = append(m.events, e)
+
+	return nil
+}
+
+func (m *MemoryOutbox) Pending(ctx context.Context, limit int) ([]Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var pending []Event
+	for _, e := range m.events {
+		if len(pending) == limit {
+			break
+		}
+		if !m.done[e.ID] {
+			pending = append(pending, e)
+		}
+	}
+
+	return pending, nil
+}
+
+func (m *MemoryOutbox) MarkPublished(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.done[id] = true
+
+	return nil
+}
+
+func (m *MemoryOutbox) MarkFailed(ctx context.Context, id string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.attempts[id]++
+
+	return m.attempts[id], nil
+}
+
+// DeadLetter drops the event, there is nowhere to keep it aside in memory
+func (m *MemoryOutbox) DeadLetter(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.done[id] = true
+
+	return nil
+}
@@ -0,0 +1,49 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+)
+
+// NATS publishes each event on "<prefix>.<key type>.<event type>", the event id is set as the message id
+// so a JetStream stream can drop redeliveries
+type NATS struct {
+	Conn   *nats.Conn
+	Prefix string
+}
+
+func NewNATS(url, prefix string) (*NATS, error) {
+	conn, err := nats.Connect(url, nats.Name("key-service"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &NATS{
+		Conn:   conn,
+		Prefix: prefix,
+	}, nil
+}
+
+func (n *NATS) Publish(ctx context.Context, e Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	msg := nats.NewMsg(fmt.Sprintf("%s.%s.%s", n.Prefix, e.KeyType, e.Type))
+	msg.Header.Set(nats.MsgIdHdr, e.ID)
+	msg.Data = b
+	if err := n.Conn.PublishMsg(msg); err != nil {
+		return err
+	}
+
+	// only count it as published once the server has it
+	return n.Conn.FlushWithContext(ctx)
+}
+
+func (n *NATS) Close() error {
+	return n.Conn.Drain()
+}
@@ -0,0 +1,129 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrCursorExpired = errors.New("cursor no longer available")
+)
+
+// Filter picks the events a watcher wants, empty fields match everything
+type Filter struct {
+	Owner   string
+	KeyType string
+}
+
+func (f Filter) Matches(e Event) bool {
+	if f.Owner != "" && e.Owner != f.Owner {
+		return false
+	}
+	if f.KeyType != "" && e.KeyType != f.KeyType {
+		return false
+	}
+
+	return true
+}
+
+// Watcher streams events matching the filter to fn, each with the cursor to resume after it,
+// an empty cursor starts from now, it runs until the context is done or fn returns an error
+type Watcher interface {
+	Watch(ctx context.Context, f Filter, cursor string, fn func(e Event, cursor string) error) error
+}
+
+// Hub is an in process Publisher and Watcher, it keeps the last few events so watchers can resume
+type Hub struct {
+	mu      sync.Mutex
+	size    int
+	first   int64
+	events  []Event
+	changed chan struct{}
+}
+
+func NewHub(size int) *Hub {
+	return &Hub{
+		size:    size,
+		first:   1,
+		changed: make(chan struct{}),
+	}
+}
+
+func (h *Hub) Publish(ctx context.Context, e Event) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.events = append(h.events, e)
+	if len(h.events) > h.size {
+		h.events = h.events[1:]
+		h.first++
+	}
+
+	// wake everyone that is waiting on the next event
+	close(h.changed)
+	h.changed = make(chan struct{})
+
+	return nil
+}
+
+func (h *Hub) Close() error {
+	return nil
+}
+
+// since returns the events from seq onwards, the seq after them and a channel closed on the next publish
+func (h *Hub) since(seq int64) ([]Event, int64, <-chan struct{}, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if seq < h.first {
+		return nil, 0, nil, ErrCursorExpired
+	}
+	next := h.first + int64(len(h.events))
+	if seq >= next {
+		return nil, seq, h.changed, nil
+	}
+
+	evs := make([]Event, next-seq)
+	copy(evs, h.events[seq-h.first:])
+
+	return evs, next, h.changed, nil
+}
+
+func (h *Hub) Watch(ctx context.Context, f Filter, cursor string, fn func(e Event, cursor string) error) error {
+	h.mu.Lock()
+	seq := h.first + int64(len(h.events))
+	h.mu.Unlock()
+
+	if cursor != "" {
+		last, err := strconv.ParseInt(cursor, 10, 64)
+		if err != nil {
+			return ErrInvalidCursor
+		}
+		seq = last + 1
+	}
+
+	for {
+		evs, next, changed, err := h.since(seq)
+		if err != nil {
+			return err
+		}
+		for i, e := range evs {
+			if !f.Matches(e) {
+				continue
+			}
+			if err := fn(e, strconv.FormatInt(seq+int64(i), 10)); err != nil {
+				return err
+			}
+		}
+		seq = next
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package events_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/k8sdeploy/key-service/internal/events"
+)
+
+type seen struct {
+	ids     []string
+	cursors []string
+}
+
+// collect watches until it has n events
+func collect(t *testing.T, h *events.Hub, f events.Filter, cursor string, n int) seen {
+	t.Helper()
+
+	var s seen
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err := h.Watch(ctx, f, cursor, func(e events.Event, cursor string) error {
+		s.ids = append(s.ids, e.ID)
+		s.cursors = append(s.cursors, cursor)
+		if len(s.ids) == n {
+			cancel()
+		}
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Watch() = %v, want %v", err, context.Canceled)
+	}
+
+	return s
+}
+
+func TestHub_Watch(t *testing.T) {
+	h := events.NewHub(10)
+	evs := []events.Event{
+		events.New(events.KeyCreated, "hooks", "company-1", "key-1"),
+		events.New(events.KeyCreated, "user", "user-1", "key-2"),
+		events.New(events.KeyRotated, "hooks", "company-1", "key-3"),
+		events.New(events.KeyRevoked, "hooks", "company-2", "key-4"),
+	}
+	for _, e := range evs {
+		_ = h.Publish(context.Background(), e)
+	}
+
+	all := collect(t, h, events.Filter{}, "0", 4)
+	for i, id := range all.ids {
+		if id != evs[i].ID {
+			t.Errorf("event %d = %s, want %s", i, id, evs[i].ID)
+		}
+	}
+
+	company := collect(t, h, events.Filter{Owner: "company-1", KeyType: "hooks"}, "0", 2)
+	if company.ids[0] != evs[0].ID || company.ids[1] != evs[2].ID {
+		t.Errorf("filtered events = %v, want %s and %s", company.ids, evs[0].ID, evs[2].ID)
+	}
+
+	resumed := collect(t, h, events.Filter{}, company.cursors[0], 3)
+	if resumed.ids[0] != evs[1].ID {
+		t.Errorf("resumed from %s at %s, want %s", company.cursors[0], resumed.ids[0], evs[1].ID)
+	}
+}
+
+func TestHub_WatchLive(t *testing.T) {
+	h := events.NewHub(1000)
+	e := events.New(events.KeyExpired, "hooks", "company", "key")
+
+	done := make(chan seen)
+	ready := make(chan struct{})
+	go func() {
+		var s seen
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		close(ready)
+		_ = h.Watch(ctx, events.Filter{}, "", func(e events.Event, cursor string) error {
+			s.ids = append(s.ids, e.ID)
+			cancel()
+			return nil
+		})
+		done <- s
+	}()
+
+	<-ready
+	// publish until the watcher has caught one, it may not be waiting yet on the first
+	for {
+		_ = h.Publish(context.Background(), e)
+		select {
+		case s := <-done:
+			if len(s.ids) != 1 || s.ids[0] != e.ID {
+				t.Errorf("live events = %v, want %s", s.ids, e.ID)
+			}
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestHub_Cursors(t *testing.T) {
+	h := events.NewHub(2)
+	for i := 0; i < 4; i++ {
+		_ = h.Publish(context.Background(), events.New(events.KeyCreated, "hooks", "company", "key"))
+	}
+
+	tests := []struct {
+		name   string
+		cursor string
+		want   error
+	}{
+		{
+			name:   "not a cursor",
+			cursor: "abc",
+			want:   events.ErrInvalidCursor,
+		},
+		{
+			name:   "fallen out of the buffer",
+			cursor: "1",
+			want:   events.ErrCursorExpired,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := h.Watch(context.Background(), events.Filter{}, tt.cursor, func(e events.Event, cursor string) error {
+				return nil
+			})
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Watch() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
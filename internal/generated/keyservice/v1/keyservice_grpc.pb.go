@@ -0,0 +1,241 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             v3.21.12
+// source: keyservice/v1/keyservice.proto
+
+package v1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// ExtendedKeyServiceClient is the client API for ExtendedKeyService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ExtendedKeyServiceClient interface {
+	VerifySignature(ctx context.Context, in *VerifySignatureRequest, opts ...grpc.CallOption) (*VerifySignatureResponse, error)
+	RevokeKey(ctx context.Context, in *RevokeKeyRequest, opts ...grpc.CallOption) (*RevokeKeyResponse, error)
+	QueryAuditLog(ctx context.Context, in *AuditLogRequest, opts ...grpc.CallOption) (*AuditLogResponse, error)
+	WatchKeys(ctx context.Context, in *WatchKeysRequest, opts ...grpc.CallOption) (ExtendedKeyService_WatchKeysClient, error)
+}
+
+type extendedKeyServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewExtendedKeyServiceClient(cc grpc.ClientConnInterface) ExtendedKeyServiceClient {
+	return &extendedKeyServiceClient{cc}
+}
+
+func (c *extendedKeyServiceClient) VerifySignature(ctx context.Context, in *VerifySignatureRequest, opts ...grpc.CallOption) (*VerifySignatureResponse, error) {
+	out := new(VerifySignatureResponse)
+	err := c.cc.Invoke(ctx, "/keyservice.v1.ExtendedKeyService/VerifySignature", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *extendedKeyServiceClient) RevokeKey(ctx context.Context, in *RevokeKeyRequest, opts ...grpc.CallOption) (*RevokeKeyResponse, error) {
+	out := new(RevokeKeyResponse)
+	err := c.cc.Invoke(ctx, "/keyservice.v1.ExtendedKeyService/RevokeKey", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *extendedKeyServiceClient) QueryAuditLog(ctx context.Context, in *AuditLogRequest, opts ...grpc.CallOption) (*AuditLogResponse, error) {
+	out := new(AuditLogResponse)
+	err := c.cc.Invoke(ctx, "/keyservice.v1.ExtendedKeyService/QueryAuditLog", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *extendedKeyServiceClient) WatchKeys(ctx context.Context, in *WatchKeysRequest, opts ...grpc.CallOption) (ExtendedKeyService_WatchKeysClient, error) {
+	stream, err := c.cc.NewStream(ctx, &ExtendedKeyService_ServiceDesc.Streams[0], "/keyservice.v1.ExtendedKeyService/WatchKeys", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &extendedKeyServiceWatchKeysClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type ExtendedKeyService_WatchKeysClient interface {
+	Recv() (*KeyEvent, error)
+	grpc.ClientStream
+}
+
+type extendedKeyServiceWatchKeysClient struct {
+	grpc.ClientStream
+}
+
+func (x *extendedKeyServiceWatchKeysClient) Recv() (*KeyEvent, error) {
+	m := new(KeyEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ExtendedKeyServiceServer is the server API for ExtendedKeyService service.
+// All implementations must embed UnimplementedExtendedKeyServiceServer
+// for forward compatibility
+type ExtendedKeyServiceServer interface {
+	VerifySignature(context.Context, *VerifySignatureRequest) (*VerifySignatureResponse, error)
+	RevokeKey(context.Context, *RevokeKeyRequest) (*RevokeKeyResponse, error)
+	QueryAuditLog(context.Context, *AuditLogRequest) (*AuditLogResponse, error)
+	WatchKeys(*WatchKeysRequest, ExtendedKeyService_WatchKeysServer) error
+	mustEmbedUnimplementedExtendedKeyServiceServer()
+}
+
+// UnimplementedExtendedKeyServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedExtendedKeyServiceServer struct {
+}
+
+func (UnimplementedExtendedKeyServiceServer) VerifySignature(context.Context, *VerifySignatureRequest) (*VerifySignatureResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method VerifySignature not implemented")
+}
+func (UnimplementedExtendedKeyServiceServer) RevokeKey(context.Context, *RevokeKeyRequest) (*RevokeKeyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RevokeKey not implemented")
+}
+func (UnimplementedExtendedKeyServiceServer) QueryAuditLog(context.Context, *AuditLogRequest) (*AuditLogResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method QueryAuditLog not implemented")
+}
+func (UnimplementedExtendedKeyServiceServer) WatchKeys(*WatchKeysRequest, ExtendedKeyService_WatchKeysServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchKeys not implemented")
+}
+func (UnimplementedExtendedKeyServiceServer) mustEmbedUnimplementedExtendedKeyServiceServer() {}
+
+// UnsafeExtendedKeyServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ExtendedKeyServiceServer will
+// result in compilation errors.
+type UnsafeExtendedKeyServiceServer interface {
+	mustEmbedUnimplementedExtendedKeyServiceServer()
+}
+
+func RegisterExtendedKeyServiceServer(s grpc.ServiceRegistrar, srv ExtendedKeyServiceServer) {
+	s.RegisterService(&ExtendedKeyService_ServiceDesc, srv)
+}
+
+func _ExtendedKeyService_VerifySignature_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VerifySignatureRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ExtendedKeyServiceServer).VerifySignature(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/keyservice.v1.ExtendedKeyService/VerifySignature",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ExtendedKeyServiceServer).VerifySignature(ctx, req.(*VerifySignatureRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ExtendedKeyService_RevokeKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RevokeKeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ExtendedKeyServiceServer).RevokeKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/keyservice.v1.ExtendedKeyService/RevokeKey",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ExtendedKeyServiceServer).RevokeKey(ctx, req.(*RevokeKeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ExtendedKeyService_QueryAuditLog_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AuditLogRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ExtendedKeyServiceServer).QueryAuditLog(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/keyservice.v1.ExtendedKeyService/QueryAuditLog",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ExtendedKeyServiceServer).QueryAuditLog(ctx, req.(*AuditLogRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ExtendedKeyService_WatchKeys_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchKeysRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ExtendedKeyServiceServer).WatchKeys(m, &extendedKeyServiceWatchKeysServer{stream})
+}
+
+type ExtendedKeyService_WatchKeysServer interface {
+	Send(*KeyEvent) error
+	grpc.ServerStream
+}
+
+type extendedKeyServiceWatchKeysServer struct {
+	grpc.ServerStream
+}
+
+func (x *extendedKeyServiceWatchKeysServer) Send(m *KeyEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// ExtendedKeyService_ServiceDesc is the grpc.ServiceDesc for ExtendedKeyService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ExtendedKeyService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "keyservice.v1.ExtendedKeyService",
+	HandlerType: (*ExtendedKeyServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "VerifySignature",
+			Handler:    _ExtendedKeyService_VerifySignature_Handler,
+		},
+		{
+			MethodName: "RevokeKey",
+			Handler:    _ExtendedKeyService_RevokeKey_Handler,
+		},
+		{
+			MethodName: "QueryAuditLog",
+			Handler:    _ExtendedKeyService_QueryAuditLog_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchKeys",
+			Handler:       _ExtendedKeyService_WatchKeys_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "keyservice/v1/keyservice.proto",
+}
@@ -0,0 +1,166 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const ok = "ok"
+
+// Check is one dependency the service needs before it can take traffic
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+type Status struct {
+	Ready  bool              `json:"ready"`
+	Checks map[string]string `json:"checks"`
+}
+
+// Monitor runs the checks in the background so probes never wait on a dependency, the http readiness
+// endpoint and the grpc health service both report its last result
+type Monitor struct {
+	Checks   []Check
+	Interval time.Duration
+	Timeout  time.Duration
+	// Accepting is false before the service has started and once it is shutting down
+	Accepting func() bool
+	GRPC      *grpchealth.Server
+	Services  []string
+	Logger    log.Logger
+
+	mu     sync.RWMutex
+	status Status
+}
+
+func NewMonitor(interval, timeout time.Duration, accepting func() bool, logger log.Logger, checks ...Check) *Monitor {
+	return &Monitor{
+		Checks:    checks,
+		Interval:  interval,
+		Timeout:   timeout,
+		Accepting: accepting,
+		GRPC:      grpchealth.NewServer(),
+		Logger:    logger,
+	}
+}
+
+// Run updates the status every interval until the context is done
+func (m *Monitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.Interval)
+	defer ticker.Stop()
+
+	for {
+		m.Update(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Update runs every check at once and records the result
+func (m *Monitor) Update(ctx context.Context) Status {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	results := make([]string, len(m.Checks))
+	var wg sync.WaitGroup
+	for i, c := range m.Checks {
+		wg.Add(1)
+		go func(i int, c Check) {
+			defer wg.Done()
+			results[i] = ok
+			if err := c.Run(ctx); err != nil {
+				results[i] = err.Error()
+			}
+		}(i, c)
+	}
+	wg.Wait()
+
+	status := Status{
+		Ready:  m.Accepting(),
+		Checks: make(map[string]string, len(m.Checks)),
+	}
+	for i, c := range m.Checks {
+		status.Checks[c.Name] = results[i]
+		if results[i] != ok {
+			status.Ready = false
+		}
+	}
+
+	m.mu.Lock()
+	changed := m.status.Ready != status.Ready
+	m.status = status
+	m.mu.Unlock()
+
+	if changed && !status.Ready {
+		_ = level.Warn(m.Logger).Log("msg", "not ready", "checks", fmtChecks(status.Checks))
+	}
+	m.setServing(status.Ready)
+
+	return status
+}
+
+func fmtChecks(checks map[string]string) string {
+	b, _ := json.Marshal(checks)
+	return string(b)
+}
+
+func (m *Monitor) setServing(ready bool) {
+	serving := healthpb.HealthCheckResponse_NOT_SERVING
+	if ready {
+		serving = healthpb.HealthCheckResponse_SERVING
+	}
+
+	m.GRPC.SetServingStatus("", serving)
+	for _, s := range m.Services {
+		m.GRPC.SetServingStatus(s, serving)
+	}
+}
+
+func (m *Monitor) Status() Status {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.status
+}
+
+// Shutdown reports not serving from now on, whatever the checks say
+func (m *Monitor) Shutdown() {
+	m.mu.Lock()
+	m.status.Ready = false
+	m.mu.Unlock()
+
+	m.GRPC.Shutdown()
+}
+
+// ReadyHandler is the readiness probe, it answers 503 with the failing checks when the service isn't ready
+func (m *Monitor) ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	status := m.Status()
+	// shutting down takes effect straight away rather than on the next update
+	if !m.Accepting() {
+		status.Ready = false
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !status.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(status)
+}
+
+// LiveHandler is the liveness probe, it only says the process is still answering
+func LiveHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
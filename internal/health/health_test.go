@@ -0,0 +1,100 @@
+package health_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/k8sdeploy/key-service/internal/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestMonitor(t *testing.T) {
+	var mongoErr error
+	accepting := true
+
+	m := health.NewMonitor(time.Second, time.Second, func() bool { return accepting }, log.NewNopLogger(),
+		health.Check{Name: "mongo", Run: func(ctx context.Context) error { return mongoErr }},
+		health.Check{Name: "service_keys", Run: func(ctx context.Context) error { return nil }},
+	)
+	m.Services = []string{"key.v1.KeyService"}
+
+	tests := []struct {
+		name        string
+		mongoErr    error
+		accepting   bool
+		wantReady   bool
+		wantCode    int
+		wantServing healthpb.HealthCheckResponse_ServingStatus
+	}{
+		{
+			name:        "all good",
+			accepting:   true,
+			wantReady:   true,
+			wantCode:    http.StatusOK,
+			wantServing: healthpb.HealthCheckResponse_SERVING,
+		},
+		{
+			name:        "mongo down",
+			mongoErr:    errors.New("auth failed"),
+			accepting:   true,
+			wantCode:    http.StatusServiceUnavailable,
+			wantServing: healthpb.HealthCheckResponse_NOT_SERVING,
+		},
+		{
+			name:        "shutting down",
+			accepting:   false,
+			wantCode:    http.StatusServiceUnavailable,
+			wantServing: healthpb.HealthCheckResponse_NOT_SERVING,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mongoErr = tt.mongoErr
+			accepting = tt.accepting
+
+			status := m.Update(context.Background())
+			if status.Ready != tt.wantReady {
+				t.Errorf("Update().Ready = %v, want %v", status.Ready, tt.wantReady)
+			}
+			if tt.mongoErr != nil && status.Checks["mongo"] != tt.mongoErr.Error() {
+				t.Errorf("mongo check = %q, want %q", status.Checks["mongo"], tt.mongoErr.Error())
+			}
+
+			w := httptest.NewRecorder()
+			m.ReadyHandler(w, httptest.NewRequest(http.MethodGet, "/ready", nil))
+			if w.Code != tt.wantCode {
+				t.Errorf("ReadyHandler() = %d, want %d", w.Code, tt.wantCode)
+			}
+
+			for _, service := range []string{"", "key.v1.KeyService"} {
+				resp, err := m.GRPC.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+				if err != nil {
+					t.Fatalf("Check(%q) = %v", service, err)
+				}
+				if resp.Status != tt.wantServing {
+					t.Errorf("Check(%q) = %v, want %v", service, resp.Status, tt.wantServing)
+				}
+			}
+		})
+	}
+}
+
+func TestMonitor_Shutdown(t *testing.T) {
+	m := health.NewMonitor(time.Second, time.Second, func() bool { return true }, log.NewNopLogger())
+	m.Update(context.Background())
+	m.Shutdown()
+
+	resp, err := m.GRPC.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatalf("Check() = %v", err)
+	}
+	if resp.Status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("Check() after Shutdown() = %v, want NOT_SERVING", resp.Status)
+	}
+}
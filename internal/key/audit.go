@@ -0,0 +1,263 @@
+package key
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/k8sdeploy/key-service/internal/audit"
+	kspb "github.com/k8sdeploy/key-service/internal/generated/keyservice/v1"
+	"github.com/k8sdeploy/key-service/internal/metrics"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const appendAttempts = 5
+
+// MongoAudit is the append only audit store, records are only ever inserted, the sequence number is the
+// document id so two replicas appending at once can't both chain onto the same record
+type MongoAudit struct {
+	*Mongo
+}
+
+// NewMongoAudit keeps the audit log through m, sharing its client
+func NewMongoAudit(m *Mongo) *MongoAudit {
+	return &MongoAudit{
+		Mongo: m,
+	}
+}
+
+func NewAudit(m *Mongo) audit.Store {
+	if m.Config.Audit.Backend == "memory" {
+		return audit.NewMemory([]byte(m.Config.Audit.HMACKey))
+	}
+
+	return NewMongoAudit(m)
+}
+
+func (m *MongoAudit) collection(client *mongo.Client) *mongo.Collection {
+	return client.
+		Database(m.Config.Audit.Database).
+		Collection(m.Config.Audit.Collection)
+}
+
+func (m *MongoAudit) Append(ctx context.Context, r audit.Record) (audit.Record, error) {
+	defer metrics.MongoTimer("audit_append").ObserveDuration()
+	ctx, cancel := m.writeContext(ctx)
+	defer cancel()
+
+	client, err := m.getConnection(ctx)
+	if err != nil {
+		return r, err
+	}
+	defer m.disconnect(ctx, client)
+
+	col := m.collection(client)
+	for i := 0; i < appendAttempts; i++ {
+		var prev *audit.Record
+		var last audit.Record
+		err := col.FindOne(ctx, bson.D{}, options.FindOne().SetSort(bson.D{{Key: "_id", Value: -1}})).Decode(&last)
+		switch {
+		case err == nil:
+			prev = &last
+		case !errors.Is(err, mongo.ErrNoDocuments):
+			return r, err
+		}
+
+		chained := audit.Chain([]byte(m.Config.Audit.HMACKey), prev, r)
+		if _, err := col.InsertOne(ctx, chained); err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				// another replica got there first, chain onto its record instead
+				continue
+			}
+			return r, err
+		}
+
+		return chained, nil
+	}
+
+	return r, errors.New("audit append lost too many races")
+}
+
+func (m *MongoAudit) find(ctx context.Context, filter bson.M) ([]audit.Record, error) {
+	client, err := m.getConnection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer m.disconnect(ctx, client)
+
+	cur, err := m.collection(client).Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	var records []audit.Record
+	if err := cur.All(ctx, &records); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+func (m *MongoAudit) Query(ctx context.Context, f audit.Filter) ([]audit.Record, error) {
+	defer metrics.MongoTimer("audit_query").ObserveDuration()
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	filter := bson.M{}
+	if f.Owner != "" {
+		filter["owner"] = f.Owner
+	}
+	times := bson.M{}
+	if !f.From.IsZero() {
+		times["$gte"] = f.From
+	}
+	if !f.To.IsZero() {
+		times["$lte"] = f.To
+	}
+	if len(times) > 0 {
+		filter["time"] = times
+	}
+
+	return m.find(ctx, filter)
+}
+
+func (m *MongoAudit) Range(ctx context.Context, from, to int64) ([]audit.Record, error) {
+	defer metrics.MongoTimer("audit_range").ObserveDuration()
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	return m.find(ctx, bson.M{"_id": bson.M{"$gte": from, "$lte": to}})
+}
+
+// audit writes the record, a failure to audit is logged but doesn't fail the request
+func (s *Server) audit(ctx context.Context, r audit.Record) {
+	if s.Audit == nil {
+		return
+	}
+
+	r.Time = time.Now()
+	if _, err := s.Audit.Append(ctx, r); err != nil {
+		_ = level.Error(s.logger(ctx)).Log("msg", "writing audit record", "action", r.Action, "owner", r.Owner, "key_id", r.KeyID, "err", err)
+	}
+}
+
+func (s *Server) QueryAuditLog(c context.Context, r *kspb.AuditLogRequest) (*kspb.AuditLogResponse, error) {
+	if err := s.checkServiceKey(r.ServiceKey); err != nil {
+		return nil, err
+	}
+
+	if r.CompanyId == "" {
+		return nil, missingField("company_id", MissingCompanyID)
+	}
+
+	if s.Audit == nil {
+		_ = level.Error(s.logger(c)).Log("msg", "querying audit log", "err", "no audit store")
+		return nil, systemError(c, errors.New("no audit store"))
+	}
+
+	f := audit.Filter{
+		Owner: r.CompanyId,
+	}
+	if r.From != nil {
+		f.From = r.From.AsTime()
+	}
+	if r.To != nil {
+		f.To = r.To.AsTime()
+	}
+	records, err := s.Audit.Query(c, f)
+	if err != nil {
+		_ = level.Error(s.logger(c)).Log("msg", "querying audit log", "company_id", r.CompanyId, "err", err)
+		return nil, systemError(c, err)
+	}
+
+	intact, err := s.auditIntact(c, records)
+	if err != nil {
+		_ = level.Error(s.logger(c)).Log("msg", "verifying audit chain", "company_id", r.CompanyId, "err", err)
+		return nil, systemError(c, err)
+	}
+
+	resp := &kspb.AuditLogResponse{
+		Intact: intact,
+	}
+	for _, rec := range records {
+		resp.Records = append(resp.Records, &kspb.AuditRecord{
+			Seq:      rec.Seq,
+			Time:     timestamppb.New(rec.Time),
+			Action:   rec.Action,
+			Actor:    rec.Actor,
+			KeyType:  rec.KeyType,
+			Owner:    rec.Owner,
+			KeyId:    rec.KeyID,
+			Outcome:  rec.Outcome,
+			PrevHash: rec.PrevHash,
+			Hash:     rec.Hash,
+		})
+	}
+
+	return resp, nil
+}
+
+// auditIntact verifies the stretch of the chain the records being returned fall in, anchored on the record
+// before it, then checks the records are the ones in it. a company's records aren't next to each other in
+// the chain, so they can't be verified alone, but nothing before the anchor needs reading
+func (s *Server) auditIntact(ctx context.Context, records []audit.Record) (bool, error) {
+	if len(records) == 0 {
+		return true, nil
+	}
+	from, to := records[0].Seq, records[0].Seq
+	for _, r := range records {
+		if r.Seq < from {
+			from = r.Seq
+		}
+		if r.Seq > to {
+			to = r.Seq
+		}
+	}
+	if from > 1 {
+		from--
+	}
+
+	chain, err := s.Audit.Range(ctx, from, to)
+	if err != nil {
+		return false, err
+	}
+	if len(chain) == 0 || chain[0].Seq != from {
+		_ = level.Warn(s.logger(ctx)).Log("msg", "audit chain is missing the anchor record", "seq", from)
+		return false, nil
+	}
+
+	key := []byte(s.Config.Audit.HMACKey)
+	if err := audit.VerifyRange(key, chain); err != nil {
+		_ = level.Warn(s.logger(ctx)).Log("msg", "audit chain failed verification", "err", err)
+		return false, nil
+	}
+	if err := audit.Contains(chain, records); err != nil {
+		_ = level.Warn(s.logger(ctx)).Log("msg", "audit records don't match the chain", "err", err)
+		return false, nil
+	}
+
+	return true, nil
+}
+
+func (s *Server) auditFailure(ctx context.Context, keyType, serviceKey, owner, keyID string) {
+	s.audit(ctx, audit.Record{
+		Action:  audit.ValidationFailed,
+		Actor:   s.Principal(serviceKey),
+		KeyType: keyType,
+		Owner:   owner,
+		KeyID:   keyID,
+		Outcome: audit.Failure,
+	})
+}
+
+func lifecycleAction(rotated bool) string {
+	if rotated {
+		return audit.Rotate
+	}
+
+	return audit.Create
+}
@@ -0,0 +1,175 @@
+package key_test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/k8sdeploy/key-service/internal/audit"
+	"github.com/k8sdeploy/key-service/internal/config"
+	kspb "github.com/k8sdeploy/key-service/internal/generated/keyservice/v1"
+	"github.com/k8sdeploy/key-service/internal/key"
+	"github.com/k8sdeploy/key-service/internal/logging"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// editedStore hands back records that have been edited since they were appended
+type editedStore struct {
+	*audit.Memory
+}
+
+func (e editedStore) Query(ctx context.Context, f audit.Filter) ([]audit.Record, error) {
+	records, err := e.Memory.Query(ctx, f)
+	for i := range records {
+		records[i].Actor = "someone-else"
+		records[i].Hash = audit.Hash(e.Key, records[i])
+	}
+
+	return records, err
+}
+
+func auditLog(hmacKey string) *audit.Memory {
+	store := audit.NewMemory([]byte(hmacKey))
+	start := time.Unix(1670000000, 0)
+	for i, owner := range []string{"company-1", "company-2", "company-1"} {
+		_, _ = store.Append(context.Background(), audit.Record{
+			Time:    start.Add(time.Duration(i) * time.Minute),
+			Action:  audit.Create,
+			Actor:   key.HooksPrincipal,
+			Owner:   owner,
+			KeyID:   "key",
+			Outcome: audit.Success,
+		})
+	}
+
+	return store
+}
+
+func TestServer_QueryAuditLog(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.HooksService.Key = "hooks-service-key"
+	cfg.Audit.HMACKey = "audit-hmac-key"
+
+	start := time.Unix(1670000000, 0)
+	store := auditLog(cfg.Audit.HMACKey)
+
+	s := &key.Server{
+		Config: cfg,
+		Audit:  store,
+		Logger: logging.New(io.Discard, false),
+	}
+
+	_, err := s.QueryAuditLog(context.Background(), &kspb.AuditLogRequest{
+		ServiceKey: "wrong-key",
+		CompanyId:  "company-1",
+	})
+	if status.Code(err) != codes.PermissionDenied || key.Reason(err) != key.ReasonInvalidServiceKey {
+		t.Errorf("QueryAuditLog() with bad service key = %v, want PermissionDenied", err)
+	}
+
+	resp, err := s.QueryAuditLog(context.Background(), &kspb.AuditLogRequest{
+		ServiceKey: "hooks-service-key",
+		CompanyId:  "company-1",
+		From:       timestamppb.New(start.Add(time.Minute)),
+	})
+	if err != nil {
+		t.Fatalf("QueryAuditLog() = %v", err)
+	}
+	if len(resp.Records) != 1 || resp.Records[0].Seq != 3 {
+		t.Errorf("QueryAuditLog() records = %v, want only seq 3", resp.Records)
+	}
+	if !resp.Intact {
+		t.Errorf("QueryAuditLog() intact = false, want true")
+	}
+}
+
+func TestServer_QueryAuditLog_Intact(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.HooksService.Key = "hooks-service-key"
+	cfg.Audit.HMACKey = "audit-hmac-key"
+
+	tests := []struct {
+		name  string
+		store audit.Store
+		want  bool
+	}{
+		{
+			name:  "untouched",
+			store: auditLog(cfg.Audit.HMACKey),
+			want:  true,
+		},
+		{
+			name:  "chain written without the key",
+			store: auditLog("guessed-key"),
+		},
+		{
+			name:  "queried records edited",
+			store: editedStore{Memory: auditLog(cfg.Audit.HMACKey)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &key.Server{
+				Config: cfg,
+				Audit:  tt.store,
+				Logger: logging.New(io.Discard, false),
+			}
+
+			resp, err := s.QueryAuditLog(context.Background(), &kspb.AuditLogRequest{
+				ServiceKey: "hooks-service-key",
+				CompanyId:  "company-1",
+			})
+			if err != nil {
+				t.Fatalf("QueryAuditLog() = %v", err)
+			}
+			if resp.Intact != tt.want {
+				t.Errorf("QueryAuditLog() intact = %v, want %v", resp.Intact, tt.want)
+			}
+		})
+	}
+}
+
+// rangeStore remembers the stretch of the chain that was read
+type rangeStore struct {
+	*audit.Memory
+	from, to int64
+}
+
+func (r *rangeStore) Range(ctx context.Context, from, to int64) ([]audit.Record, error) {
+	r.from, r.to = from, to
+
+	return r.Memory.Range(ctx, from, to)
+}
+
+func TestServer_QueryAuditLog_ReadsFromAnchor(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.HooksService.Key = "hooks-service-key"
+	cfg.Audit.HMACKey = "audit-hmac-key"
+
+	store := &rangeStore{Memory: auditLog(cfg.Audit.HMACKey)}
+	s := &key.Server{
+		Config: cfg,
+		Audit:  store,
+		Logger: logging.New(io.Discard, false),
+	}
+
+	// only the third record is asked for, so the chain is read from the second rather than the first
+	resp, err := s.QueryAuditLog(context.Background(), &kspb.AuditLogRequest{
+		ServiceKey: "hooks-service-key",
+		CompanyId:  "company-1",
+		From:       timestamppb.New(time.Unix(1670000000, 0).Add(90 * time.Second)),
+	})
+	if err != nil {
+		t.Fatalf("QueryAuditLog() = %v", err)
+	}
+	if len(resp.Records) != 1 || !resp.Intact {
+		t.Fatalf("QueryAuditLog() = %d records, intact %v, want 1 intact", len(resp.Records), resp.Intact)
+	}
+	if store.from != 2 || store.to != 3 {
+		t.Errorf("read the chain from %d to %d, want 2 to 3", store.from, store.to)
+	}
+}
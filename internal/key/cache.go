@@ -0,0 +1,141 @@
+package key
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/k8sdeploy/key-service/internal/cache"
+	"github.com/k8sdeploy/key-service/internal/config"
+	"github.com/k8sdeploy/key-service/internal/events"
+	"github.com/k8sdeploy/key-service/internal/metrics"
+)
+
+// cached states
+const (
+	revokedState = "revoked"
+	expiredState = "expired"
+)
+
+// NewCache returns the validation cache named in the config, nil when caching is turned off
+func NewCache(c *config.Config, logger log.Logger) cache.Cache {
+	switch c.Cache.Backend {
+	case "none", "":
+		return nil
+	case "redis":
+		maxTTL := c.Cache.TTL
+		if c.Cache.NegativeTTL > maxTTL {
+			maxTTL = c.Cache.NegativeTTL
+		}
+		return cache.NewRedis(c.Cache.RedisAddress, c.Cache.RedisPassword, c.Cache.RedisDB, maxTTL)
+	}
+
+	m, err := cache.NewMemory(c.Cache.Size)
+	if err != nil {
+		_ = level.Warn(logger).Log("msg", "creating validation cache, validating without one", "err", err)
+		return nil
+	}
+
+	return m
+}
+
+func stateError(state string) error {
+	switch state {
+	case revokedState:
+		return ErrRevoked
+	case expiredState:
+		return ErrExpired
+	default:
+		return nil
+	}
+}
+
+// validate answers from the cache when it can and otherwise asks lookup, failures are cached too so
+// the same wrong secret being tried over and over doesn't reach mongo each time
+func (s *Server) validate(ctx context.Context, keyType, owner, key, secret string, lookup func() (bool, error)) (bool, error) {
+	if s.Cache == nil {
+		return lookup()
+	}
+
+	// the owner is normalized the way the store keeps it, so revoking a key drops the results cached for it
+	owner = normalizeOwner(owner)
+	cacheKey := cache.Key(keyType, owner, key, secret)
+	r, hit, err := s.Cache.Get(ctx, cacheKey)
+	if err != nil {
+		_ = level.Warn(s.logger(ctx)).Log("msg", "reading validation cache", "err", err)
+	}
+	metrics.CacheLookup(hit)
+	if hit {
+		return r.Valid, stateError(r.State)
+	}
+
+	// the generation is taken before the lookup, if the key is rotated or revoked while the lookup runs
+	// what it found isn't cached
+	group := cache.Owner(keyType, owner)
+	generation, err := s.Cache.Generation(ctx, group)
+	if err != nil {
+		_ = level.Warn(s.logger(ctx)).Log("msg", "reading validation cache generation", "err", err)
+		return lookup()
+	}
+
+	valid, err := lookup()
+	switch {
+	case err == nil:
+		r = cache.Result{Valid: valid}
+	case errors.Is(err, ErrRevoked):
+		r = cache.Result{State: revokedState}
+	case errors.Is(err, ErrExpired):
+		r = cache.Result{State: expiredState}
+	default:
+		// don't cache the store being down
+		return valid, err
+	}
+
+	ttl := s.Config.Cache.NegativeTTL
+	if r.Valid {
+		ttl = s.Config.Cache.TTL
+	}
+	if err := s.Cache.Set(ctx, group, cacheKey, generation, r, ttl); err != nil {
+		_ = level.Warn(s.logger(ctx)).Log("msg", "writing validation cache", "err", err)
+	}
+
+	return valid, stateError(r.State)
+}
+
+// invalidate drops every cached result for the owner's key
+func (s *Server) invalidate(ctx context.Context, keyType, owner string) {
+	if s.Cache == nil {
+		return
+	}
+
+	owner = normalizeOwner(owner)
+	if err := s.Cache.Invalidate(ctx, cache.Owner(keyType, owner)); err != nil {
+		_ = level.Warn(s.logger(ctx)).Log("msg", "invalidating validation cache", "key_type", keyType, "owner", owner, "err", err)
+	}
+}
+
+// InvalidateOnEvents drops cached results whenever any replica changes a key, it runs until the context is done
+func (s *Server) InvalidateOnEvents(ctx context.Context) {
+	if s.Cache == nil || s.Watcher == nil {
+		return
+	}
+
+	for {
+		err := s.Watcher.Watch(ctx, events.Filter{}, "", func(e events.Event, cursor string) error {
+			s.invalidate(ctx, e.KeyType, e.Owner)
+			return nil
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		_ = level.Warn(s.Logger).Log("msg", "watching keys for cache invalidation, retrying", "err", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
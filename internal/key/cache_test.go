@@ -0,0 +1,239 @@
+package key_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/k8sdeploy/key-service/internal/cache"
+	"github.com/k8sdeploy/key-service/internal/config"
+	"github.com/k8sdeploy/key-service/internal/events"
+	kspb "github.com/k8sdeploy/key-service/internal/generated/keyservice/v1"
+	"github.com/k8sdeploy/key-service/internal/key"
+	"github.com/k8sdeploy/key-service/internal/logging"
+	pb "github.com/k8sdeploy/protos/generated/key/v1"
+)
+
+type lookups struct {
+	calls int
+	valid bool
+	err   error
+}
+
+func (l *lookups) lookup() (bool, error) {
+	l.calls++
+	return l.valid, l.err
+}
+
+func cachedServer(t *testing.T) *key.Server {
+	t.Helper()
+
+	cfg := &config.Config{}
+	cfg.Cache.TTL = time.Minute
+	cfg.Cache.NegativeTTL = time.Minute
+
+	c, err := cache.NewMemory(10)
+	if err != nil {
+		t.Fatalf("NewMemory() = %v", err)
+	}
+
+	return &key.Server{
+		Config: cfg,
+		Cache:  c,
+		Logger: logging.New(io.Discard, false),
+	}
+}
+
+func TestServer_ValidateCache(t *testing.T) {
+	tests := []struct {
+		name      string
+		lookup    lookups
+		wantValid bool
+		wantErr   error
+		wantCalls int
+	}{
+		{
+			name:      "valid is cached",
+			lookup:    lookups{valid: true},
+			wantValid: true,
+			wantCalls: 1,
+		},
+		{
+			name:      "invalid is cached",
+			lookup:    lookups{},
+			wantCalls: 1,
+		},
+		{
+			name:      "revoked is cached",
+			lookup:    lookups{err: key.ErrRevoked},
+			wantErr:   key.ErrRevoked,
+			wantCalls: 1,
+		},
+		{
+			name:      "wrapped revoked is cached",
+			lookup:    lookups{err: fmt.Errorf("hooks key: %w", key.ErrRevoked)},
+			wantErr:   key.ErrRevoked,
+			wantCalls: 1,
+		},
+		{
+			name:      "store errors are not cached",
+			lookup:    lookups{err: errors.New("mongo down")},
+			wantErr:   errors.New("mongo down"),
+			wantCalls: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := cachedServer(t)
+			l := tt.lookup
+
+			for i := 0; i < 3; i++ {
+				valid, err := key.Validate(s, context.Background(), key.HooksKeyType, "company", "key", "secret", l.lookup)
+				if valid != tt.wantValid {
+					t.Errorf("validate() = %v, want %v", valid, tt.wantValid)
+				}
+				if (err == nil) != (tt.wantErr == nil) || (err != nil && err.Error() != tt.wantErr.Error()) {
+					t.Errorf("validate() error = %v, want %v", err, tt.wantErr)
+				}
+			}
+			if l.calls != tt.wantCalls {
+				t.Errorf("lookup called %d times, want %d", l.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestServer_ValidateCache_InvalidatedDuringLookup(t *testing.T) {
+	s := cachedServer(t)
+	ctx := context.Background()
+
+	calls := 0
+	lookup := func() (bool, error) {
+		calls++
+		if calls == 1 {
+			// revoked on another replica while this lookup still sees the key as valid
+			if err := s.Cache.Invalidate(ctx, cache.Owner(key.HooksKeyType, "company")); err != nil {
+				t.Fatalf("Invalidate() = %v", err)
+			}
+			return true, nil
+		}
+		return false, key.ErrRevoked
+	}
+
+	if valid, err := key.Validate(s, ctx, key.HooksKeyType, "company", "key", "secret", lookup); !valid || err != nil {
+		t.Fatalf("validate() = %v, %v, want the lookup's answer", valid, err)
+	}
+	if _, err := key.Validate(s, ctx, key.HooksKeyType, "company", "key", "secret", lookup); !errors.Is(err, key.ErrRevoked) {
+		t.Errorf("validate() after the invalidation = %v, want %v rather than the stale cached result", err, key.ErrRevoked)
+	}
+}
+
+func TestServer_InvalidateOnEvents(t *testing.T) {
+	s := cachedServer(t)
+	hub := events.NewHub(10)
+	s.Watcher = hub
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.InvalidateOnEvents(ctx)
+
+	l := &lookups{valid: true}
+	_, _ = key.Validate(s, ctx, key.HooksKeyType, "company", "key", "secret", l.lookup)
+
+	// keep rotating until the watcher has picked it up and the next validation misses the cache
+	deadline := time.Now().Add(5 * time.Second)
+	for l.calls == 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("cache was never invalidated by the rotate event")
+		}
+		_ = hub.Publish(ctx, events.New(events.KeyRotated, key.HooksKeyType, "company", "new-key"))
+		time.Sleep(10 * time.Millisecond)
+		_, _ = key.Validate(s, ctx, key.HooksKeyType, "company", "key", "secret", l.lookup)
+	}
+}
+
+func TestServer_RevokeThenValidateCached(t *testing.T) {
+	serviceKey := "orchestrator-key"
+
+	tests := []struct {
+		name   string
+		watch  bool
+		revoke func(s *key.Server, m *key.MemoryStore) error
+	}{
+		{
+			name: "revoked through the api",
+			revoke: func(s *key.Server, m *key.MemoryStore) error {
+				_, err := s.RevokeKey(context.Background(), &kspb.RevokeKeyRequest{
+					ServiceKey: serviceKey,
+					KeyType:    key.HooksKeyType,
+					OwnerId:    "company-1",
+					Key:        "key",
+				})
+				return err
+			},
+		},
+		{
+			name:  "revoked on another replica",
+			watch: true,
+			revoke: func(s *key.Server, m *key.MemoryStore) error {
+				_, err := m.RevokeKey(context.Background(), key.HooksKeyType, "company-1", "key")
+				return err
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			s := cachedServer(t)
+			s.Config.Orchestrator.Key = serviceKey
+			hub := events.NewHub(10)
+			m := key.NewMemoryStore(s.Config, hub)
+			s.Store = m
+			if tt.watch {
+				s.Watcher = hub
+				go s.InvalidateOnEvents(ctx)
+			}
+
+			if _, err := m.InsertHooksKey(ctx, key.K8sKey{ID: "company-1", Key: "key", Secret: "secret"}); err != nil {
+				t.Fatalf("InsertHooksKey() = %v", err)
+			}
+			validate := func() error {
+				resp, err := s.ValidateHookKey(ctx, &pb.ValidateSystemKeyRequest{
+					ServiceKey: serviceKey,
+					CompanyId:  "company-1",
+					Key:        "key",
+					Secret:     "secret",
+				})
+				if err == nil && !resp.GetValid() {
+					t.Fatalf("ValidateHookKey() = invalid, want valid or revoked")
+				}
+				return err
+			}
+			if err := validate(); err != nil {
+				t.Fatalf("ValidateHookKey() = %v, want valid", err)
+			}
+
+			if err := tt.revoke(s, m); err != nil {
+				t.Fatalf("revoke = %v", err)
+			}
+
+			// the watcher may not have been listening yet, keep announcing the revoke with the owner the
+			// way the store keeps it until it drops the cached result
+			deadline := time.Now().Add(5 * time.Second)
+			for err := validate(); key.Reason(err) != key.ReasonKeyRevoked; err = validate() {
+				if !tt.watch || time.Now().After(deadline) {
+					t.Fatalf("ValidateHookKey() after revoking = %v, want the key revoked rather than the cached result", err)
+				}
+				_ = hub.Publish(ctx, events.New(events.KeyRevoked, key.HooksKeyType, "company1", "key"))
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	}
+}
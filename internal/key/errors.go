@@ -0,0 +1,166 @@
+package key
+
+import (
+	"context"
+	"errors"
+
+	"github.com/k8sdeploy/key-service/internal/events"
+	"github.com/k8sdeploy/key-service/internal/ratelimit"
+	"github.com/k8sdeploy/key-service/internal/signature"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrorDomain is the google.rpc.ErrorInfo domain on every error the rpcs return
+const ErrorDomain = "key-service.k8sdeploy"
+
+// Reasons are the machine readable google.rpc.ErrorInfo reasons, clients should switch on these rather
+// than the message
+const (
+	ReasonMissingServiceKey = "MISSING_SERVICE_KEY"
+	ReasonInvalidServiceKey = "INVALID_SERVICE_KEY"
+	ReasonMissingField      = "MISSING_FIELD"
+	ReasonUnknownKeyType    = "UNKNOWN_KEY_TYPE"
+	ReasonKeyNotFound       = "KEY_NOT_FOUND"
+	ReasonKeyRevoked        = "KEY_REVOKED"
+	ReasonKeyExpired        = "KEY_EXPIRED"
+	ReasonRateLimited       = "RATE_LIMITED"
+	ReasonLockedOut         = "LOCKED_OUT"
+	ReasonInvalidCursor     = "INVALID_CURSOR"
+	ReasonCursorExpired     = "CURSOR_EXPIRED"
+	ReasonInvalidSignature  = "INVALID_SIGNATURE"
+	ReasonOutsideWindow     = "OUTSIDE_WINDOW"
+	ReasonReplayedNonce     = "REPLAYED_NONCE"
+	ReasonNotImplemented    = "NOT_IMPLEMENTED"
+	ReasonSystemError       = "SYSTEM_ERROR"
+)
+
+// rpcError is a grpc status with an ErrorInfo carrying the reason, plus a BadRequest when there are
+// field violations
+func rpcError(code codes.Code, reason, msg string, violations ...*errdetails.BadRequest_FieldViolation) error {
+	st := status.New(code, msg)
+	info := &errdetails.ErrorInfo{
+		Reason: reason,
+		Domain: ErrorDomain,
+	}
+
+	var err error
+	if len(violations) > 0 {
+		st, err = st.WithDetails(info, &errdetails.BadRequest{FieldViolations: violations})
+	} else {
+		st, err = st.WithDetails(info)
+	}
+	if err != nil {
+		return status.Error(code, msg)
+	}
+
+	return st.Err()
+}
+
+// missingField is the error for a request without a field it needs
+func missingField(field, msg string) error {
+	return rpcError(codes.InvalidArgument, ReasonMissingField, msg, &errdetails.BadRequest_FieldViolation{
+		Field:       field,
+		Description: msg,
+	})
+}
+
+// checkServiceKey is the first thing every rpc does, the caller has to be one of the services we know
+func (s *Server) checkServiceKey(key string) error {
+	if key == "" {
+		return rpcError(codes.Unauthenticated, ReasonMissingServiceKey, MissingServiceKey)
+	}
+	if s.Principal(key) == "" {
+		return rpcError(codes.PermissionDenied, ReasonInvalidServiceKey, InvalidServiceKey)
+	}
+
+	return nil
+}
+
+// systemError is what the caller sees when something went wrong on our side, the cause is logged rather
+// than sent back. a cancelled or timed out request keeps its own code
+func systemError(ctx context.Context, err error) error {
+	switch {
+	case errors.Is(ctx.Err(), context.Canceled), errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, context.Canceled.Error())
+	case errors.Is(ctx.Err(), context.DeadlineExceeded), errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, context.DeadlineExceeded.Error())
+	}
+
+	return rpcError(codes.Internal, ReasonSystemError, SystemError)
+}
+
+// keyStateError is the error for a key that was presented correctly but can no longer be used, nil for
+// anything else
+func keyStateError(err error) error {
+	switch {
+	case errors.Is(err, ErrRevoked):
+		return rpcError(codes.FailedPrecondition, ReasonKeyRevoked, RevokedKey)
+	case errors.Is(err, ErrExpired):
+		return rpcError(codes.FailedPrecondition, ReasonKeyExpired, ExpiredKey)
+	default:
+		return nil
+	}
+}
+
+// limitError is the error for a caller over its rate limit or a key that is locked out, nil for anything else
+func limitError(err error) error {
+	switch {
+	case errors.Is(err, ratelimit.ErrRateLimited):
+		return rpcError(codes.ResourceExhausted, ReasonRateLimited, err.Error())
+	case errors.Is(err, ratelimit.ErrLockedOut):
+		return rpcError(codes.ResourceExhausted, ReasonLockedOut, err.Error())
+	default:
+		return nil
+	}
+}
+
+// cursorError is the error for a WatchKeys cursor that can't be resumed from, nil for anything else
+func cursorError(err error) error {
+	switch {
+	case errors.Is(err, events.ErrInvalidCursor):
+		return rpcError(codes.InvalidArgument, ReasonInvalidCursor, InvalidCursor, &errdetails.BadRequest_FieldViolation{
+			Field:       "cursor",
+			Description: InvalidCursor,
+		})
+	case errors.Is(err, events.ErrCursorExpired):
+		return rpcError(codes.FailedPrecondition, ReasonCursorExpired, ExpiredCursor)
+	default:
+		return nil
+	}
+}
+
+// signatureError is the error for a signed request that was checked and isn't genuine, nil for anything else
+func signatureError(err error) error {
+	switch {
+	case errors.Is(err, signature.ErrInvalidSignature):
+		return rpcError(codes.Unauthenticated, ReasonInvalidSignature, err.Error())
+	case errors.Is(err, signature.ErrOutsideWindow):
+		return rpcError(codes.Unauthenticated, ReasonOutsideWindow, err.Error())
+	case errors.Is(err, signature.ErrReplayedNonce):
+		return rpcError(codes.Unauthenticated, ReasonReplayedNonce, err.Error())
+	default:
+		return nil
+	}
+}
+
+// notImplemented is for the rpcs in the proto this service doesn't do yet
+func notImplemented(method string) error {
+	return rpcError(codes.Unimplemented, ReasonNotImplemented, method+" is not implemented")
+}
+
+// Reason is the ErrorInfo reason on an error from one of the rpcs, empty if it has none
+func Reason(err error) string {
+	st, ok := status.FromError(err)
+	if !ok {
+		return ""
+	}
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			return info.Reason
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,205 @@
+package key
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/k8sdeploy/key-service/internal/config"
+	"github.com/k8sdeploy/key-service/internal/events"
+	"github.com/k8sdeploy/key-service/internal/metrics"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoOutbox is the outbox collection the key writes add their events to
+type MongoOutbox struct {
+	*Mongo
+}
+
+// NewMongoOutbox reads the outbox through m, sharing its client
+func NewMongoOutbox(m *Mongo) *MongoOutbox {
+	return &MongoOutbox{
+		Mongo: m,
+	}
+}
+
+// NewPublisher returns the publisher named in the config, nil when publishing is turned off
+func NewPublisher(c *config.Config) (events.Publisher, error) {
+	switch c.Events.Publisher {
+	case "none", "":
+		return nil, nil
+	case "nats":
+		return events.NewNATS(c.Events.NATSURL, c.Events.NATSSubject)
+	case "webhook":
+		return events.NewWebhook(c.Events.WebhookURL, c.Events.WebhookSecret, c.Events.WebhookTimeout), nil
+	case "memory":
+		return events.NewMemory(), nil
+	default:
+		return nil, fmt.Errorf("unknown events publisher: %s", c.Events.Publisher)
+	}
+}
+
+func NewRelay(m *Mongo, publisher events.Publisher) *events.Relay {
+	c := m.Config
+	r := events.NewRelay(NewMongoOutbox(m), publisher, c.Events.RelayInterval, c.Events.BatchSize, m.Logger)
+	r.MaxAttempts = c.Events.MaxAttempts
+
+	return r
+}
+
+// outboxEnabled is whether anything reads the outbox, either the relay or WatchKeys
+func (m *Mongo) outboxEnabled() bool {
+	return m.publishing() || m.Config.Events.Watch
+}
+
+// publishing is whether the relay publishes the outbox
+func (m *Mongo) publishing() bool {
+	return m.Config.Events.Publisher != "" && m.Config.Events.Publisher != "none"
+}
+
+// outboxEvent is how an event is stored in the outbox, it is kept for a while after it's published so
+// watchers can resume from it. the ttl index only deletes it once it has an expires_at, which is set when
+// it's published, or straight away when there is nothing publishing and it is only there for watchers
+type outboxEvent struct {
+	events.Event `bson:",inline"`
+	ExpiresAt    *time.Time `bson:"expires_at,omitempty"`
+}
+
+func outboxCollection(client *mongo.Client, c *config.Config) *mongo.Collection {
+	return client.
+		Database(c.Events.OutboxDatabase).
+		Collection(c.Events.OutboxCollection)
+}
+
+// withEvents runs fn in a transaction and adds the events it returns to the outbox in that same transaction,
+// so a key change and its events are either both stored or neither is
+func (m *Mongo) withEvents(ctx context.Context, client *mongo.Client, fn func(ctx context.Context) ([]events.Event, error)) error {
+	if !m.outboxEnabled() {
+		_, err := fn(ctx)
+		return err
+	}
+
+	session, err := client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		evs, err := fn(sc)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range evs {
+			stored := outboxEvent{Event: e}
+			if !m.publishing() {
+				expiresAt := e.Time.Add(m.Config.Events.OutboxRetention)
+				stored.ExpiresAt = &expiresAt
+			}
+			if _, err := outboxCollection(client, m.Config).InsertOne(sc, stored); err != nil {
+				return nil, err
+			}
+		}
+
+		return nil, nil
+	})
+
+	return err
+}
+
+func (m *MongoOutbox) Pending(ctx context.Context, limit int) ([]events.Event, error) {
+	defer metrics.MongoTimer("outbox_pending").ObserveDuration()
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	client, err := m.getConnection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer m.disconnect(ctx, client)
+
+	cur, err := outboxCollection(client, m.Config).Find(ctx,
+		bson.M{
+			"published_at":     bson.M{"$exists": false},
+			"dead_lettered_at": bson.M{"$exists": false},
+		},
+		options.Find().
+			SetSort(bson.D{{Key: "time", Value: 1}, {Key: "_id", Value: 1}}).
+			SetLimit(int64(limit)))
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []events.Event
+	if err := cur.All(ctx, &pending); err != nil {
+		return nil, err
+	}
+
+	return pending, nil
+}
+
+func (m *MongoOutbox) MarkPublished(ctx context.Context, id string) error {
+	defer metrics.MongoTimer("outbox_mark_published").ObserveDuration()
+	ctx, cancel := m.writeContext(ctx)
+	defer cancel()
+
+	client, err := m.getConnection(ctx)
+	if err != nil {
+		return err
+	}
+	defer m.disconnect(ctx, client)
+
+	now := time.Now()
+	_, err = outboxCollection(client, m.Config).UpdateOne(ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{
+			"published_at": now,
+			"expires_at":   now.Add(m.Config.Events.OutboxRetention),
+		}})
+
+	return err
+}
+
+func (m *MongoOutbox) MarkFailed(ctx context.Context, id string) (int, error) {
+	defer metrics.MongoTimer("outbox_mark_failed").ObserveDuration()
+	ctx, cancel := m.writeContext(ctx)
+	defer cancel()
+
+	client, err := m.getConnection(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer m.disconnect(ctx, client)
+
+	var stored struct {
+		Attempts int `bson:"attempts"`
+	}
+	err = outboxCollection(client, m.Config).FindOneAndUpdate(ctx,
+		bson.M{"_id": id},
+		bson.M{"$inc": bson.M{"attempts": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).
+		Decode(&stored)
+
+	return stored.Attempts, err
+}
+
+// DeadLetter sets the event aside, it has no expires_at so it is kept until someone looks at it
+func (m *MongoOutbox) DeadLetter(ctx context.Context, id string) error {
+	defer metrics.MongoTimer("outbox_dead_letter").ObserveDuration()
+	ctx, cancel := m.writeContext(ctx)
+	defer cancel()
+
+	client, err := m.getConnection(ctx)
+	if err != nil {
+		return err
+	}
+	defer m.disconnect(ctx, client)
+
+	_, err = outboxCollection(client, m.Config).UpdateOne(ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"dead_lettered_at": time.Now()}})
+
+	return err
+}
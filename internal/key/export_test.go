@@ -0,0 +1,63 @@
+package key
+
+import (
+	"context"
+
+	"github.com/k8sdeploy/key-service/internal/signature"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SetSecretsEqual swaps the comparison used for key checks, the returned func puts the original back
+func SetSecretsEqual(f func(a, b string) bool) func() {
+	original := secretsEqual
+	secretsEqual = f
+
+	return func() {
+		secretsEqual = original
+	}
+}
+
+// SetConnectMongo swaps how clients are opened, the returned func puts the original back
+func SetConnectMongo(f func(ctx context.Context, opts ...*options.ClientOptions) (*mongo.Client, error)) func() {
+	original := connectMongo
+	connectMongo = f
+
+	return func() {
+		connectMongo = original
+	}
+}
+
+// SharedClient is the client Connect opened, nil without one
+func SharedClient(m *Mongo) *mongo.Client {
+	return m.shared()
+}
+
+// Validate is the cached validation every validate rpc goes through
+var Validate = (*Server).validate
+
+// ClientOptions is how getConnection connects to mongo
+var ClientOptions = clientOptions
+
+// IndexSpecs are the indexes EnsureIndexes creates, by database.collection
+func IndexSpecs(m *Mongo) map[string][]mongo.IndexModel {
+	specs := make(map[string][]mongo.IndexModel)
+	for _, s := range m.indexSpecs() {
+		specs[s.db+"."+s.collection] = append(specs[s.db+"."+s.collection], s.models...)
+	}
+
+	return specs
+}
+
+// UseClient makes m share client, the way Connect would
+func UseClient(m *Mongo, client *mongo.Client) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.client = client
+}
+
+// CompanyNonces scopes a nonce store to the company the way VerifySignature does
+func CompanyNonces(companyID string, store signature.NonceStore) signature.NonceStore {
+	return companyNonces{companyID: companyID, store: store}
+}
@@ -2,79 +2,94 @@ package key
 
 import (
 	"context"
-	"fmt"
+	"sync"
 
-	"github.com/hashicorp/vault/sdk/helper/pointerutil"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/k8sdeploy/key-service/internal/audit"
+	"github.com/k8sdeploy/key-service/internal/cache"
 	"github.com/k8sdeploy/key-service/internal/config"
+	"github.com/k8sdeploy/key-service/internal/events"
+	kspb "github.com/k8sdeploy/key-service/internal/generated/keyservice/v1"
+	"github.com/k8sdeploy/key-service/internal/logging"
+	"github.com/k8sdeploy/key-service/internal/metrics"
+	"github.com/k8sdeploy/key-service/internal/ratelimit"
 	pb "github.com/k8sdeploy/protos/generated/key/v1"
+	"google.golang.org/grpc"
 )
 
 type Server struct {
 	pb.UnimplementedKeyServiceServer
-	Config *config.Config
+	kspb.UnimplementedExtendedKeyServiceServer
+	Config  *config.Config
+	Limiter *ratelimit.Limiter
+	Audit   audit.Store
+	Watcher events.Watcher
+	Cache   cache.Cache
+	Store   Store
+	Logger  log.Logger
+
+	initOnce     sync.Once
+	shutdownOnce sync.Once
+	closing      chan struct{}
 }
 
 // Missing
 const (
 	MissingUserID    = "missing user id"
 	MissingCompanyID = "missing company id"
+	MissingKey       = "missing key"
+	MissingOwnerID   = "missing owner id"
 	//	MissingAgentKey   = "missing agent key"
 	MissingServiceKey = "missing service key"
 )
 
+// Principals
+const (
+	HooksPrincipal        = "hooks-service"
+	OrchestratorPrincipal = "orchestrator"
+)
+
 // Status
 const (
 	InvalidServiceKey = "invalid service key"
 	//	InvalidAgentKey   = "invalid agent key"
 	//	InvalidHookKey    = "invalid hook key"
 	//	InvalidUserKey    = "invalid user key"
-	SystemError = "system error"
+	ExpiredKey     = "key expired"
+	RevokedKey     = "key revoked"
+	KeyNotFound    = "key not found"
+	UnknownKeyType = "unknown key type"
+	SystemError    = "system error"
 )
 
 // Agent
 func (s *Server) CreateAgentKeys(c context.Context, r *pb.AgentRequest) (*pb.KeyResponse, error) {
-	if r.ServiceKey != "" {
-		if valid, _ := s.ValidateServiceKey(r.ServiceKey); !valid {
-			return &pb.KeyResponse{
-				Status: pointerutil.StringPtr(InvalidServiceKey),
-			}, nil
-		}
-	} else {
-		return &pb.KeyResponse{
-			Status: pointerutil.StringPtr(MissingServiceKey),
-		}, nil
+	if err := s.checkServiceKey(r.ServiceKey); err != nil {
+		return nil, err
 	}
 
-	return nil, nil
+	return nil, notImplemented("CreateAgentKeys")
 }
 
 func (s *Server) GetAgentKeys(c context.Context, r *pb.AgentRequest) (*pb.KeyResponse, error) {
-	if r.ServiceKey != "" {
-		if valid, _ := s.ValidateServiceKey(r.ServiceKey); !valid {
-			return &pb.KeyResponse{
-				Status: pointerutil.StringPtr(InvalidServiceKey),
-			}, nil
-		}
-	} else {
-		return &pb.KeyResponse{
-			Status: pointerutil.StringPtr(MissingServiceKey),
-		}, nil
+	if err := s.checkServiceKey(r.ServiceKey); err != nil {
+		return nil, err
 	}
 
-	return nil, nil
+	return nil, notImplemented("GetAgentKeys")
 }
 
 func (s *Server) ValidateAgentKey(c context.Context, r *pb.ValidateSystemKeyRequest) (*pb.ValidKeyResponse, error) {
-	if r.ServiceKey != "" {
-		if valid, _ := s.ValidateServiceKey(r.ServiceKey); !valid {
-			return &pb.ValidKeyResponse{
-				Status: pointerutil.StringPtr(InvalidServiceKey),
-			}, nil
-		}
-	} else {
-		return &pb.ValidKeyResponse{
-			Status: pointerutil.StringPtr(MissingServiceKey),
-		}, nil
+	if err := s.checkServiceKey(r.ServiceKey); err != nil {
+		return nil, err
+	}
+
+	if r.CompanyId == "" {
+		return nil, missingField("company_id", MissingCompanyID)
+	}
+	if err := s.limited(c, r.CompanyId, r.Key); err != nil {
+		return nil, err
 	}
 
 	k := K8sKey{
@@ -83,12 +98,21 @@ func (s *Server) ValidateAgentKey(c context.Context, r *pb.ValidateSystemKeyRequ
 		Secret: r.Secret,
 	}
 
-	m := NewMongo(s.Config)
-	valid, err := m.ValidateAgentKey(&k)
+	valid, err := s.validate(c, AgentKeyType, r.CompanyId, r.Key, r.Secret, func() (bool, error) {
+		return s.store().ValidateAgentKey(c, &k)
+	})
+	if stateErr := keyStateError(err); stateErr != nil {
+		s.recordValidation(c, r.CompanyId, r.Key, false)
+		s.auditFailure(c, AgentKeyType, r.ServiceKey, r.CompanyId, r.Key)
+		return nil, stateErr
+	}
 	if err != nil {
-		return &pb.ValidKeyResponse{
-			Status: pointerutil.StringPtr(SystemError),
-		}, err
+		_ = level.Error(s.logger(c)).Log("msg", "validating agent key", "company_id", r.CompanyId, "err", err)
+		return nil, systemError(c, err)
+	}
+	s.recordValidation(c, r.CompanyId, r.Key, valid)
+	if !valid {
+		s.auditFailure(c, AgentKeyType, r.ServiceKey, r.CompanyId, r.Key)
 	}
 
 	return &pb.ValidKeyResponse{
@@ -98,38 +122,24 @@ func (s *Server) ValidateAgentKey(c context.Context, r *pb.ValidateSystemKeyRequ
 
 // Hooks
 func (s *Server) CreateHookKeys(c context.Context, r *pb.HooksRequest) (*pb.KeyResponse, error) {
-	if r.ServiceKey != "" {
-		if valid, _ := s.ValidateServiceKey(r.ServiceKey); !valid {
-			return &pb.KeyResponse{
-				Status: pointerutil.StringPtr(InvalidServiceKey),
-			}, nil
-		}
-	} else {
-		return &pb.KeyResponse{
-			Status: pointerutil.StringPtr(MissingServiceKey),
-		}, nil
+	if err := s.checkServiceKey(r.ServiceKey); err != nil {
+		return nil, err
 	}
 
 	if r.CompanyId == "" {
-		return &pb.KeyResponse{
-			Status: pointerutil.StringPtr(MissingCompanyID),
-		}, nil
+		return nil, missingField("company_id", MissingCompanyID)
 	}
 
 	k := NewKey(s.Config)
 	hk, err := k.GenerateKey(32)
 	if err != nil {
-		fmt.Printf("error generating hook key: %s\n", err)
-		return &pb.KeyResponse{
-			Status: pointerutil.StringPtr(SystemError),
-		}, err
+		_ = level.Error(s.logger(c)).Log("msg", "generating hook key", "err", err)
+		return nil, systemError(c, err)
 	}
 	hs, err := k.GenerateKey(32)
 	if err != nil {
-		fmt.Printf("error generating hook secret: %s\n", err)
-		return &pb.KeyResponse{
-			Status: pointerutil.StringPtr(SystemError),
-		}, err
+		_ = level.Error(s.logger(c)).Log("msg", "generating hook secret", "err", err)
+		return nil, systemError(c, err)
 	}
 	d := K8sKey{
 		ID:     r.CompanyId,
@@ -137,14 +147,24 @@ func (s *Server) CreateHookKeys(c context.Context, r *pb.HooksRequest) (*pb.KeyR
 		Secret: hs,
 	}
 
-	m := NewMongo(s.Config)
-	if err := m.InsertHooksKey(d); err != nil {
-		fmt.Printf("error inserting hook key: %s\n", err)
-		return &pb.KeyResponse{
-			Status: pointerutil.StringPtr(SystemError),
-		}, err
+	m := s.store()
+	rotated, err := m.InsertHooksKey(c, d)
+	if err != nil {
+		_ = level.Error(s.logger(c)).Log("msg", "inserting hook key", "company_id", r.CompanyId, "err", err)
+		return nil, systemError(c, err)
 	}
 
+	metrics.KeyCreated(HooksKeyType)
+	s.invalidate(c, HooksKeyType, r.CompanyId)
+	s.audit(c, audit.Record{
+		Action:  lifecycleAction(rotated),
+		Actor:   s.Principal(r.ServiceKey),
+		KeyType: HooksKeyType,
+		Owner:   r.CompanyId,
+		KeyID:   hk,
+		Outcome: audit.Success,
+	})
+
 	return &pb.KeyResponse{
 		Key:    hk,
 		Secret: hs,
@@ -152,72 +172,55 @@ func (s *Server) CreateHookKeys(c context.Context, r *pb.HooksRequest) (*pb.KeyR
 }
 
 func (s *Server) GetHookKeys(c context.Context, r *pb.HooksRequest) (*pb.KeyResponse, error) {
-	if r.ServiceKey != "" {
-		if valid, _ := s.ValidateServiceKey(r.ServiceKey); !valid {
-			return &pb.KeyResponse{
-				Status: pointerutil.StringPtr(InvalidServiceKey),
-			}, nil
-		}
-	} else {
-		return &pb.KeyResponse{
-			Status: pointerutil.StringPtr(MissingServiceKey),
-		}, nil
+	if err := s.checkServiceKey(r.ServiceKey); err != nil {
+		return nil, err
 	}
 
-	return nil, nil
+	return nil, notImplemented("GetHookKeys")
 }
 
 func (s *Server) GetHookKeysForCompany(c context.Context, r *pb.HooksRequest) (*pb.MultipleHooksResponse, error) {
-	if r.ServiceKey != "" {
-		if valid, _ := s.ValidateServiceKey(r.ServiceKey); !valid {
-			return &pb.MultipleHooksResponse{
-				Status: pointerutil.StringPtr(InvalidServiceKey),
-			}, nil
-		}
-	} else {
-		return &pb.MultipleHooksResponse{
-			Status: pointerutil.StringPtr(MissingServiceKey),
-		}, nil
+	if err := s.checkServiceKey(r.ServiceKey); err != nil {
+		return nil, err
 	}
 
-	return nil, nil
+	return nil, notImplemented("GetHookKeysForCompany")
 }
 
 func (s *Server) ValidateHookKey(c context.Context, r *pb.ValidateSystemKeyRequest) (*pb.ValidKeyResponse, error) {
-	if r.ServiceKey != "" {
-		if valid, _ := s.ValidateServiceKey(r.ServiceKey); !valid {
-			return &pb.ValidKeyResponse{
-				Valid:  false,
-				Status: pointerutil.StringPtr(InvalidServiceKey),
-			}, nil
-		}
-	} else {
-		return &pb.ValidKeyResponse{
-			Valid:  false,
-			Status: pointerutil.StringPtr(MissingServiceKey),
-		}, nil
+	if err := s.checkServiceKey(r.ServiceKey); err != nil {
+		return nil, err
 	}
 
 	if r.CompanyId == "" {
-		return &pb.ValidKeyResponse{
-			Valid:  false,
-			Status: pointerutil.StringPtr(MissingCompanyID),
-		}, nil
+		return nil, missingField("company_id", MissingCompanyID)
 	}
-	valid, err := NewMongo(s.Config).ValidateHooksKey(K8sKey{
-		ID:     r.CompanyId,
-		Key:    r.Key,
-		Secret: r.Secret,
+	if err := s.limited(c, r.CompanyId, r.Key); err != nil {
+		return nil, err
+	}
+
+	valid, err := s.validate(c, HooksKeyType, r.CompanyId, r.Key, r.Secret, func() (bool, error) {
+		return s.store().ValidateHooksKey(c, K8sKey{
+			ID:     r.CompanyId,
+			Key:    r.Key,
+			Secret: r.Secret,
+		})
 	})
+	if stateErr := keyStateError(err); stateErr != nil {
+		s.recordValidation(c, r.CompanyId, r.Key, false)
+		s.auditFailure(c, HooksKeyType, r.ServiceKey, r.CompanyId, r.Key)
+		return nil, stateErr
+	}
 	if err != nil {
-		fmt.Printf("validate key error: %v\n", err)
-		return &pb.ValidKeyResponse{
-			Valid:  false,
-			Status: pointerutil.StringPtr(SystemError),
-		}, err
+		_ = level.Error(s.logger(c)).Log("msg", "validating hook key", "company_id", r.CompanyId, "err", err)
+		return nil, systemError(c, err)
+	}
+
+	s.recordValidation(c, r.CompanyId, r.Key, valid)
+	if !valid {
+		s.auditFailure(c, HooksKeyType, r.ServiceKey, r.CompanyId, r.Key)
 	}
 
-	fmt.Printf("validate key: %v\n", valid)
 	return &pb.ValidKeyResponse{
 		Valid: valid,
 	}, nil
@@ -225,38 +228,24 @@ func (s *Server) ValidateHookKey(c context.Context, r *pb.ValidateSystemKeyReque
 
 // User
 func (s *Server) CreateUserKeys(c context.Context, r *pb.UserRequest) (*pb.KeyResponse, error) {
-	if r.ServiceKey != "" {
-		if valid, _ := s.ValidateServiceKey(r.ServiceKey); !valid {
-			return &pb.KeyResponse{
-				Status: pointerutil.StringPtr(InvalidServiceKey),
-			}, nil
-		}
-	} else {
-		return &pb.KeyResponse{
-			Status: pointerutil.StringPtr(MissingServiceKey),
-		}, nil
+	if err := s.checkServiceKey(r.ServiceKey); err != nil {
+		return nil, err
 	}
 
 	if r.UserId == "" {
-		return &pb.KeyResponse{
-			Status: pointerutil.StringPtr(MissingUserID),
-		}, nil
+		return nil, missingField("user_id", MissingUserID)
 	}
 
 	k := NewKey(s.Config)
 	uk, err := k.GenerateKey(32)
 	if err != nil {
-		fmt.Printf("error generating user key: %s\n", err)
-		return &pb.KeyResponse{
-			Status: pointerutil.StringPtr(SystemError),
-		}, err
+		_ = level.Error(s.logger(c)).Log("msg", "generating user key", "err", err)
+		return nil, systemError(c, err)
 	}
 	us, err := k.GenerateKey(32)
 	if err != nil {
-		fmt.Printf("error generating user secret: %s\n", err)
-		return &pb.KeyResponse{
-			Status: pointerutil.StringPtr(SystemError),
-		}, err
+		_ = level.Error(s.logger(c)).Log("msg", "generating user secret", "err", err)
+		return nil, systemError(c, err)
 	}
 	d := UserKey{
 		ID:     r.UserId,
@@ -264,14 +253,24 @@ func (s *Server) CreateUserKeys(c context.Context, r *pb.UserRequest) (*pb.KeyRe
 		Secret: us,
 	}
 
-	m := NewMongo(s.Config)
-	if err := m.UpsertUser(d); err != nil {
-		fmt.Printf("error upserting user: %s\n", err)
-		return &pb.KeyResponse{
-			Status: pointerutil.StringPtr(SystemError),
-		}, err
+	m := s.store()
+	rotated, err := m.UpsertUser(c, d)
+	if err != nil {
+		_ = level.Error(s.logger(c)).Log("msg", "upserting user key", "user_id", r.UserId, "err", err)
+		return nil, systemError(c, err)
 	}
 
+	metrics.KeyCreated(UserKeyType)
+	s.invalidate(c, UserKeyType, r.UserId)
+	s.audit(c, audit.Record{
+		Action:  lifecycleAction(rotated),
+		Actor:   s.Principal(r.ServiceKey),
+		KeyType: UserKeyType,
+		Owner:   r.UserId,
+		KeyID:   uk,
+		Outcome: audit.Success,
+	})
+
 	return &pb.KeyResponse{
 		Key:    uk,
 		Secret: us,
@@ -279,38 +278,102 @@ func (s *Server) CreateUserKeys(c context.Context, r *pb.UserRequest) (*pb.KeyRe
 }
 
 func (s *Server) ValidateUserKeys(c context.Context, r *pb.ValidateUserKeyRequest) (*pb.ValidKeyResponse, error) {
-	if r.ServiceKey != "" {
-		if valid, _ := s.ValidateServiceKey(r.ServiceKey); !valid {
-			fmt.Printf("invalid service key: %s\n", r.ServiceKey)
-			return &pb.ValidKeyResponse{
-				Valid:  false,
-				Status: pointerutil.StringPtr(InvalidServiceKey),
-			}, nil
+	if err := s.checkServiceKey(r.ServiceKey); err != nil {
+		if r.ServiceKey != "" {
+			_ = level.Warn(s.logger(c)).Log("msg", InvalidServiceKey, "method", "ValidateUserKeys")
 		}
-	} else {
-		return &pb.ValidKeyResponse{
-			Valid:  false,
-			Status: pointerutil.StringPtr(MissingServiceKey),
-		}, nil
+		return nil, err
+	}
+
+	if r.UserId == "" {
+		return nil, missingField("user_id", MissingUserID)
+	}
+
+	if err := s.limited(c, "", r.UserId); err != nil {
+		return nil, err
+	}
+
+	valid, err := s.validate(c, UserKeyType, r.UserId, r.Key, r.Secret, func() (bool, error) {
+		return s.store().ValidateUserKey(c, UserKey{
+			ID:     r.UserId,
+			Key:    r.Key,
+			Secret: r.Secret,
+		})
+	})
+	if stateErr := keyStateError(err); stateErr != nil {
+		s.recordValidation(c, "", r.UserId, false)
+		s.auditFailure(c, UserKeyType, r.ServiceKey, r.UserId, r.Key)
+		return nil, stateErr
+	}
+	if err != nil {
+		_ = level.Error(s.logger(c)).Log("msg", "validating user key", "user_id", r.UserId, "err", err)
+		return nil, systemError(c, err)
+	}
+	s.recordValidation(c, "", r.UserId, valid)
+	if !valid {
+		s.auditFailure(c, UserKeyType, r.ServiceKey, r.UserId, r.Key)
 	}
 
 	return &pb.ValidKeyResponse{
-		Valid: false,
+		Valid: valid,
 	}, nil
 }
 
+func (s *Server) closingChan() chan struct{} {
+	s.initOnce.Do(func() {
+		s.closing = make(chan struct{})
+	})
+
+	return s.closing
+}
+
+// Shutdown ends open streams so a graceful stop doesn't wait on them, callers resume elsewhere from their cursor
+func (s *Server) Shutdown() {
+	s.shutdownOnce.Do(func() {
+		close(s.closingChan())
+	})
+}
+
+func (s *Server) logger(ctx context.Context) log.Logger {
+	return logging.FromContext(ctx, s.Logger)
+}
+
+func (s *Server) mongo() *Mongo {
+	m := NewMongo(s.Config)
+	m.Logger = s.Logger
+
+	return m
+}
+
 func (s *Server) ValidateServiceKey(key string) (bool, error) {
-	fmt.Printf("validating service key: %s, hooksService: %+v, orchestratorService: %+v\n",
-		key,
-		s.Config.HooksService,
-		s.Config.Orchestrator)
+	return s.Principal(key) != "", nil
+}
 
-	if key == s.Config.HooksService.Key ||
-		key == s.Config.Orchestrator.Key {
-		return true, nil
+// Principal is the name of the service the service key belongs to, empty if it isn't one we know
+func (s *Server) Principal(key string) string {
+	services := s.Config.ServiceKeys()
+	principal := ""
+	if services.HooksService.Key != "" && secretsEqual(services.HooksService.Key, key) {
+		principal = HooksPrincipal
 	}
+	if services.Orchestrator.Key != "" && secretsEqual(services.Orchestrator.Key, key) {
+		principal = OrchestratorPrincipal
+	}
+
+	return principal
+}
+
+// PrincipalInterceptor tags the request with the calling service so it shows on every log line
+func (s *Server) PrincipalInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if r, ok := req.(interface{ GetServiceKey() string }); ok {
+			if principal := s.Principal(r.GetServiceKey()); principal != "" {
+				logging.SetPrincipal(ctx, principal)
+			}
+		}
 
-	return false, nil
+		return handler(ctx, req)
+	}
 }
 
 // func (s *Server) CreateAgentKeys(c context.Context, r *pb.CreateRequest) (*pb.KeyResponse, error) {
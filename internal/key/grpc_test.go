@@ -0,0 +1,349 @@
+package key_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/k8sdeploy/key-service/internal/config"
+	"github.com/k8sdeploy/key-service/internal/events"
+	kspb "github.com/k8sdeploy/key-service/internal/generated/keyservice/v1"
+	"github.com/k8sdeploy/key-service/internal/key"
+	"github.com/k8sdeploy/key-service/internal/logging"
+	"github.com/k8sdeploy/key-service/internal/ratelimit"
+	"github.com/k8sdeploy/key-service/internal/signature"
+	pb "github.com/k8sdeploy/protos/generated/key/v1"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestServer_DoesNotLogSecrets(t *testing.T) {
+	hooksServiceKey := "hooksServiceKeyValue"
+	orchestratorKey := "orchestratorKeyValue"
+	mongoPassword := "mongoPasswordValue"
+	presentedServiceKey := "presentedServiceKeyValue"
+	presentedKey := "presentedKeyValue"
+	presentedSecret := "presentedSecretValue"
+
+	cfg := &config.Config{}
+	cfg.HooksService.Key = hooksServiceKey
+	cfg.Orchestrator.Key = orchestratorKey
+	cfg.Mongo.Username = "user"
+	cfg.Mongo.Password = mongoPassword
+
+	var buf bytes.Buffer
+	s := &key.Server{
+		Config:  cfg,
+		Limiter: ratelimit.NewLimiter(ratelimit.NewMemory(), ratelimit.Limits{MaxFailures: 1}),
+		Logger:  logging.New(&buf, true),
+	}
+
+	ctx := context.Background()
+	_, _ = s.ValidateUserKeys(ctx, &pb.ValidateUserKeyRequest{
+		ServiceKey: presentedServiceKey,
+		UserId:     "user",
+		Key:        presentedKey,
+		Secret:     presentedSecret,
+	})
+	_, _ = s.ValidateUserKeys(ctx, &pb.ValidateUserKeyRequest{
+		ServiceKey: hooksServiceKey,
+		UserId:     "user",
+		Key:        presentedKey,
+		Secret:     presentedSecret,
+	})
+	_, _ = s.ValidateHookKey(ctx, &pb.ValidateSystemKeyRequest{
+		ServiceKey: orchestratorKey,
+		CompanyId:  "company",
+		Key:        presentedKey,
+		Secret:     presentedSecret,
+	})
+	_, _ = s.CreateHookKeys(ctx, &pb.HooksRequest{
+		ServiceKey: hooksServiceKey,
+		CompanyId:  "company",
+	})
+	_, _ = s.VerifySignature(ctx, &kspb.VerifySignatureRequest{
+		ServiceKey: hooksServiceKey,
+		CompanyId:  "company",
+		Key:        presentedKey,
+		Nonce:      "nonce",
+		Signature:  presentedSecret,
+	})
+
+	if buf.Len() == 0 {
+		t.Fatal("nothing was logged, the test isn't exercising anything")
+	}
+
+	for name, secret := range map[string]string{
+		"hooks service key":     hooksServiceKey,
+		"orchestrator key":      orchestratorKey,
+		"mongo password":        mongoPassword,
+		"presented service key": presentedServiceKey,
+		"presented key":         presentedKey,
+		"presented secret":      presentedSecret,
+	} {
+		if strings.Contains(buf.String(), secret) {
+			t.Errorf("%s found in log output: %s", name, buf.String())
+		}
+	}
+}
+
+// failingStore is a store that is down
+type failingStore struct {
+	key.Store
+}
+
+func (failingStore) ValidateHooksKey(ctx context.Context, data key.K8sKey) (bool, error) {
+	return false, errors.New("mongo down")
+}
+
+func TestServer_ErrorCodes(t *testing.T) {
+	serviceKey := "orchestrator-key"
+	cfg := &config.Config{}
+	cfg.Orchestrator.Key = serviceKey
+	cfg.SignatureWindow = time.Minute
+
+	signed := func(secret string, timestamp int64) *kspb.VerifySignatureRequest {
+		return &kspb.VerifySignatureRequest{
+			ServiceKey: serviceKey,
+			CompanyId:  "company",
+			Key:        "key",
+			Payload:    []byte("payload"),
+			Timestamp:  timestamp,
+			Nonce:      "nonce",
+			Signature:  signature.Sign(secret, timestamp, "nonce", []byte("payload")),
+		}
+	}
+
+	tests := []struct {
+		name       string
+		store      func(m *key.MemoryStore) key.Store
+		call       func(s *key.Server) error
+		wantCode   codes.Code
+		wantReason string
+		wantField  string
+	}{
+		{
+			name: "missing service key",
+			call: func(s *key.Server) error {
+				_, err := s.ValidateHookKey(context.Background(), &pb.ValidateSystemKeyRequest{CompanyId: "company"})
+				return err
+			},
+			wantCode:   codes.Unauthenticated,
+			wantReason: key.ReasonMissingServiceKey,
+		},
+		{
+			name: "invalid service key",
+			call: func(s *key.Server) error {
+				_, err := s.ValidateHookKey(context.Background(), &pb.ValidateSystemKeyRequest{ServiceKey: "wrong", CompanyId: "company"})
+				return err
+			},
+			wantCode:   codes.PermissionDenied,
+			wantReason: key.ReasonInvalidServiceKey,
+		},
+		{
+			name: "missing company",
+			call: func(s *key.Server) error {
+				_, err := s.ValidateHookKey(context.Background(), &pb.ValidateSystemKeyRequest{ServiceKey: serviceKey})
+				return err
+			},
+			wantCode:   codes.InvalidArgument,
+			wantReason: key.ReasonMissingField,
+			wantField:  "company_id",
+		},
+		{
+			name: "missing agent company",
+			call: func(s *key.Server) error {
+				_, err := s.ValidateAgentKey(context.Background(), &pb.ValidateSystemKeyRequest{
+					ServiceKey: serviceKey,
+					Key:        "key",
+					Secret:     "secret",
+				})
+				return err
+			},
+			wantCode:   codes.InvalidArgument,
+			wantReason: key.ReasonMissingField,
+			wantField:  "company_id",
+		},
+		{
+			name: "wrong secret",
+			call: func(s *key.Server) error {
+				resp, err := s.ValidateHookKey(context.Background(), &pb.ValidateSystemKeyRequest{
+					ServiceKey: serviceKey,
+					CompanyId:  "company",
+					Key:        "key",
+					Secret:     "wrong",
+				})
+				if err == nil && resp.GetValid() {
+					t.Errorf("ValidateHookKey() with wrong secret is valid")
+				}
+				return err
+			},
+			wantCode: codes.OK,
+		},
+		{
+			name: "revoked key",
+			store: func(m *key.MemoryStore) key.Store {
+				if _, err := m.RevokeKey(context.Background(), key.HooksKeyType, "company", "key"); err != nil {
+					t.Fatalf("RevokeKey() = %v", err)
+				}
+				return m
+			},
+			call: func(s *key.Server) error {
+				_, err := s.ValidateHookKey(context.Background(), &pb.ValidateSystemKeyRequest{
+					ServiceKey: serviceKey,
+					CompanyId:  "company",
+					Key:        "key",
+					Secret:     "secret",
+				})
+				return err
+			},
+			wantCode:   codes.FailedPrecondition,
+			wantReason: key.ReasonKeyRevoked,
+		},
+		{
+			name: "revoke unknown key",
+			call: func(s *key.Server) error {
+				_, err := s.RevokeKey(context.Background(), &kspb.RevokeKeyRequest{
+					ServiceKey: serviceKey,
+					KeyType:    key.HooksKeyType,
+					OwnerId:    "company",
+					Key:        "other",
+				})
+				return err
+			},
+			wantCode:   codes.NotFound,
+			wantReason: key.ReasonKeyNotFound,
+		},
+		{
+			name: "revoke unknown key type",
+			call: func(s *key.Server) error {
+				_, err := s.RevokeKey(context.Background(), &kspb.RevokeKeyRequest{
+					ServiceKey: serviceKey,
+					KeyType:    "other",
+					OwnerId:    "company",
+					Key:        "key",
+				})
+				return err
+			},
+			wantCode:   codes.InvalidArgument,
+			wantReason: key.ReasonUnknownKeyType,
+			wantField:  "key_type",
+		},
+		{
+			name: "invalid signature",
+			call: func(s *key.Server) error {
+				_, err := s.VerifySignature(context.Background(), signed("wrong", time.Now().Unix()))
+				return err
+			},
+			wantCode:   codes.Unauthenticated,
+			wantReason: key.ReasonInvalidSignature,
+		},
+		{
+			name: "unknown signing key",
+			call: func(s *key.Server) error {
+				r := signed("secret", time.Now().Unix())
+				r.Key = "other"
+				_, err := s.VerifySignature(context.Background(), r)
+				return err
+			},
+			wantCode:   codes.Unauthenticated,
+			wantReason: key.ReasonInvalidSignature,
+		},
+		{
+			name: "signature outside window",
+			call: func(s *key.Server) error {
+				_, err := s.VerifySignature(context.Background(), signed("secret", time.Now().Add(-time.Hour).Unix()))
+				return err
+			},
+			wantCode:   codes.Unauthenticated,
+			wantReason: key.ReasonOutsideWindow,
+		},
+		{
+			name: "replayed nonce",
+			call: func(s *key.Server) error {
+				r := signed("secret", time.Now().Unix())
+				resp, err := s.VerifySignature(context.Background(), r)
+				if err != nil || !resp.GetValid() {
+					t.Fatalf("first VerifySignature() = %v, %v, want valid", resp, err)
+				}
+				_, err = s.VerifySignature(context.Background(), r)
+				return err
+			},
+			wantCode:   codes.Unauthenticated,
+			wantReason: key.ReasonReplayedNonce,
+		},
+		{
+			name: "not implemented",
+			call: func(s *key.Server) error {
+				_, err := s.GetHookKeys(context.Background(), &pb.HooksRequest{ServiceKey: serviceKey})
+				return err
+			},
+			wantCode:   codes.Unimplemented,
+			wantReason: key.ReasonNotImplemented,
+		},
+		{
+			name: "store down",
+			store: func(m *key.MemoryStore) key.Store {
+				return failingStore{Store: m}
+			},
+			call: func(s *key.Server) error {
+				_, err := s.ValidateHookKey(context.Background(), &pb.ValidateSystemKeyRequest{
+					ServiceKey: serviceKey,
+					CompanyId:  "company",
+					Key:        "key",
+					Secret:     "secret",
+				})
+				if err != nil && strings.Contains(err.Error(), "mongo down") {
+					t.Errorf("ValidateHookKey() = %v, leaks the cause", err)
+				}
+				return err
+			},
+			wantCode:   codes.Internal,
+			wantReason: key.ReasonSystemError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := key.NewMemoryStore(cfg, events.NewMemory())
+			if _, err := m.InsertHooksKey(context.Background(), key.K8sKey{ID: "company", Key: "key", Secret: "secret"}); err != nil {
+				t.Fatalf("InsertHooksKey() = %v", err)
+			}
+			var store key.Store = m
+			if tt.store != nil {
+				store = tt.store(m)
+			}
+
+			s := &key.Server{
+				Config: cfg,
+				Store:  store,
+				Logger: logging.New(&bytes.Buffer{}, false),
+			}
+
+			err := tt.call(s)
+			if got := status.Code(err); got != tt.wantCode {
+				t.Fatalf("code = %v (%v), want %v", got, err, tt.wantCode)
+			}
+			if got := key.Reason(err); got != tt.wantReason {
+				t.Errorf("reason = %q, want %q", got, tt.wantReason)
+			}
+			if tt.wantField == "" {
+				return
+			}
+
+			field := ""
+			for _, d := range status.Convert(err).Details() {
+				if br, ok := d.(*errdetails.BadRequest); ok && len(br.FieldViolations) > 0 {
+					field = br.FieldViolations[0].Field
+				}
+			}
+			if field != tt.wantField {
+				t.Errorf("field violation = %q, want %q", field, tt.wantField)
+			}
+		})
+	}
+}
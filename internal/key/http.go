@@ -5,8 +5,9 @@ import (
 	"net/http"
 	"time"
 
-	bugLog "github.com/bugfixes/go-bugfixes/logs"
 	"github.com/go-chi/chi/v5"
+	"github.com/go-kit/log/level"
+	"github.com/k8sdeploy/key-service/internal/logging"
 )
 
 type ResponseItem struct {
@@ -52,14 +53,14 @@ func (k Key) CreateHandler(w http.ResponseWriter, r *http.Request) {
 
 	keys, err := k.GetKeys(25)
 	if err != nil {
-		bugLog.Info(err)
+		_ = level.Error(logging.FromContext(r.Context(), k.Logger)).Log("msg", "generating keys", "err", err)
 		jsonResponse(w, http.StatusInternalServerError, &ResponseItem{
 			Status: "internal error",
 		})
 		return
 	}
 
-	if err := NewMongo(k.Config).Create(DataSet{
+	if err := k.mongo().Create(r.Context(), DataSet{
 		UserID:    userID,
 		Generated: time.Now().Unix(),
 		Keys: struct {
@@ -78,7 +79,7 @@ func (k Key) CreateHandler(w http.ResponseWriter, r *http.Request) {
 			Orchestrator:       keys.Orchestrator,
 		},
 	}); err != nil {
-		bugLog.Info(err)
+		_ = level.Error(logging.FromContext(r.Context(), k.Logger)).Log("msg", "creating keys", "user_id", userID, "err", err)
 		jsonResponse(w, http.StatusInternalServerError, &ResponseItem{
 			Status: "internal error",
 		})
@@ -109,9 +110,9 @@ func (k Key) GetHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	keys, err := NewMongo(k.Config).Get(userID)
+	keys, err := k.mongo().Get(r.Context(), userID)
 	if err != nil {
-		bugLog.Info(err)
+		_ = level.Error(logging.FromContext(r.Context(), k.Logger)).Log("msg", "getting keys", "user_id", userID, "err", err)
 		jsonResponse(w, http.StatusInternalServerError, &ResponseItem{
 			Status: "internal error",
 		})
@@ -153,9 +154,9 @@ func (k Key) ValidateHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	keys, err := NewMongo(k.Config).Get(userID)
+	keys, err := k.mongo().Get(r.Context(), userID)
 	if err != nil {
-		bugLog.Info(err)
+		_ = level.Error(logging.FromContext(r.Context(), k.Logger)).Log("msg", "getting keys", "user_id", userID, "err", err)
 		jsonResponse(w, http.StatusInternalServerError, &ResponseItem{
 			Status: "internal error",
 		})
@@ -169,17 +170,7 @@ func (k Key) ValidateHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userKey := keys.Keys.UserService
-	hooksKey := keys.Keys.HooksService
-	companyKey := keys.Keys.CompanyService
-	billingKey := keys.Keys.BillingService
-	permissionsKey := keys.Keys.PermissionsService
-
-	if checkKey == userKey ||
-		checkKey == hooksKey ||
-		checkKey == companyKey ||
-		checkKey == billingKey ||
-		checkKey == permissionsKey {
+	if keys.Matches(checkKey) {
 		jsonResponse(w, http.StatusOK, &ResponseItem{
 			Status: "ok",
 		})
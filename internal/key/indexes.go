@@ -0,0 +1,187 @@
+package key
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/k8sdeploy/key-service/internal/config"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrIndexesPending is what readiness reports until the indexes have been created
+var ErrIndexesPending = errors.New("indexes not created yet")
+
+// collectionIndexes are the indexes one collection needs
+type collectionIndexes struct {
+	db         string
+	collection string
+	models     []mongo.IndexModel
+}
+
+// indexSpecs are the indexes the queries rely on. the upserts match on the owner alone, so that is unique
+// for hooks and user keys, otherwise two racing upserts could both insert. keys are marked expired rather
+// than deleted so their expires_at is a plain index for the sweep, nonces, outbox events and rate limit
+// counters and locks are deleted by a ttl index once they expire
+func (m *Mongo) indexSpecs() []collectionIndexes {
+	c := m.Config.MongoConfig()
+	stores := m.stores()
+
+	unique := func(keys ...string) mongo.IndexModel {
+		d := bson.D{}
+		for _, k := range keys {
+			d = append(d, bson.E{Key: k, Value: 1})
+		}
+		return mongo.IndexModel{Keys: d, Options: options.Index().SetUnique(true)}
+	}
+	expiresAt := mongo.IndexModel{Keys: bson.D{{Key: "expires_at", Value: 1}}}
+	ttl := mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+
+	specs := []collectionIndexes{
+		{
+			db:         c.Hooks.Database,
+			collection: c.Hooks.KeysCollection,
+			models: []mongo.IndexModel{
+				unique(stores[HooksKeyType].owner),
+				unique(stores[HooksKeyType].owner, stores[HooksKeyType].key),
+				expiresAt,
+			},
+		},
+		{
+			db:         c.Agent.Database,
+			collection: c.Agent.KeysCollection,
+			models: []mongo.IndexModel{
+				unique(stores[AgentKeyType].owner, stores[AgentKeyType].key),
+				expiresAt,
+			},
+		},
+		{
+			db:         c.User.Database,
+			collection: c.User.KeysCollection,
+			models: []mongo.IndexModel{
+				unique(stores[UserKeyType].owner),
+				unique(stores[UserKeyType].owner, stores[UserKeyType].key),
+				expiresAt,
+			},
+		},
+		{
+			db:         c.Hooks.Database,
+			collection: c.Hooks.NoncesCollection,
+			models:     []mongo.IndexModel{ttl},
+		},
+		{
+			db:         c.Legacy.Database,
+			collection: c.Legacy.KeysCollection,
+			models:     []mongo.IndexModel{unique("user_id")},
+		},
+	}
+	if m.Config.RateLimit.Backend == "mongo" {
+		specs = append(specs, collectionIndexes{
+			db:         m.Config.RateLimit.Database,
+			collection: m.Config.RateLimit.Collection,
+			models:     []mongo.IndexModel{ttl},
+		})
+	}
+	if m.outboxEnabled() {
+		specs = append(specs, collectionIndexes{
+			db:         m.Config.Events.OutboxDatabase,
+			collection: m.Config.Events.OutboxCollection,
+			models:     []mongo.IndexModel{ttl},
+		})
+	}
+
+	return specs
+}
+
+// EnsureIndexes creates any of the indexes that are missing, ones that already exist are left as they are.
+// it fails if a collection already breaks a unique index, the duplicates have to be cleared up first
+func (m *Mongo) EnsureIndexes(ctx context.Context) error {
+	client, err := m.getConnection(ctx)
+	if err != nil {
+		return err
+	}
+	defer m.disconnect(ctx, client)
+
+	for _, spec := range m.indexSpecs() {
+		if _, err := client.
+			Database(spec.db).
+			Collection(spec.collection).
+			Indexes().
+			CreateMany(ctx, spec.models); err != nil {
+			return fmt.Errorf("indexes on %s.%s: %w", spec.db, spec.collection, err)
+		}
+	}
+
+	return nil
+}
+
+// Indexes keeps the indexes in place and remembers whether that worked, for readiness
+type Indexes struct {
+	Config *config.Config
+	Ensure func(ctx context.Context) error
+	Retry  time.Duration
+	Logger log.Logger
+
+	mu  sync.RWMutex
+	err error
+}
+
+func NewIndexes(m *Mongo, logger log.Logger) *Indexes {
+	return &Indexes{
+		Config: m.Config,
+		Ensure: m.EnsureIndexes,
+		Retry:  10 * time.Second,
+		Logger: logger,
+		err:    ErrIndexesPending,
+	}
+}
+
+// Run creates the indexes, trying again until it works, and again whenever the mongo config is reloaded
+// as the collections may have moved
+func (i *Indexes) Run(ctx context.Context) {
+	for {
+		changed := i.Config.MongoChanged()
+		err := i.Ensure(ctx)
+		i.mu.Lock()
+		i.err = err
+		i.mu.Unlock()
+
+		var retry *time.Timer
+		var retryC <-chan time.Time
+		if err != nil {
+			_ = level.Error(i.Logger).Log("msg", "creating indexes", "err", err)
+			retry = time.NewTimer(i.Retry)
+			retryC = retry.C
+		}
+
+		select {
+		case <-ctx.Done():
+			if retry != nil {
+				retry.Stop()
+			}
+			return
+		case <-changed:
+		case <-retryC:
+		}
+		if retry != nil {
+			retry.Stop()
+		}
+	}
+}
+
+// Check is the readiness check, it fails until the indexes have been created
+func (i *Indexes) Check(ctx context.Context) error {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	return i.err
+}
@@ -0,0 +1,160 @@
+package key_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/k8sdeploy/key-service/internal/config"
+	"github.com/k8sdeploy/key-service/internal/key"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func indexConfig() *config.Config {
+	c := &config.Config{}
+	c.Mongo.Hooks = config.DB{Database: "hooks", KeysCollection: "keys", NoncesCollection: "nonces"}
+	c.Mongo.Agent = config.DB{Database: "agents", KeysCollection: "keys"}
+	c.Mongo.User = config.DB{Database: "users", KeysCollection: "keys"}
+	c.Mongo.Legacy = config.DB{Database: "keys", KeysCollection: "keys"}
+	c.Events.Publisher = "none"
+	c.Events.OutboxDatabase = "events"
+	c.Events.OutboxCollection = "outbox"
+	c.RateLimit.Backend = "memory"
+	c.RateLimit.Database = "limits"
+	c.RateLimit.Collection = "counters"
+
+	return c
+}
+
+// describe is an index as "field,field unique" or "field ttl"
+func describe(m mongo.IndexModel) string {
+	s := ""
+	for i, e := range m.Keys.(bson.D) {
+		if i > 0 {
+			s += ","
+		}
+		s += e.Key
+	}
+	if m.Options != nil && m.Options.Unique != nil && *m.Options.Unique {
+		s += " unique"
+	}
+	if m.Options != nil && m.Options.ExpireAfterSeconds != nil {
+		s += " ttl"
+	}
+
+	return s
+}
+
+func TestMongo_IndexSpecs(t *testing.T) {
+	tests := []struct {
+		name    string
+		watch   bool
+		backend string
+		want    map[string][]string
+	}{
+		{
+			name: "keys and nonces",
+			want: map[string][]string{
+				"hooks.keys":   {"company_id unique", "company_id,key unique", "expires_at"},
+				"agents.keys":  {"company_id,agent_key unique", "expires_at"},
+				"users.keys":   {"user_id unique", "user_id,key unique", "expires_at"},
+				"hooks.nonces": {"expires_at ttl"},
+				"keys.keys":    {"user_id unique"},
+			},
+		},
+		{
+			name:  "outbox when something reads it",
+			watch: true,
+			want: map[string][]string{
+				"hooks.keys":    {"company_id unique", "company_id,key unique", "expires_at"},
+				"agents.keys":   {"company_id,agent_key unique", "expires_at"},
+				"users.keys":    {"user_id unique", "user_id,key unique", "expires_at"},
+				"hooks.nonces":  {"expires_at ttl"},
+				"keys.keys":     {"user_id unique"},
+				"events.outbox": {"expires_at ttl"},
+			},
+		},
+		{
+			name:    "rate limits kept in mongo",
+			backend: "mongo",
+			want: map[string][]string{
+				"hooks.keys":      {"company_id unique", "company_id,key unique", "expires_at"},
+				"agents.keys":     {"company_id,agent_key unique", "expires_at"},
+				"users.keys":      {"user_id unique", "user_id,key unique", "expires_at"},
+				"hooks.nonces":    {"expires_at ttl"},
+				"keys.keys":       {"user_id unique"},
+				"limits.counters": {"expires_at ttl"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := indexConfig()
+			c.Events.Watch = tt.watch
+			if tt.backend != "" {
+				c.RateLimit.Backend = tt.backend
+			}
+
+			specs := key.IndexSpecs(key.NewMongo(c))
+			if len(specs) != len(tt.want) {
+				t.Errorf("collections = %d, want %d", len(specs), len(tt.want))
+			}
+			for collection, want := range tt.want {
+				got := make([]string, 0, len(specs[collection]))
+				for _, m := range specs[collection] {
+					got = append(got, describe(m))
+				}
+				if fmt.Sprint(got) != fmt.Sprint(want) {
+					t.Errorf("%s indexes = %q, want %q", collection, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestIndexes_Run(t *testing.T) {
+	var calls int32
+	indexes := key.NewIndexes(key.NewMongo(indexConfig()), log.NewNopLogger())
+	indexes.Retry = 10 * time.Millisecond
+	indexes.Ensure = func(ctx context.Context) error {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			return errors.New("duplicate key")
+		}
+		return nil
+	}
+
+	if err := indexes.Check(context.Background()); !errors.Is(err, key.ErrIndexesPending) {
+		t.Fatalf("Check() before Run = %v, want ErrIndexesPending", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		indexes.Run(ctx)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for indexes.Check(context.Background()) != nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("Check() = %v, want the retry to create the indexes", indexes.Check(context.Background()))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Errorf("Ensure called %d times, want 2", got)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run didn't stop with its context")
+	}
+}
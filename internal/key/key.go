@@ -2,14 +2,42 @@ package key
 
 import (
 	"crypto/rand"
+	"errors"
 	"math/big"
 	"time"
 
+	"github.com/go-kit/log"
+	"github.com/k8sdeploy/key-service/internal/compare"
 	"github.com/k8sdeploy/key-service/internal/config"
+	"github.com/mrz1836/go-sanitize"
 )
 
+// Key types
+const (
+	HooksKeyType = "hooks"
+	AgentKeyType = "agent"
+	UserKeyType  = "user"
+)
+
+var (
+	ErrRevoked        = errors.New("key revoked")
+	ErrExpired        = errors.New("key expired")
+	ErrUnknownKeyType = errors.New("unknown key type")
+)
+
+// secretsEqual is what every key check goes through, it's a var so the tests can make sure of that
+var secretsEqual = compare.Equal
+
+// normalizeOwner is the form an owner id is stored under, every read, write and revoke goes through it so they all find the same key
+func normalizeOwner(id string) string {
+	return sanitize.AlphaNumeric(id, false)
+}
+
 type Key struct {
 	Config *config.Config
+	Logger log.Logger
+	// Mongo is used when set, so the handlers share its client rather than connecting for each request
+	Mongo *Mongo
 }
 
 type ServiceKey struct {
@@ -17,17 +45,36 @@ type ServiceKey struct {
 }
 
 type UserKey struct {
-	ID      string
-	Created time.Time
+	ID      string    `bson:"user_id"`
+	Created time.Time `bson:"-"`
 
-	Key    string
-	Secret string
+	Key       string     `bson:"key"`
+	Secret    string     `bson:"secret"`
+	RevokedAt *time.Time `bson:"revoked_at,omitempty"`
+	ExpiresAt *time.Time `bson:"expires_at,omitempty"`
 }
 
 type K8sKey struct {
-	ID     string
-	Key    string
-	Secret string
+	ID        string     `bson:"company_id"`
+	Key       string     `bson:"key"`
+	Secret    string     `bson:"secret"`
+	RevokedAt *time.Time `bson:"revoked_at,omitempty"`
+	ExpiresAt *time.Time `bson:"expires_at,omitempty"`
+}
+
+// Matches checks the presented key and secret against the stored ones, both are always compared
+func (k K8sKey) Matches(presented K8sKey) bool {
+	keyMatch := secretsEqual(k.Key, presented.Key)
+	secretMatch := secretsEqual(k.Secret, presented.Secret)
+
+	return k.Key != "" && k.Secret != "" && keyMatch && secretMatch
+}
+
+func (k UserKey) Matches(presented UserKey) bool {
+	keyMatch := secretsEqual(k.Key, presented.Key)
+	secretMatch := secretsEqual(k.Secret, presented.Secret)
+
+	return k.Key != "" && k.Secret != "" && keyMatch && secretMatch
 }
 
 func NewKey(config *config.Config) *Key {
@@ -36,6 +83,17 @@ func NewKey(config *config.Config) *Key {
 	}
 }
 
+func (k *Key) mongo() *Mongo {
+	if k.Mongo != nil {
+		return k.Mongo
+	}
+
+	m := NewMongo(k.Config)
+	m.Logger = k.Logger
+
+	return m
+}
+
 func (k *Key) GenerateServiceKey(n int) (string, error) {
 	var letterRunes = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
 
@@ -96,5 +154,5 @@ func (k *Key) GetKeys(n int) (*ResponseItem, error) {
 }
 
 func (k *Key) ValidateServiceKey(key string) bool {
-	return k.Config.Local.OnePasswordKey == key
+	return secretsEqual(k.Config.Local.OnePasswordKey, key)
 }
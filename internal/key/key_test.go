@@ -1,10 +1,18 @@
 package key_test
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/go-chi/chi/v5"
+	"github.com/go-kit/log"
 	"github.com/k8sdeploy/key-service/internal/config"
 	"github.com/k8sdeploy/key-service/internal/key"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
 )
 
 func TestKey_GenerateServiceKey(t *testing.T) {
@@ -102,3 +110,176 @@ func TestKey_GetKeys(t *testing.T) {
 		})
 	}
 }
+
+func TestKey_ConstantTimeComparisons(t *testing.T) {
+	presented := "presentedSecretValue"
+	stored := "storedSecretValue"
+
+	mongoConfig := func() *config.Config {
+		c := &config.Config{}
+		c.Mongo.Hooks = config.DB{Database: "hooks", KeysCollection: "keys"}
+		c.Mongo.Agent = config.DB{Database: "agents", KeysCollection: "keys"}
+		c.Mongo.User = config.DB{Database: "users", KeysCollection: "keys"}
+		c.Mongo.Legacy = config.DB{Database: "keys", KeysCollection: "keys"}
+		return c
+	}
+	tests := []struct {
+		name     string
+		stored   bson.D
+		validate func(mt *mtest.T)
+	}{
+		{
+			name: "service key",
+			validate: func(mt *mtest.T) {
+				key.NewKey(&config.Config{
+					Local: config.Local{
+						OnePasswordKey: stored,
+					},
+				}).ValidateServiceKey(presented)
+			},
+		},
+		{
+			name: "server service key",
+			validate: func(mt *mtest.T) {
+				s := &key.Server{
+					Config: &config.Config{},
+				}
+				s.Config.HooksService.Key = stored
+				s.Config.Orchestrator.Key = stored
+				_, _ = s.ValidateServiceKey(presented)
+			},
+		},
+		{
+			name:   "hook key in mongo",
+			stored: bson.D{{Key: "company_id", Value: "company"}, {Key: "key", Value: "hookKey"}, {Key: "secret", Value: stored}},
+			validate: func(mt *mtest.T) {
+				m := key.NewMongo(mongoConfig())
+				key.UseClient(m, mt.Client)
+				_, _ = m.ValidateHooksKey(context.Background(), key.K8sKey{ID: "company", Key: "hookKey", Secret: presented})
+			},
+		},
+		{
+			name: "hook key in memory",
+			validate: func(mt *mtest.T) {
+				m := key.NewMemoryStore(&config.Config{}, nil)
+				_, _ = m.InsertHooksKey(context.Background(), key.K8sKey{ID: "company", Key: "hookKey", Secret: stored})
+				_, _ = m.ValidateHooksKey(context.Background(), key.K8sKey{ID: "company", Key: "hookKey", Secret: presented})
+			},
+		},
+		{
+			name:   "agent key in mongo",
+			stored: bson.D{{Key: "company_id", Value: "company"}, {Key: "agent_key", Value: "agentKey"}, {Key: "agent_secret", Value: stored}},
+			validate: func(mt *mtest.T) {
+				m := key.NewMongo(mongoConfig())
+				key.UseClient(m, mt.Client)
+				_, _ = m.ValidateAgentKey(context.Background(), &key.K8sKey{ID: "company", Key: "agentKey", Secret: presented})
+			},
+		},
+		{
+			name:   "user key in mongo",
+			stored: bson.D{{Key: "user_id", Value: "user"}, {Key: "key", Value: "userKey"}, {Key: "secret", Value: stored}},
+			validate: func(mt *mtest.T) {
+				m := key.NewMongo(mongoConfig())
+				key.UseClient(m, mt.Client)
+				_, _ = m.ValidateUserKey(context.Background(), key.UserKey{ID: "user", Key: "userKey", Secret: presented})
+			},
+		},
+		{
+			name: "user key",
+			validate: func(mt *mtest.T) {
+				key.UserKey{ID: "user", Key: "userKey", Secret: stored}.Matches(key.UserKey{ID: "user", Key: "userKey", Secret: presented})
+			},
+		},
+		{
+			name: "legacy service keys through the handler",
+			stored: bson.D{
+				{Key: "user_id", Value: "user"},
+				{Key: "generated", Value: time.Now().Unix()},
+				{Key: "keys", Value: bson.D{{Key: "user_service", Value: stored}}},
+			},
+			validate: func(mt *mtest.T) {
+				rctx := chi.NewRouteContext()
+				rctx.URLParams.Add("key", presented)
+				r := httptest.NewRequest(http.MethodGet, "/validate/"+presented, nil)
+				r.Header.Set("x-user-id", "user")
+				r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+
+				k := key.NewKey(mongoConfig())
+				k.Logger = log.NewNopLogger()
+				k.Mongo = key.NewMongo(k.Config)
+				key.UseClient(k.Mongo, mt.Client)
+				k.ValidateHandler(httptest.NewRecorder(), r)
+			},
+		},
+	}
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			if tt.stored != nil {
+				mt.AddMockResponses(mtest.CreateCursorResponse(0, "db.keys", mtest.FirstBatch, tt.stored))
+			}
+
+			used := false
+			restore := key.SetSecretsEqual(func(a, b string) bool {
+				if a == presented || b == presented {
+					used = true
+				}
+				return false
+			})
+			defer restore()
+
+			tt.validate(mt)
+
+			if !used {
+				t.Errorf("%s was not compared through compare.Equal", tt.name)
+			}
+		})
+	}
+}
+
+func TestK8sKey_Matches(t *testing.T) {
+	stored := key.K8sKey{ID: "company", Key: "hookKey", Secret: "hookSecret"}
+
+	tests := []struct {
+		name      string
+		stored    key.K8sKey
+		presented key.K8sKey
+		want      bool
+	}{
+		{
+			name:      "matching",
+			stored:    stored,
+			presented: key.K8sKey{ID: "company", Key: "hookKey", Secret: "hookSecret"},
+			want:      true,
+		},
+		{
+			name:      "wrong secret",
+			stored:    stored,
+			presented: key.K8sKey{ID: "company", Key: "hookKey", Secret: "hookSecret1"},
+			want:      false,
+		},
+		{
+			name:      "wrong key",
+			stored:    stored,
+			presented: key.K8sKey{ID: "company", Key: "hookKey1", Secret: "hookSecret"},
+			want:      false,
+		},
+		{
+			name:      "nothing stored",
+			stored:    key.K8sKey{},
+			presented: key.K8sKey{},
+			want:      false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.stored.Matches(tt.presented); got != tt.want {
+				t.Errorf("K8sKey.Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
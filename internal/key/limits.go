@@ -0,0 +1,202 @@
+package key
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/k8sdeploy/key-service/internal/config"
+	"github.com/k8sdeploy/key-service/internal/metrics"
+	"github.com/k8sdeploy/key-service/internal/ratelimit"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoLimits keeps the rate limit counters in mongo so every replica sees the same state. it is checked on
+// every validate so it is meant to be used with the shared client Connect opens
+type MongoLimits struct {
+	*Mongo
+}
+
+func NewMongoLimits(c *config.Config) *MongoLimits {
+	return &MongoLimits{
+		Mongo: NewMongo(c),
+	}
+}
+
+// NewLimiterBackend is the backend named by RATE_LIMIT_BACKEND, a MongoLimits has to be connected before use
+func NewLimiterBackend(c *config.Config, logger log.Logger) ratelimit.Backend {
+	if c.RateLimit.Backend != "mongo" {
+		return ratelimit.NewMemory()
+	}
+
+	m := NewMongoLimits(c)
+	m.Logger = logger
+
+	return m
+}
+
+func NewLimiter(c *config.Config, backend ratelimit.Backend) *ratelimit.Limiter {
+	return ratelimit.NewLimiter(backend, ratelimit.Limits{
+		CompanyRequests: c.RateLimit.CompanyRequests,
+		KeyRequests:     c.RateLimit.KeyRequests,
+		Window:          c.RateLimit.Window,
+		MaxFailures:     c.RateLimit.MaxFailures,
+		FailureTTL:      c.RateLimit.FailureTTL,
+		LockoutBase:     c.RateLimit.LockoutBase,
+		LockoutLimit:    c.RateLimit.LockoutLimit,
+	})
+}
+
+func (m *MongoLimits) collection(client *mongo.Client) *mongo.Collection {
+	return client.
+		Database(m.Config.RateLimit.Database).
+		Collection(m.Config.RateLimit.Collection)
+}
+
+func (m *MongoLimits) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	defer metrics.MongoTimer("rate_limit_incr").ObserveDuration()
+	ctx, cancel := m.writeContext(ctx)
+	defer cancel()
+
+	client, err := m.getConnection(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer m.disconnect(ctx, client)
+
+	now := time.Now()
+	col := m.collection(client)
+	if _, err := col.DeleteOne(ctx, bson.M{
+		"_id":        key,
+		"expires_at": bson.M{"$lte": now},
+	}); err != nil {
+		return 0, err
+	}
+
+	var counter struct {
+		Value int64 `bson:"value"`
+	}
+	incr := func() error {
+		return col.FindOneAndUpdate(ctx,
+			bson.M{"_id": key},
+			bson.M{
+				"$inc": bson.M{"value": 1},
+				// $min sets it on insert and on any counter left without one, and never pushes the window out
+				"$min": bson.M{"expires_at": now.Add(ttl)},
+			},
+			options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).
+			Decode(&counter)
+	}
+	if err := incr(); err != nil {
+		// two replicas can race on the upsert, the loser just increments the winners document
+		if !mongo.IsDuplicateKeyError(err) {
+			return 0, err
+		}
+		if err := incr(); err != nil {
+			return 0, err
+		}
+	}
+
+	return counter.Value, nil
+}
+
+func (m *MongoLimits) LockUntil(ctx context.Context, key string) (time.Time, error) {
+	defer metrics.MongoTimer("rate_limit_lock_until").ObserveDuration()
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	client, err := m.getConnection(ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer m.disconnect(ctx, client)
+
+	var lock struct {
+		Until time.Time `bson:"until"`
+	}
+	if err := m.collection(client).FindOne(ctx, bson.M{"_id": key}).Decode(&lock); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, err
+	}
+
+	return lock.Until, nil
+}
+
+func (m *MongoLimits) Lock(ctx context.Context, key string, until time.Time) error {
+	defer metrics.MongoTimer("rate_limit_lock").ObserveDuration()
+	ctx, cancel := m.writeContext(ctx)
+	defer cancel()
+
+	client, err := m.getConnection(ctx)
+	if err != nil {
+		return err
+	}
+	defer m.disconnect(ctx, client)
+
+	_, err = m.collection(client).UpdateOne(ctx,
+		bson.M{"_id": key},
+		bson.M{"$set": bson.M{
+			"until":      until,
+			"expires_at": until,
+		}},
+		options.Update().SetUpsert(true))
+
+	return err
+}
+
+func (m *MongoLimits) Delete(ctx context.Context, key string) error {
+	defer metrics.MongoTimer("rate_limit_delete").ObserveDuration()
+	ctx, cancel := m.writeContext(ctx)
+	defer cancel()
+
+	client, err := m.getConnection(ctx)
+	if err != nil {
+		return err
+	}
+	defer m.disconnect(ctx, client)
+
+	_, err = m.collection(client).DeleteOne(ctx, bson.M{"_id": key})
+
+	return err
+}
+
+// limited is the error to send back when the caller has hit a rate limit or the key is locked out
+func (s *Server) limited(ctx context.Context, companyID, keyID string) error {
+	if s.Limiter == nil {
+		return nil
+	}
+
+	err := s.Limiter.Allow(ctx, companyID, keyID)
+	if err == nil {
+		return nil
+	}
+	if limitErr := limitError(err); limitErr != nil {
+		return limitErr
+	}
+
+	_ = level.Error(s.logger(ctx)).Log("msg", "checking rate limits", "company_id", companyID, "err", err)
+	return systemError(ctx, err)
+}
+
+func (s *Server) recordValidation(ctx context.Context, companyID, keyID string, valid bool) {
+	if s.Limiter == nil {
+		return
+	}
+
+	if valid {
+		if err := s.Limiter.Success(ctx, companyID, keyID); err != nil {
+			_ = level.Error(s.logger(ctx)).Log("msg", "clearing validation failures", "company_id", companyID, "key_id", keyID, "err", err)
+		}
+		return
+	}
+
+	if err := s.Limiter.Failure(ctx, companyID, keyID); err != nil {
+		_ = level.Error(s.logger(ctx)).Log("msg", "recording validation failure", "company_id", companyID, "key_id", keyID, "err", err)
+	}
+}
@@ -0,0 +1,103 @@
+package key_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/k8sdeploy/key-service/internal/config"
+	"github.com/k8sdeploy/key-service/internal/key"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestMongoLimits_SharedClient(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("calls reuse the client", func(mt *mtest.T) {
+		c := &config.Config{}
+		c.RateLimit.Database = "limits"
+		c.RateLimit.Collection = "counters"
+
+		m := key.NewMongoLimits(c)
+		key.UseClient(m.Mongo, mt.Client)
+
+		ctx := context.Background()
+		for i := int64(1); i <= 2; i++ {
+			mt.AddMockResponses(
+				mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}),
+				mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{
+					{Key: "_id", Value: "rate:company:company:1"},
+					{Key: "value", Value: i},
+				}}),
+			)
+			n, err := m.Incr(ctx, "rate:company:company:1", time.Minute)
+			if err != nil {
+				t.Fatalf("Incr() call %d = %v", i, err)
+			}
+			if n != i {
+				t.Errorf("Incr() call %d = %d, want %d", i, n, i)
+			}
+		}
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "limits.counters", mtest.FirstBatch))
+		if _, err := m.LockUntil(ctx, "lock:company:key"); err != nil {
+			t.Errorf("LockUntil() after Incr() = %v, the shared client was closed", err)
+		}
+	})
+}
+
+func TestMongoLimits_ExpiresAt(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	// the ttl index only deletes documents that have expires_at, so every write has to set it
+	mt.Run("counters and locks", func(mt *mtest.T) {
+		c := &config.Config{}
+		c.RateLimit.Database = "limits"
+		c.RateLimit.Collection = "counters"
+
+		m := key.NewMongoLimits(c)
+		key.UseClient(m.Mongo, mt.Client)
+		ctx := context.Background()
+
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}),
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{
+				{Key: "_id", Value: "rate:company:company:1"},
+				{Key: "value", Value: 1},
+			}}),
+		)
+		before := time.Now()
+		if _, err := m.Incr(ctx, "rate:company:company:1", time.Minute); err != nil {
+			t.Fatalf("Incr() = %v", err)
+		}
+		mt.GetStartedEvent()
+		incr := mt.GetStartedEvent()
+		if incr == nil || incr.CommandName != "findAndModify" {
+			t.Fatalf("Incr() command = %v, want findAndModify", incr)
+		}
+		expires, ok := incr.Command.Lookup("update", "$min", "expires_at").TimeOK()
+		if !ok {
+			t.Fatalf("Incr() update = %s, want expires_at set", incr.Command.Lookup("update"))
+		}
+		if expires.Before(before.Add(time.Minute).Truncate(time.Millisecond)) {
+			t.Errorf("counter expires_at = %v, want a minute out", expires)
+		}
+
+		until := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}))
+		if err := m.Lock(ctx, "lock:company:key", until); err != nil {
+			t.Fatalf("Lock() = %v", err)
+		}
+		lock := mt.GetStartedEvent()
+		if lock == nil || lock.CommandName != "update" {
+			t.Fatalf("Lock() command = %v, want update", lock)
+		}
+		expires, ok = lock.Command.Lookup("updates", "0", "u", "$set", "expires_at").TimeOK()
+		if !ok || !expires.Equal(until) {
+			t.Errorf("lock expires_at = %v, want %v", expires, until)
+		}
+	})
+}
@@ -0,0 +1,63 @@
+package key
+
+import (
+	"context"
+	"path"
+
+	"github.com/k8sdeploy/key-service/internal/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type validResponse interface {
+	GetValid() bool
+	GetStatus() string
+}
+
+type statusResponse interface {
+	GetStatus() string
+}
+
+// Outcome maps an rpc response or error onto the outcome label used for the rpc metrics
+func Outcome(resp interface{}, err error) string {
+	if err != nil {
+		switch Reason(err) {
+		case ReasonKeyExpired:
+			return metrics.Expired
+		case ReasonKeyRevoked:
+			return metrics.Revoked
+		}
+		switch status.Code(err) {
+		case codes.InvalidArgument, codes.Unauthenticated, codes.PermissionDenied, codes.NotFound,
+			codes.FailedPrecondition, codes.ResourceExhausted:
+			return metrics.Invalid
+		}
+		return metrics.SystemError
+	}
+
+	switch r := resp.(type) {
+	case validResponse:
+		if r.GetValid() {
+			return metrics.Valid
+		}
+		return metrics.Invalid
+	case statusResponse:
+		if r.GetStatus() == "" {
+			return metrics.OK
+		}
+		return metrics.Invalid
+	}
+
+	return metrics.OK
+}
+
+// MetricsInterceptor counts every rpc by its outcome
+func (s *Server) MetricsInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		metrics.Result(path.Base(info.FullMethod), Outcome(resp, err))
+
+		return resp, err
+	}
+}
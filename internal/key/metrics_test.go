@@ -0,0 +1,104 @@
+package key_test
+
+import (
+	"errors"
+	"testing"
+
+	kspb "github.com/k8sdeploy/key-service/internal/generated/keyservice/v1"
+	"github.com/k8sdeploy/key-service/internal/key"
+	"github.com/k8sdeploy/key-service/internal/metrics"
+	pb "github.com/k8sdeploy/protos/generated/key/v1"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// reasonError is an rpc error carrying the reason the way the handlers send it
+func reasonError(t *testing.T, code codes.Code, reason string) error {
+	t.Helper()
+
+	st, err := status.New(code, reason).WithDetails(&errdetails.ErrorInfo{
+		Reason: reason,
+		Domain: key.ErrorDomain,
+	})
+	if err != nil {
+		t.Fatalf("WithDetails() = %v", err)
+	}
+
+	return st.Err()
+}
+
+func TestOutcome(t *testing.T) {
+	tests := []struct {
+		name string
+		resp interface{}
+		err  error
+		want string
+	}{
+		{
+			name: "valid",
+			resp: &pb.ValidKeyResponse{Valid: true},
+			want: metrics.Valid,
+		},
+		{
+			name: "invalid",
+			resp: &pb.ValidKeyResponse{},
+			want: metrics.Invalid,
+		},
+		{
+			name: "invalid service key",
+			resp: (*pb.ValidKeyResponse)(nil),
+			err:  status.Error(codes.PermissionDenied, key.InvalidServiceKey),
+			want: metrics.Invalid,
+		},
+		{
+			name: "expired",
+			resp: (*pb.ValidKeyResponse)(nil),
+			err:  reasonError(t, codes.FailedPrecondition, key.ReasonKeyExpired),
+			want: metrics.Expired,
+		},
+		{
+			name: "revoked",
+			resp: (*kspb.VerifySignatureResponse)(nil),
+			err:  reasonError(t, codes.FailedPrecondition, key.ReasonKeyRevoked),
+			want: metrics.Revoked,
+		},
+		{
+			name: "rate limited",
+			resp: (*kspb.VerifySignatureResponse)(nil),
+			err:  reasonError(t, codes.ResourceExhausted, key.ReasonRateLimited),
+			want: metrics.Invalid,
+		},
+		{
+			name: "internal",
+			resp: (*pb.ValidKeyResponse)(nil),
+			err:  reasonError(t, codes.Internal, key.ReasonSystemError),
+			want: metrics.SystemError,
+		},
+		{
+			name: "error",
+			resp: (*pb.ValidKeyResponse)(nil),
+			err:  errors.New("mongo down"),
+			want: metrics.SystemError,
+		},
+		{
+			name: "wrong signature",
+			resp: (*kspb.VerifySignatureResponse)(nil),
+			err:  reasonError(t, codes.Unauthenticated, key.ReasonInvalidSignature),
+			want: metrics.Invalid,
+		},
+		{
+			name: "created",
+			resp: &pb.KeyResponse{Key: "key", Secret: "secret"},
+			want: metrics.OK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := key.Outcome(tt.resp, tt.err); got != tt.want {
+				t.Errorf("Outcome() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,28 +2,48 @@ package key
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"net/url"
+	"os"
+	"sync"
 	"time"
 
-	"github.com/mrz1836/go-sanitize"
-
-	bugLog "github.com/bugfixes/go-bugfixes/logs"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
 	"github.com/k8sdeploy/key-service/internal/config"
+	"github.com/k8sdeploy/key-service/internal/events"
+	"github.com/k8sdeploy/key-service/internal/logging"
+	"github.com/k8sdeploy/key-service/internal/metrics"
+	"github.com/k8sdeploy/key-service/internal/tracing"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.opentelemetry.io/contrib/instrumentation/go.mongodb.org/mongo-driver/mongo/otelmongo"
 )
 
+// reconnectRetry is how long Run waits to try again when the shared client can't be reopened
+const reconnectRetry = 10 * time.Second
+
+// connectMongo opens a client, tests swap it to see what is connected
+var connectMongo = mongo.Connect
+
 type Mongo struct {
 	Config *config.Config
-	CTX    context.Context
+	Logger log.Logger
+
+	mu      sync.RWMutex
+	client  *mongo.Client
+	changed <-chan struct{}
+	swapped chan struct{}
 }
 
 func NewMongo(c *config.Config) *Mongo {
 	return &Mongo{
 		Config: c,
-		CTX:    context.Background(),
 	}
 }
 
@@ -40,15 +60,109 @@ type DataSet struct {
 	} `json:"keys" bson:"keys"`
 }
 
-func (m *Mongo) getConnection() (*mongo.Client, error) {
-	client, err := mongo.Connect(
-		m.CTX,
-		options.Client().ApplyURI(fmt.Sprintf(
-			"mongodb+srv://%s:%s@%s",
-			m.Config.Mongo.Username,
-			m.Config.Mongo.Password,
-			m.Config.Mongo.Host)),
-	)
+// Matches checks a key against every service key in the set, all of them are compared every time
+func (d DataSet) Matches(key string) bool {
+	matched := false
+	for _, k := range []string{
+		d.Keys.UserService,
+		d.Keys.HooksService,
+		d.Keys.CompanyService,
+		d.Keys.BillingService,
+		d.Keys.PermissionsService,
+	} {
+		if k != "" && secretsEqual(k, key) {
+			matched = true
+		}
+	}
+
+	return matched
+}
+
+// checkState only reports a revocation or expiry to callers that presented the right secret
+func checkState(matched bool, revokedAt, expiresAt *time.Time) (bool, error) {
+	if !matched {
+		return false, nil
+	}
+	if revokedAt != nil {
+		return false, ErrRevoked
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return false, ErrExpired
+	}
+
+	return true, nil
+}
+
+// issue is the update that (re)issues a key, anything left over from a revoked or expired key is cleared
+func (m *Mongo) issue(set bson.D) bson.D {
+	now := time.Now()
+	set = append(set, bson.E{Key: "generated", Value: now.Unix()})
+	unset := bson.D{
+		{Key: "revoked_at", Value: ""},
+		{Key: "expired_at", Value: ""},
+	}
+	if m.Config.KeyLifetime > 0 {
+		set = append(set, bson.E{Key: "expires_at", Value: now.Add(m.Config.KeyLifetime)})
+	} else {
+		unset = append(unset, bson.E{Key: "expires_at", Value: ""})
+	}
+
+	return bson.D{
+		{Key: "$set", Value: set},
+		{Key: "$unset", Value: unset},
+	}
+}
+
+func issuedEvent(rotated bool, keyType, owner, key string) events.Event {
+	if rotated {
+		return events.New(events.KeyRotated, keyType, owner, key)
+	}
+
+	return events.New(events.KeyCreated, keyType, owner, key)
+}
+
+// queryContext bounds a read by MONGO_QUERY_TIMEOUT, the caller's own deadline still applies if it is sooner
+func (m *Mongo) queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, m.Config.MongoConfig().QueryTimeout)
+}
+
+// writeContext bounds a write, or a transaction of them, by MONGO_WRITE_TIMEOUT
+func (m *Mongo) writeContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, m.Config.MongoConfig().WriteTimeout)
+}
+
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// getConnection is the shared client once Connect has been called, otherwise a client of its own that the
+// caller disconnects when it's done
+func (m *Mongo) getConnection(ctx context.Context) (*mongo.Client, error) {
+	if client := m.shared(); client != nil {
+		return client, nil
+	}
+
+	return m.connect(ctx)
+}
+
+func (m *Mongo) shared() *mongo.Client {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.client
+}
+
+func (m *Mongo) connect(ctx context.Context) (*mongo.Client, error) {
+	opts, err := clientOptions(m.Config.MongoConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := connectMongo(ctx, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -56,22 +170,253 @@ func (m *Mongo) getConnection() (*mongo.Client, error) {
 	return client, nil
 }
 
-func (m *Mongo) Get(key string) (*DataSet, error) {
-	client, err := m.getConnection()
+// clientOptions builds the connection from the uri when there is one, otherwise from the hostname and
+// credentials, with the tls, auth and timeout settings on top
+func clientOptions(c config.Mongo) (*options.ClientOptions, error) {
+	conn := c.Connection
+
+	uri := conn.URI
+	if uri == "" {
+		scheme := conn.Scheme
+		if scheme == "" {
+			scheme = "mongodb+srv"
+		}
+		u := url.URL{Scheme: scheme, Host: c.Host}
+		if c.Username != "" && conn.AuthMechanism != "MONGODB-X509" {
+			u.User = url.UserPassword(c.Username, c.Password)
+		}
+		uri = u.String()
+	}
+
+	opts := options.Client().ApplyURI(uri).SetMonitor(otelmongo.NewMonitor())
+
+	if conn.AuthSource != "" || conn.AuthMechanism != "" {
+		cred := options.Credential{}
+		if opts.Auth != nil {
+			cred = *opts.Auth
+		}
+		if conn.AuthSource != "" {
+			cred.AuthSource = conn.AuthSource
+		}
+		if conn.AuthMechanism != "" {
+			cred.AuthMechanism = conn.AuthMechanism
+		}
+		if cred.AuthMechanism == "MONGODB-X509" {
+			// the username comes from the certificate
+			cred.Username = ""
+			cred.Password = ""
+			cred.PasswordSet = false
+		}
+		opts.SetAuth(cred)
+	}
+
+	if conn.ReplicaSet != "" {
+		opts.SetReplicaSet(conn.ReplicaSet)
+	}
+
+	if conn.TLS || conn.TLSCAFile != "" || conn.TLSCertificateKeyFile != "" {
+		tlsConfig, err := mongoTLS(conn)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
+
+	if conn.ReadPreference != "" {
+		mode, err := readpref.ModeFromString(conn.ReadPreference)
+		if err != nil {
+			return nil, err
+		}
+		pref, err := readpref.New(mode)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetReadPreference(pref)
+	}
+
+	if conn.ConnectTimeout > 0 {
+		opts.SetConnectTimeout(conn.ConnectTimeout)
+	}
+	if conn.ServerSelectionTimeout > 0 {
+		opts.SetServerSelectionTimeout(conn.ServerSelectionTimeout)
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("mongo connection: %w", err)
+	}
+
+	return opts, nil
+}
+
+func mongoTLS(conn config.MongoConnection) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: conn.TLSInsecure,
+	}
+
+	if conn.TLSCAFile != "" {
+		ca, err := os.ReadFile(conn.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("mongo tls ca: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("mongo tls ca: no certificates in %s", conn.TLSCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if conn.TLSCertificateKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(conn.TLSCertificateKeyFile, conn.TLSCertificateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("mongo tls certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// Connect opens one pooled client that every call then shares, replacing any it already had
+func (m *Mongo) Connect(ctx context.Context) error {
+	changed := m.Config.MongoChanged()
+	client, err := m.connect(ctx)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer func() {
-		if err := client.Disconnect(m.CTX); err != nil {
-			bugLog.Info(err)
+
+	m.mu.Lock()
+	old := m.client
+	m.client = client
+	m.changed = changed
+	if m.swapped != nil {
+		close(m.swapped)
+	}
+	m.swapped = make(chan struct{})
+	m.mu.Unlock()
+
+	if old != nil {
+		m.close(ctx, old)
+	}
+
+	return nil
+}
+
+// Run reopens the shared client whenever the mongo config is reloaded, as the pooled connections would
+// otherwise carry on with the old credentials. until it manages to the old client is kept
+func (m *Mongo) Run(ctx context.Context) {
+	for {
+		m.mu.RLock()
+		changed := m.changed
+		m.mu.RUnlock()
+		if changed == nil {
+			changed = m.Config.MongoChanged()
 		}
-	}()
 
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+		}
+
+		for {
+			err := m.Connect(ctx)
+			if err == nil {
+				break
+			}
+			_ = level.Error(logging.FromContext(ctx, m.Logger)).Log("msg", "reconnecting to mongo", "err", err)
+
+			retry := time.NewTimer(reconnectRetry)
+			select {
+			case <-ctx.Done():
+				retry.Stop()
+				return
+			case <-retry.C:
+			}
+		}
+	}
+}
+
+// Close closes the shared client, calls after it go back to a client each
+func (m *Mongo) Close(ctx context.Context) {
+	m.mu.Lock()
+	client := m.client
+	m.client = nil
+	if m.swapped != nil {
+		close(m.swapped)
+		m.swapped = nil
+	}
+	m.mu.Unlock()
+
+	if client != nil {
+		m.close(ctx, client)
+	}
+}
+
+// clientChanged is closed the next time calls get a different client, when the shared client is reopened
+// or closed, or without one when the mongo config changes
+func (m *Mongo) clientChanged() <-chan struct{} {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.swapped != nil {
+		return m.swapped
+	}
+
+	return m.Config.MongoChanged()
+}
+
+// disconnect closes a client getConnection opened for one call, the shared client is left open
+func (m *Mongo) disconnect(ctx context.Context, client *mongo.Client) {
+	if client == m.shared() {
+		return
+	}
+
+	m.close(ctx, client)
+}
+
+// close still closes the connection when ctx has been cancelled or run out of time, it waits for any
+// operations using it to finish
+func (m *Mongo) close(ctx context.Context, client *mongo.Client) {
+	dctx, cancel := m.writeContext(tracing.Detach(ctx))
+	defer cancel()
+
+	if err := client.Disconnect(dctx); err != nil {
+		_ = level.Warn(logging.FromContext(ctx, m.Logger)).Log("msg", "disconnecting from mongo", "err", err)
+	}
+}
+
+// Ping checks the primary can be reached with the configured credentials
+func (m *Mongo) Ping(ctx context.Context) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	client, err := m.getConnection(ctx)
+	if err != nil {
+		return err
+	}
+	defer m.disconnect(ctx, client)
+
+	return client.Ping(ctx, readpref.Primary())
+}
+
+func (m *Mongo) Get(ctx context.Context, key string) (*DataSet, error) {
+	defer metrics.MongoTimer("get").ObserveDuration()
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	client, err := m.getConnection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer m.disconnect(ctx, client)
+
+	legacy := m.Config.MongoConfig().Legacy
 	var dataSet DataSet
 	err = client.
-		Database("keys").
-		Collection("keys").
-		FindOne(m.CTX, map[string]string{"user_id": sanitize.AlphaNumeric(key, false)}).
+		Database(legacy.Database).
+		Collection(legacy.KeysCollection).
+		FindOne(ctx, map[string]string{"user_id": normalizeOwner(key)}).
 		Decode(&dataSet)
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
@@ -90,20 +435,21 @@ func (m *Mongo) Get(key string) (*DataSet, error) {
 	return nil, nil
 }
 
-func (m *Mongo) Create(data DataSet) error {
-	client, err := m.getConnection()
+func (m *Mongo) Create(ctx context.Context, data DataSet) error {
+	defer metrics.MongoTimer("create").ObserveDuration()
+	ctx, cancel := m.writeContext(ctx)
+	defer cancel()
+
+	client, err := m.getConnection(ctx)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		if err := client.Disconnect(m.CTX); err != nil {
-			bugLog.Info(err)
-		}
-	}()
+	defer m.disconnect(ctx, client)
 
-	_, err = client.Database("keys").Collection("keys").UpdateOne(
-		m.CTX,
-		map[string]string{"user_id": sanitize.AlphaNumeric(data.UserID, false)},
+	legacy := m.Config.MongoConfig().Legacy
+	_, err = client.Database(legacy.Database).Collection(legacy.KeysCollection).UpdateOne(
+		ctx,
+		map[string]string{"user_id": normalizeOwner(data.UserID)},
 		bson.D{{Key: "$set", Value: bson.D{
 			{Key: "generated", Value: time.Now().Unix()},
 			{Key: "keys.user_service", Value: data.Keys.UserService},
@@ -120,126 +466,465 @@ func (m *Mongo) Create(data DataSet) error {
 	return nil
 }
 
-func (m *Mongo) UpsertUser(data UserKey) error {
-	client, err := m.getConnection()
+// MigrateLegacyKeys copies the bundles of service keys from where they used to be into the configured
+// legacy_db and legacy_keys_collection. a bundle already there is left alone, so it can be run again
+// if it is stopped part way
+func (m *Mongo) MigrateLegacyKeys(ctx context.Context, from config.DB) (copied, skipped int, err error) {
+	to := m.Config.MongoConfig().Legacy
+	if from.Database == to.Database && from.KeysCollection == to.KeysCollection {
+		return 0, 0, nil
+	}
+
+	client, err := m.getConnection(ctx)
 	if err != nil {
-		return err
+		return 0, 0, err
+	}
+	defer m.disconnect(ctx, client)
+
+	cursor, err := client.Database(from.Database).Collection(from.KeysCollection).Find(ctx, bson.D{})
+	if err != nil {
+		return 0, 0, err
 	}
 	defer func() {
-		if err := client.Disconnect(m.CTX); err != nil {
-			bugLog.Info(err)
-		}
+		_ = cursor.Close(ctx)
 	}()
 
-	_, err = client.
-		Database(m.Config.Mongo.User.Database).
-		Collection(m.Config.Mongo.User.KeysCollection).
-		UpdateOne(
-			m.CTX,
-			map[string]string{"user_id": sanitize.AlphaNumeric(data.ID, false)},
-			bson.D{{Key: "$set", Value: bson.D{
-				{Key: "generated", Value: time.Now().Unix()},
-				{Key: "key", Value: data.Key},
-				{Key: "secret", Value: data.Secret},
-			}}},
+	dest := client.Database(to.Database).Collection(to.KeysCollection)
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return copied, skipped, err
+		}
+		delete(doc, "_id")
+
+		res, err := dest.UpdateOne(
+			ctx,
+			bson.M{"user_id": doc["user_id"]},
+			bson.M{"$setOnInsert": doc},
 			options.Update().SetUpsert(true))
+		if err != nil {
+			return copied, skipped, err
+		}
+		if res.UpsertedCount > 0 {
+			copied++
+		} else {
+			skipped++
+		}
+	}
+
+	return copied, skipped, cursor.Err()
+}
+
+func (m *Mongo) UpsertUser(ctx context.Context, data UserKey) (bool, error) {
+	defer metrics.MongoTimer("upsert_user").ObserveDuration()
+	ctx, cancel := m.writeContext(ctx)
+	defer cancel()
+
+	client, err := m.getConnection(ctx)
 	if err != nil {
-		return err
+		return false, err
 	}
+	defer m.disconnect(ctx, client)
 
-	return nil
+	userID := normalizeOwner(data.ID)
+	rotated := false
+	err = m.withEvents(ctx, client, func(ctx context.Context) ([]events.Event, error) {
+		res, err := client.
+			Database(m.Config.MongoConfig().User.Database).
+			Collection(m.Config.MongoConfig().User.KeysCollection).
+			UpdateOne(
+				ctx,
+				map[string]string{"user_id": userID},
+				m.issue(bson.D{
+					{Key: "key", Value: data.Key},
+					{Key: "secret", Value: data.Secret},
+				}),
+				options.Update().SetUpsert(true))
+		if err != nil {
+			return nil, err
+		}
+		rotated = res.MatchedCount > 0
+
+		return []events.Event{issuedEvent(rotated, UserKeyType, userID, data.Key)}, nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return rotated, nil
 }
 
-func (m *Mongo) InsertHooksKey(data K8sKey) error {
-	client, err := m.getConnection()
+func (m *Mongo) InsertHooksKey(ctx context.Context, data K8sKey) (bool, error) {
+	defer metrics.MongoTimer("insert_hooks_key").ObserveDuration()
+	ctx, cancel := m.writeContext(ctx)
+	defer cancel()
+
+	client, err := m.getConnection(ctx)
 	if err != nil {
-		return err
+		return false, err
 	}
-	defer func() {
-		if err := client.Disconnect(m.CTX); err != nil {
-			bugLog.Info(err)
+	defer m.disconnect(ctx, client)
+
+	companyID := normalizeOwner(data.ID)
+	rotated := false
+	err = m.withEvents(ctx, client, func(ctx context.Context) ([]events.Event, error) {
+		res, err := client.
+			Database(m.Config.MongoConfig().Hooks.Database).
+			Collection(m.Config.MongoConfig().Hooks.KeysCollection).
+			UpdateOne(
+				ctx,
+				map[string]string{"company_id": companyID},
+				m.issue(bson.D{
+					{Key: "key", Value: data.Key},
+					{Key: "secret", Value: data.Secret},
+				}),
+				options.Update().SetUpsert(true))
+		if err != nil {
+			return nil, err
 		}
-	}()
+		rotated = res.MatchedCount > 0
 
-	_, err = client.
-		Database(m.Config.Mongo.Hooks.Database).
-		Collection(m.Config.Mongo.Hooks.KeysCollection).
-		UpdateOne(
-			m.CTX,
-			map[string]string{"company_id": sanitize.AlphaNumeric(data.ID, false)},
-			bson.D{{Key: "$set", Value: bson.D{
-				{Key: "generated", Value: time.Now().Unix()},
-				{Key: "key", Value: data.Key},
-				{Key: "secret", Value: data.Secret},
-			}}},
-			options.Update().SetUpsert(true))
+		return []events.Event{issuedEvent(rotated, HooksKeyType, companyID, data.Key)}, nil
+	})
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	return nil
+	return rotated, nil
 }
 
-func (m *Mongo) ValidateHooksKey(data K8sKey) (bool, error) {
-	client, err := m.getConnection()
+func (m *Mongo) ValidateHooksKey(ctx context.Context, data K8sKey) (bool, error) {
+	defer metrics.MongoTimer("validate_hooks_key").ObserveDuration()
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	client, err := m.getConnection(ctx)
 	if err != nil {
-		fmt.Printf("validateHooksKey connection error: %s\n", err)
 		return false, err
 	}
-	defer func() {
-		if err := client.Disconnect(m.CTX); err != nil {
-			bugLog.Info(err)
+	defer m.disconnect(ctx, client)
+
+	var stored K8sKey
+	err = client.
+		Database(m.Config.MongoConfig().Hooks.Database).
+		Collection(m.Config.MongoConfig().Hooks.KeysCollection).
+		FindOne(ctx, map[string]string{
+			"company_id": normalizeOwner(data.ID),
+			"key":        data.Key,
+		}).
+		Decode(&stored)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return false, nil
 		}
-	}()
+		return false, err
+	}
+
+	return checkState(stored.Matches(data), stored.RevokedAt, stored.ExpiresAt)
+}
+
+func (m *Mongo) ValidateAgentKey(ctx context.Context, data *K8sKey) (bool, error) {
+	defer metrics.MongoTimer("validate_agent_key").ObserveDuration()
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	client, err := m.getConnection(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer m.disconnect(ctx, client)
 
-	ret, err := client.
-		Database(m.Config.Mongo.Hooks.Database).
-		Collection(m.Config.Mongo.Hooks.KeysCollection).
-		CountDocuments(m.CTX, map[string]string{
-			"company_id": data.ID,
+	var stored struct {
+		Key       string     `bson:"agent_key"`
+		Secret    string     `bson:"agent_secret"`
+		RevokedAt *time.Time `bson:"revoked_at,omitempty"`
+		ExpiresAt *time.Time `bson:"expires_at,omitempty"`
+	}
+	err = client.
+		Database(m.Config.MongoConfig().Agent.Database).
+		Collection(m.Config.MongoConfig().Agent.KeysCollection).
+		FindOne(ctx, map[string]string{
+			"company_id": normalizeOwner(data.ID),
+			"agent_key":  data.Key,
+		}).
+		Decode(&stored)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return checkState(K8sKey{
+		ID:     data.ID,
+		Key:    stored.Key,
+		Secret: stored.Secret,
+	}.Matches(*data), stored.RevokedAt, stored.ExpiresAt)
+}
+
+func (m *Mongo) ValidateUserKey(ctx context.Context, data UserKey) (bool, error) {
+	defer metrics.MongoTimer("validate_user_key").ObserveDuration()
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	client, err := m.getConnection(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer m.disconnect(ctx, client)
+
+	var stored UserKey
+	err = client.
+		Database(m.Config.MongoConfig().User.Database).
+		Collection(m.Config.MongoConfig().User.KeysCollection).
+		FindOne(ctx, map[string]string{
+			"user_id": normalizeOwner(data.ID),
+		}).
+		Decode(&stored)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return checkState(stored.Matches(data), stored.RevokedAt, stored.ExpiresAt)
+}
+
+func (m *Mongo) GetHooksSecret(ctx context.Context, data K8sKey) (string, error) {
+	defer metrics.MongoTimer("get_hooks_secret").ObserveDuration()
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	client, err := m.getConnection(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer m.disconnect(ctx, client)
+
+	var stored struct {
+		Secret    string     `bson:"secret"`
+		RevokedAt *time.Time `bson:"revoked_at,omitempty"`
+		ExpiresAt *time.Time `bson:"expires_at,omitempty"`
+	}
+	err = client.
+		Database(m.Config.MongoConfig().Hooks.Database).
+		Collection(m.Config.MongoConfig().Hooks.KeysCollection).
+		FindOne(ctx, map[string]string{
+			"company_id": normalizeOwner(data.ID),
 			"key":        data.Key,
-			"secret":     data.Secret,
+		}).
+		Decode(&stored)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	if _, err := checkState(true, stored.RevokedAt, stored.ExpiresAt); err != nil {
+		return "", err
+	}
+
+	return stored.Secret, nil
+}
+
+func (m *Mongo) UseNonce(ctx context.Context, nonce string, expires time.Time) (bool, error) {
+	defer metrics.MongoTimer("use_nonce").ObserveDuration()
+	ctx, cancel := m.writeContext(ctx)
+	defer cancel()
+
+	client, err := m.getConnection(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer m.disconnect(ctx, client)
+
+	_, err = client.
+		Database(m.Config.MongoConfig().Hooks.Database).
+		Collection(m.Config.MongoConfig().Hooks.NoncesCollection).
+		InsertOne(ctx, bson.D{
+			{Key: "_id", Value: nonce},
+			{Key: "expires_at", Value: expires},
 		})
 	if err != nil {
-		fmt.Printf("validateHooksKey ret err: %+v\n", err)
+		if mongo.IsDuplicateKeyError(err) {
+			return false, nil
+		}
 		return false, err
 	}
 
-	fmt.Printf("validateHooksKey ret: %d\n", ret)
+	return true, nil
+}
+
+// activeKeys matches keys that would still validate, ones that are revoked, marked expired or past their
+// expiry and not yet swept are left out
+func activeKeys(now time.Time) bson.M {
+	return bson.M{
+		"revoked_at": bson.M{"$exists": false},
+		"expired_at": bson.M{"$exists": false},
+		"$or": bson.A{
+			bson.M{"expires_at": bson.M{"$exists": false}},
+			bson.M{"expires_at": bson.M{"$gt": now}},
+		},
+	}
+}
+
+// CountKeys returns how many active keys of each type are stored
+func (m *Mongo) CountKeys(ctx context.Context) (map[string]int64, error) {
+	defer metrics.MongoTimer("count_keys").ObserveDuration()
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
 
-	if ret >= 1 {
-		return true, nil
+	client, err := m.getConnection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer m.disconnect(ctx, client)
+
+	counts := make(map[string]int64)
+	now := time.Now()
+	for keyType, db := range map[string]config.DB{
+		HooksKeyType: m.Config.MongoConfig().Hooks,
+		AgentKeyType: m.Config.MongoConfig().Agent,
+		UserKeyType:  m.Config.MongoConfig().User,
+	} {
+		n, err := client.
+			Database(db.Database).
+			Collection(db.KeysCollection).
+			CountDocuments(ctx, activeKeys(now))
+		if err != nil {
+			return nil, err
+		}
+		counts[keyType] = n
 	}
 
-	return false, nil
+	return counts, nil
 }
 
-func (m *Mongo) ValidateAgentKey(data *K8sKey) (bool, error) {
-	client, err := m.getConnection()
+// RevokeKey marks the key revoked, it stays stored so its history is kept but it will no longer validate
+func (m *Mongo) RevokeKey(ctx context.Context, keyType, ownerID, key string) (bool, error) {
+	defer metrics.MongoTimer("revoke_key").ObserveDuration()
+	ctx, cancel := m.writeContext(ctx)
+	defer cancel()
+
+	s, ok := m.stores()[keyType]
+	if !ok {
+		return false, ErrUnknownKeyType
+	}
+	ownerID = normalizeOwner(ownerID)
+
+	client, err := m.getConnection(ctx)
 	if err != nil {
-		fmt.Printf("validateAgentKey connection error: %s\n", err)
 		return false, err
 	}
-	defer func() {
-		if err := client.Disconnect(m.CTX); err != nil {
-			bugLog.Info(err)
+	defer m.disconnect(ctx, client)
+
+	revoked := false
+	err = m.withEvents(ctx, client, func(ctx context.Context) ([]events.Event, error) {
+		res, err := client.
+			Database(s.db.Database).
+			Collection(s.db.KeysCollection).
+			UpdateOne(ctx, bson.M{
+				s.owner:      ownerID,
+				s.key:        key,
+				"revoked_at": bson.M{"$exists": false},
+			}, bson.M{"$set": bson.M{"revoked_at": time.Now()}})
+		if err != nil {
+			return nil, err
+		}
+		revoked = res.ModifiedCount > 0
+		if !revoked {
+			return nil, nil
 		}
-	}()
 
-	ret, err := client.
-		Database(m.Config.Mongo.Agent.Database).
-		Collection(m.Config.Mongo.Agent.KeysCollection).
-		CountDocuments(m.CTX, map[string]string{
-			"company_id":   data.ID,
-			"agent_key":    data.Key,
-			"agent_secret": data.Secret,
-		})
+		return []events.Event{events.New(events.KeyRevoked, keyType, ownerID, key)}, nil
+	})
 	if err != nil {
-		fmt.Printf("validateAgentKey ret err: %+v\n", err)
 		return false, err
 	}
 
-	fmt.Printf("validateAgentKey ret: %d\n", ret)
+	return revoked, nil
+}
+
+// keyStore is where each type of key lives and what its owner and key id fields are called
+type keyStore struct {
+	db    config.DB
+	owner string
+	key   string
+}
+
+func (m *Mongo) stores() map[string]keyStore {
+	return map[string]keyStore{
+		HooksKeyType: {db: m.Config.MongoConfig().Hooks, owner: "company_id", key: "key"},
+		AgentKeyType: {db: m.Config.MongoConfig().Agent, owner: "company_id", key: "agent_key"},
+		UserKeyType:  {db: m.Config.MongoConfig().User, owner: "user_id", key: "key"},
+	}
+}
+
+// ExpireKeys marks keys past their expiry as expired and raises a KeyExpired event for each, a key is only
+// ever marked once so the event goes out once however many replicas are sweeping
+func (m *Mongo) ExpireKeys(ctx context.Context) (int, error) {
+	defer metrics.MongoTimer("expire_keys").ObserveDuration()
+
+	client, err := m.getConnection(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer m.disconnect(ctx, client)
+
+	expired := 0
+	for keyType, s := range m.stores() {
+		col := client.Database(s.db.Database).Collection(s.db.KeysCollection)
+		due := bson.M{
+			"expires_at": bson.M{"$lte": time.Now()},
+			"expired_at": bson.M{"$exists": false},
+			"revoked_at": bson.M{"$exists": false},
+		}
+
+		// the sweep as a whole can take a while, each query and update in it gets its own timeout
+		findCtx, cancel := m.queryContext(ctx)
+		var docs []bson.M
+		cur, err := col.Find(findCtx, due)
+		if err == nil {
+			err = cur.All(findCtx, &docs)
+		}
+		cancel()
+		if err != nil {
+			return expired, err
+		}
+
+		for _, doc := range docs {
+			keyType := keyType
+			owner, _ := doc[s.owner].(string)
+			key, _ := doc[s.key].(string)
+
+			marked := false
+			writeCtx, cancel := m.writeContext(ctx)
+			err := m.withEvents(writeCtx, client, func(ctx context.Context) ([]events.Event, error) {
+				res, err := col.UpdateOne(ctx, bson.M{
+					"_id":        doc["_id"],
+					"expired_at": bson.M{"$exists": false},
+				}, bson.M{"$set": bson.M{"expired_at": time.Now()}})
+				if err != nil {
+					return nil, err
+				}
+				marked = res.ModifiedCount > 0
+				if !marked {
+					return nil, nil
+				}
+
+				return []events.Event{events.New(events.KeyExpired, keyType, owner, key)}, nil
+			})
+			cancel()
+			if err != nil {
+				return expired, err
+			}
+			if marked {
+				expired++
+			}
+		}
+	}
 
-	return ret >= 1, nil
+	return expired, nil
 }
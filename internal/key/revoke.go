@@ -0,0 +1,63 @@
+package key
+
+import (
+	"context"
+	"errors"
+
+	"github.com/go-kit/log/level"
+	"github.com/k8sdeploy/key-service/internal/audit"
+	kspb "github.com/k8sdeploy/key-service/internal/generated/keyservice/v1"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+)
+
+func (s *Server) RevokeKey(c context.Context, r *kspb.RevokeKeyRequest) (*kspb.RevokeKeyResponse, error) {
+	if err := s.checkServiceKey(r.ServiceKey); err != nil {
+		return nil, err
+	}
+
+	if r.OwnerId == "" {
+		return nil, missingField("owner_id", MissingOwnerID)
+	}
+	if r.Key == "" {
+		return nil, missingField("key", MissingKey)
+	}
+
+	revoked, err := s.store().RevokeKey(c, r.KeyType, r.OwnerId, r.Key)
+	if err != nil {
+		if errors.Is(err, ErrUnknownKeyType) {
+			return nil, rpcError(codes.InvalidArgument, ReasonUnknownKeyType, UnknownKeyType, &errdetails.BadRequest_FieldViolation{
+				Field:       "key_type",
+				Description: UnknownKeyType,
+			})
+		}
+
+		_ = level.Error(s.logger(c)).Log("msg", "revoking key", "key_type", r.KeyType, "owner_id", r.OwnerId, "key_id", r.Key, "err", err)
+		return nil, systemError(c, err)
+	}
+
+	if revoked {
+		s.invalidate(c, r.KeyType, r.OwnerId)
+	}
+
+	outcome := audit.Success
+	if !revoked {
+		outcome = audit.Failure
+	}
+	s.audit(c, audit.Record{
+		Action:  audit.Revoke,
+		Actor:   s.Principal(r.ServiceKey),
+		KeyType: r.KeyType,
+		Owner:   r.OwnerId,
+		KeyID:   r.Key,
+		Outcome: outcome,
+	})
+
+	if !revoked {
+		return nil, rpcError(codes.NotFound, ReasonKeyNotFound, KeyNotFound)
+	}
+
+	return &kspb.RevokeKeyResponse{
+		Revoked: true,
+	}, nil
+}
@@ -0,0 +1,95 @@
+package key
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/log/level"
+	kspb "github.com/k8sdeploy/key-service/internal/generated/keyservice/v1"
+	"github.com/k8sdeploy/key-service/internal/signature"
+)
+
+// companyNonces scopes nonces to a company so two senders can never collide, the company id is length
+// prefixed so no company id and nonce can run together into another's
+type companyNonces struct {
+	companyID string
+	store     signature.NonceStore
+}
+
+func (c companyNonces) UseNonce(ctx context.Context, nonce string, expires time.Time) (bool, error) {
+	return c.store.UseNonce(ctx, fmt.Sprintf("%d:%s:%s", len(c.companyID), c.companyID, nonce), expires)
+}
+
+func (s *Server) VerifySignature(c context.Context, r *kspb.VerifySignatureRequest) (*kspb.VerifySignatureResponse, error) {
+	if err := s.checkServiceKey(r.ServiceKey); err != nil {
+		return nil, err
+	}
+
+	if r.CompanyId == "" {
+		return nil, missingField("company_id", MissingCompanyID)
+	}
+	if r.Key == "" {
+		return nil, missingField("key", MissingKey)
+	}
+	if err := s.limited(c, r.CompanyId, r.Key); err != nil {
+		return nil, err
+	}
+
+	m := s.store()
+	secret, err := m.GetHooksSecret(c, K8sKey{
+		ID:  r.CompanyId,
+		Key: r.Key,
+	})
+	if stateErr := keyStateError(err); stateErr != nil {
+		s.signatureFailed(c, r)
+		return nil, stateErr
+	}
+	if err != nil {
+		_ = level.Error(s.logger(c)).Log("msg", "getting hook secret", "company_id", r.CompanyId, "err", err)
+		return nil, systemError(c, err)
+	}
+	// an unknown key looks the same as a bad signature, so keys can't be probed for
+	if secret == "" {
+		s.signatureFailed(c, r)
+		return nil, signatureError(signature.ErrInvalidSignature)
+	}
+
+	v := signature.NewVerifier(s.Config.SignatureWindow, companyNonces{
+		companyID: normalizeOwner(r.CompanyId),
+		store:     m,
+	})
+	if err := v.Verify(c, secret, signature.Request{
+		Payload:   r.Payload,
+		Timestamp: r.Timestamp,
+		Nonce:     r.Nonce,
+		Signature: r.Signature,
+	}); err != nil {
+		switch {
+		case errors.Is(err, signature.ErrMissingNonce):
+			return nil, missingField("nonce", err.Error())
+		case errors.Is(err, signature.ErrMissingSignature):
+			return nil, missingField("signature", err.Error())
+		}
+		if sigErr := signatureError(err); sigErr != nil {
+			s.signatureFailed(c, r)
+			return nil, sigErr
+		}
+
+		_ = level.Error(s.logger(c)).Log("msg", "verifying signature", "company_id", r.CompanyId, "err", err)
+		return nil, systemError(c, err)
+	}
+
+	s.recordValidation(c, r.CompanyId, r.Key, true)
+
+	return &kspb.VerifySignatureResponse{
+		Valid: true,
+	}, nil
+}
+
+// signatureFailed counts towards the key's lockout and is audited like any other failed validation
+func (s *Server) signatureFailed(ctx context.Context, r *kspb.VerifySignatureRequest) {
+	s.recordValidation(ctx, r.CompanyId, r.Key, false)
+	s.auditFailure(ctx, HooksKeyType, r.ServiceKey, r.CompanyId, r.Key)
+}
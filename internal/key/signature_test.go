@@ -0,0 +1,99 @@
+package key_test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/k8sdeploy/key-service/internal/audit"
+	"github.com/k8sdeploy/key-service/internal/config"
+	kspb "github.com/k8sdeploy/key-service/internal/generated/keyservice/v1"
+	"github.com/k8sdeploy/key-service/internal/key"
+	"github.com/k8sdeploy/key-service/internal/logging"
+	"github.com/k8sdeploy/key-service/internal/ratelimit"
+	"github.com/k8sdeploy/key-service/internal/signature"
+)
+
+func TestCompanyNonces_Unambiguous(t *testing.T) {
+	store := signature.NewMemoryNonceStore()
+	expires := time.Now().Add(time.Minute)
+
+	// without the length these would both be a:b:c
+	for _, use := range []struct {
+		company string
+		nonce   string
+	}{
+		{"a:b", "c"},
+		{"a", "b:c"},
+	} {
+		ok, err := key.CompanyNonces(use.company, store).UseNonce(context.Background(), use.nonce, expires)
+		if err != nil || !ok {
+			t.Errorf("UseNonce(%q, %q) = %v, %v, want it unused", use.company, use.nonce, ok, err)
+		}
+	}
+}
+
+func TestServer_VerifySignature_Failures(t *testing.T) {
+	serviceKey := "orchestrator-key"
+	cfg := &config.Config{}
+	cfg.Orchestrator.Key = serviceKey
+	cfg.SignatureWindow = time.Minute
+	cfg.RateLimit.Window = time.Minute
+	cfg.RateLimit.MaxFailures = 2
+	cfg.RateLimit.FailureTTL = time.Minute
+	cfg.RateLimit.LockoutBase = time.Minute
+	cfg.RateLimit.LockoutLimit = time.Minute
+
+	m := key.NewMemoryStore(cfg, nil)
+	if _, err := m.InsertHooksKey(context.Background(), key.K8sKey{ID: "company", Key: "key", Secret: "secret"}); err != nil {
+		t.Fatalf("InsertHooksKey() = %v", err)
+	}
+	log := audit.NewMemory([]byte("hmac"))
+	s := &key.Server{
+		Config:  cfg,
+		Store:   m,
+		Audit:   log,
+		Limiter: key.NewLimiter(cfg, ratelimit.NewMemory()),
+		Logger:  logging.New(io.Discard, false),
+	}
+
+	signed := func(secret, nonce string) *kspb.VerifySignatureRequest {
+		now := time.Now().Unix()
+		return &kspb.VerifySignatureRequest{
+			ServiceKey: serviceKey,
+			CompanyId:  "company",
+			Key:        "key",
+			Payload:    []byte("payload"),
+			Timestamp:  now,
+			Nonce:      nonce,
+			Signature:  signature.Sign(secret, now, nonce, []byte("payload")),
+		}
+	}
+
+	for i, want := range []string{key.ReasonInvalidSignature, key.ReasonInvalidSignature, key.ReasonLockedOut} {
+		// the last one is signed properly, it is turned away because of the failures before it
+		secret := "wrong"
+		if i == 2 {
+			secret = "secret"
+		}
+		_, err := s.VerifySignature(context.Background(), signed(secret, string(rune('a'+i))))
+		if got := key.Reason(err); got != want {
+			t.Errorf("VerifySignature() %d = %v, want %s", i, err, want)
+		}
+	}
+
+	records, err := log.Query(context.Background(), audit.Filter{Owner: "company"})
+	if err != nil {
+		t.Fatalf("Query() = %v", err)
+	}
+	failed := 0
+	for _, r := range records {
+		if r.Action == audit.ValidationFailed && r.KeyType == key.HooksKeyType && r.KeyID == "key" {
+			failed++
+		}
+	}
+	if failed != 2 {
+		t.Errorf("audited %d failed signatures, want 2", failed)
+	}
+}
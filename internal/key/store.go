@@ -0,0 +1,230 @@
+package key
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/k8sdeploy/key-service/internal/config"
+	"github.com/k8sdeploy/key-service/internal/events"
+	"github.com/k8sdeploy/key-service/internal/signature"
+)
+
+// Store is where keys are kept, Mongo in production and MemoryStore in development
+type Store interface {
+	signature.NonceStore
+
+	InsertHooksKey(ctx context.Context, data K8sKey) (bool, error)
+	UpsertUser(ctx context.Context, data UserKey) (bool, error)
+	ValidateHooksKey(ctx context.Context, data K8sKey) (bool, error)
+	ValidateAgentKey(ctx context.Context, data *K8sKey) (bool, error)
+	ValidateUserKey(ctx context.Context, data UserKey) (bool, error)
+	GetHooksSecret(ctx context.Context, data K8sKey) (string, error)
+	RevokeKey(ctx context.Context, keyType, ownerID, key string) (bool, error)
+	CountKeys(ctx context.Context) (map[string]int64, error)
+	ExpireKeys(ctx context.Context) (int, error)
+	Ping(ctx context.Context) error
+}
+
+// store is the Store requests use, with no Store set that is Mongo
+func (s *Server) store() Store {
+	if s.Store != nil {
+		return s.Store
+	}
+
+	return s.mongo()
+}
+
+type memoryKey struct {
+	key       string
+	secret    string
+	revokedAt *time.Time
+	expiresAt *time.Time
+	expired   bool
+}
+
+// MemoryStore keeps keys in process for development, events go straight to the publisher as there is
+// nothing to keep them consistent with
+type MemoryStore struct {
+	mu     sync.Mutex
+	Config *config.Config
+	Events events.Publisher
+	Now    func() time.Time
+
+	keys   map[string]map[string]*memoryKey
+	nonces *signature.MemoryNonceStore
+}
+
+func NewMemoryStore(c *config.Config, publisher events.Publisher) *MemoryStore {
+	return &MemoryStore{
+		Config: c,
+		Events: publisher,
+		Now:    time.Now,
+		keys: map[string]map[string]*memoryKey{
+			HooksKeyType: {},
+			AgentKeyType: {},
+			UserKeyType:  {},
+		},
+		nonces: signature.NewMemoryNonceStore(),
+	}
+}
+
+func (m *MemoryStore) publish(e events.Event) {
+	if m.Events != nil {
+		_ = m.Events.Publish(context.Background(), e)
+	}
+}
+
+func (m *MemoryStore) issue(keyType, owner, key, secret string) (bool, error) {
+	m.mu.Lock()
+	_, rotated := m.keys[keyType][owner]
+	k := &memoryKey{
+		key:    key,
+		secret: secret,
+	}
+	if m.Config.KeyLifetime > 0 {
+		expires := m.Now().Add(m.Config.KeyLifetime)
+		k.expiresAt = &expires
+	}
+	m.keys[keyType][owner] = k
+	m.mu.Unlock()
+
+	m.publish(issuedEvent(rotated, keyType, owner, key))
+
+	return rotated, nil
+}
+
+func (m *MemoryStore) InsertHooksKey(ctx context.Context, data K8sKey) (bool, error) {
+	return m.issue(HooksKeyType, normalizeOwner(data.ID), data.Key, data.Secret)
+}
+
+func (m *MemoryStore) UpsertUser(ctx context.Context, data UserKey) (bool, error) {
+	return m.issue(UserKeyType, normalizeOwner(data.ID), data.Key, data.Secret)
+}
+
+// lookup returns the stored key for the owner, only if its key id is the one presented
+func (m *MemoryStore) lookup(keyType, owner, key string) (memoryKey, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k, ok := m.keys[keyType][owner]
+	if !ok || k.key != key {
+		return memoryKey{}, false
+	}
+
+	return *k, true
+}
+
+func (m *MemoryStore) validate(keyType, owner, key, secret string) (bool, error) {
+	k, ok := m.lookup(keyType, owner, key)
+	if !ok {
+		return false, nil
+	}
+
+	return m.checkState(K8sKey{Key: k.key, Secret: k.secret}.Matches(K8sKey{Key: key, Secret: secret}), k)
+}
+
+// checkState is checkState against the store's clock
+func (m *MemoryStore) checkState(matched bool, k memoryKey) (bool, error) {
+	if matched && k.revokedAt == nil && (k.expired || (k.expiresAt != nil && !k.expiresAt.After(m.Now()))) {
+		return false, ErrExpired
+	}
+
+	return checkState(matched, k.revokedAt, nil)
+}
+
+func (m *MemoryStore) ValidateHooksKey(ctx context.Context, data K8sKey) (bool, error) {
+	return m.validate(HooksKeyType, normalizeOwner(data.ID), data.Key, data.Secret)
+}
+
+func (m *MemoryStore) ValidateAgentKey(ctx context.Context, data *K8sKey) (bool, error) {
+	return m.validate(AgentKeyType, normalizeOwner(data.ID), data.Key, data.Secret)
+}
+
+func (m *MemoryStore) ValidateUserKey(ctx context.Context, data UserKey) (bool, error) {
+	return m.validate(UserKeyType, normalizeOwner(data.ID), data.Key, data.Secret)
+}
+
+func (m *MemoryStore) GetHooksSecret(ctx context.Context, data K8sKey) (string, error) {
+	k, ok := m.lookup(HooksKeyType, normalizeOwner(data.ID), data.Key)
+	if !ok {
+		return "", nil
+	}
+	if _, err := m.checkState(true, k); err != nil {
+		return "", err
+	}
+
+	return k.secret, nil
+}
+
+func (m *MemoryStore) UseNonce(ctx context.Context, nonce string, expires time.Time) (bool, error) {
+	return m.nonces.UseNonce(ctx, nonce, expires)
+}
+
+func (m *MemoryStore) RevokeKey(ctx context.Context, keyType, ownerID, key string) (bool, error) {
+	if _, ok := m.keys[keyType]; !ok {
+		return false, ErrUnknownKeyType
+	}
+	ownerID = normalizeOwner(ownerID)
+
+	m.mu.Lock()
+	k, ok := m.keys[keyType][ownerID]
+	if !ok || k.key != key || k.revokedAt != nil {
+		m.mu.Unlock()
+		return false, nil
+	}
+	now := m.Now()
+	k.revokedAt = &now
+	m.mu.Unlock()
+
+	m.publish(events.New(events.KeyRevoked, keyType, ownerID, key))
+
+	return true, nil
+}
+
+// CountKeys counts the keys that would still validate, the same as Mongo does
+func (m *MemoryStore) CountKeys(ctx context.Context) (map[string]int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.Now()
+	counts := make(map[string]int64)
+	for keyType, keys := range m.keys {
+		counts[keyType] = 0
+		for _, k := range keys {
+			if k.revokedAt != nil || k.expired || (k.expiresAt != nil && !k.expiresAt.After(now)) {
+				continue
+			}
+			counts[keyType]++
+		}
+	}
+
+	return counts, nil
+}
+
+func (m *MemoryStore) ExpireKeys(ctx context.Context) (int, error) {
+	var expired []events.Event
+
+	m.mu.Lock()
+	now := m.Now()
+	for keyType, keys := range m.keys {
+		for owner, k := range keys {
+			if k.expired || k.revokedAt != nil || k.expiresAt == nil || k.expiresAt.After(now) {
+				continue
+			}
+			k.expired = true
+			expired = append(expired, events.New(events.KeyExpired, keyType, owner, k.key))
+		}
+	}
+	m.mu.Unlock()
+
+	for _, e := range expired {
+		m.publish(e)
+	}
+
+	return len(expired), nil
+}
+
+func (m *MemoryStore) Ping(ctx context.Context) error {
+	return nil
+}
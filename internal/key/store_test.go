@@ -0,0 +1,179 @@
+package key_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/k8sdeploy/key-service/internal/config"
+	"github.com/k8sdeploy/key-service/internal/events"
+	"github.com/k8sdeploy/key-service/internal/key"
+)
+
+func TestMemoryStore(t *testing.T) {
+	tests := []struct {
+		name      string
+		run       func(m *key.MemoryStore, now *time.Time) (bool, error)
+		wantValid bool
+		wantErr   error
+		wantTypes []string
+	}{
+		{
+			name: "issued key validates",
+			run: func(m *key.MemoryStore, now *time.Time) (bool, error) {
+				return m.ValidateHooksKey(context.Background(), key.K8sKey{ID: "company", Key: "key", Secret: "secret"})
+			},
+			wantValid: true,
+			wantTypes: []string{events.KeyCreated},
+		},
+		{
+			name: "wrong secret",
+			run: func(m *key.MemoryStore, now *time.Time) (bool, error) {
+				return m.ValidateHooksKey(context.Background(), key.K8sKey{ID: "company", Key: "key", Secret: "wrong"})
+			},
+			wantTypes: []string{events.KeyCreated},
+		},
+		{
+			name: "rotated key replaces the old one",
+			run: func(m *key.MemoryStore, now *time.Time) (bool, error) {
+				if _, err := m.InsertHooksKey(context.Background(), key.K8sKey{ID: "company", Key: "new", Secret: "new"}); err != nil {
+					return false, err
+				}
+				return m.ValidateHooksKey(context.Background(), key.K8sKey{ID: "company", Key: "key", Secret: "secret"})
+			},
+			wantTypes: []string{events.KeyCreated, events.KeyRotated},
+		},
+		{
+			name: "revoked",
+			run: func(m *key.MemoryStore, now *time.Time) (bool, error) {
+				if revoked, err := m.RevokeKey(context.Background(), key.HooksKeyType, "company", "key"); !revoked || err != nil {
+					return false, errors.New("key wasn't revoked")
+				}
+				return m.ValidateHooksKey(context.Background(), key.K8sKey{ID: "company", Key: "key", Secret: "secret"})
+			},
+			wantErr:   key.ErrRevoked,
+			wantTypes: []string{events.KeyCreated, events.KeyRevoked},
+		},
+		{
+			name: "expired",
+			run: func(m *key.MemoryStore, now *time.Time) (bool, error) {
+				*now = now.Add(2 * time.Hour)
+				if n, err := m.ExpireKeys(context.Background()); n != 1 || err != nil {
+					return false, errors.New("key wasn't expired")
+				}
+				return m.ValidateHooksKey(context.Background(), key.K8sKey{ID: "company", Key: "key", Secret: "secret"})
+			},
+			wantErr:   key.ErrExpired,
+			wantTypes: []string{events.KeyCreated, events.KeyExpired},
+		},
+		{
+			name: "dashed company id",
+			run: func(m *key.MemoryStore, now *time.Time) (bool, error) {
+				if _, err := m.InsertHooksKey(context.Background(), key.K8sKey{ID: "company-1", Key: "key", Secret: "secret"}); err != nil {
+					return false, err
+				}
+				if secret, err := m.GetHooksSecret(context.Background(), key.K8sKey{ID: "company-1", Key: "key"}); secret != "secret" || err != nil {
+					return false, errors.New("secret wasn't found")
+				}
+				if revoked, err := m.RevokeKey(context.Background(), key.HooksKeyType, "company-1", "key"); !revoked || err != nil {
+					return false, errors.New("key wasn't revoked")
+				}
+				return m.ValidateHooksKey(context.Background(), key.K8sKey{ID: "company-1", Key: "key", Secret: "secret"})
+			},
+			wantErr:   key.ErrRevoked,
+			wantTypes: []string{events.KeyCreated, events.KeyCreated, events.KeyRevoked},
+		},
+		{
+			name: "unknown key type",
+			run: func(m *key.MemoryStore, now *time.Time) (bool, error) {
+				return m.RevokeKey(context.Background(), "bob", "company", "key")
+			},
+			wantErr:   key.ErrUnknownKeyType,
+			wantTypes: []string{events.KeyCreated},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.KeyLifetime = time.Hour
+			bus := events.NewMemory()
+			now := time.Now()
+
+			m := key.NewMemoryStore(cfg, bus)
+			m.Now = func() time.Time { return now }
+			if _, err := m.InsertHooksKey(context.Background(), key.K8sKey{ID: "company", Key: "key", Secret: "secret"}); err != nil {
+				t.Fatalf("InsertHooksKey() = %v", err)
+			}
+
+			valid, err := test.run(m, &now)
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("err = %v, want %v", err, test.wantErr)
+			}
+			if valid != test.wantValid {
+				t.Errorf("valid = %v, want %v", valid, test.wantValid)
+			}
+
+			published := bus.Published()
+			if len(published) != len(test.wantTypes) {
+				t.Fatalf("published %d events, want %d", len(published), len(test.wantTypes))
+			}
+			for i, e := range published {
+				if e.Type != test.wantTypes[i] {
+					t.Errorf("event %d = %s, want %s", i, e.Type, test.wantTypes[i])
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryStore_CountKeys(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.KeyLifetime = time.Hour
+	now := time.Now()
+
+	m := key.NewMemoryStore(cfg, nil)
+	m.Now = func() time.Time { return now }
+	ctx := context.Background()
+	issue := func(companies ...string) {
+		for _, company := range companies {
+			if _, err := m.InsertHooksKey(ctx, key.K8sKey{ID: company, Key: "key", Secret: "secret"}); err != nil {
+				t.Fatalf("InsertHooksKey() = %v", err)
+			}
+		}
+	}
+
+	issue("revoked", "expired", "past-expiry")
+	if _, err := m.RevokeKey(ctx, key.HooksKeyType, "revoked", "key"); err != nil {
+		t.Fatalf("RevokeKey() = %v", err)
+	}
+	if _, err := m.UpsertUser(ctx, key.UserKey{ID: "user", Key: "key", Secret: "secret"}); err != nil {
+		t.Fatalf("UpsertUser() = %v", err)
+	}
+
+	// expired is marked by the sweep, past-expiry is issued after it so it is only past its expiry
+	now = now.Add(2 * time.Hour)
+	issue("active")
+	if _, err := m.ExpireKeys(ctx); err != nil {
+		t.Fatalf("ExpireKeys() = %v", err)
+	}
+	now = now.Add(-2 * time.Hour)
+	issue("past-expiry")
+	now = now.Add(2 * time.Hour)
+
+	counts, err := m.CountKeys(ctx)
+	if err != nil {
+		t.Fatalf("CountKeys() = %v", err)
+	}
+	want := map[string]int64{
+		key.HooksKeyType: 1,
+		key.AgentKeyType: 0,
+		key.UserKeyType:  0,
+	}
+	for keyType, n := range want {
+		if counts[keyType] != n {
+			t.Errorf("CountKeys()[%s] = %d, want %d", keyType, counts[keyType], n)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package key
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+
+	"github.com/go-kit/log/level"
+	"github.com/k8sdeploy/key-service/internal/events"
+	kspb "github.com/k8sdeploy/key-service/internal/generated/keyservice/v1"
+	"github.com/k8sdeploy/key-service/internal/logging"
+	"github.com/k8sdeploy/key-service/internal/tracing"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Status
+const (
+	InvalidCursor = "invalid cursor"
+	ExpiredCursor = "cursor expired"
+)
+
+// changeStreamHistoryLost is the mongo error code for a resume token that has fallen off the oplog
+const changeStreamHistoryLost = 286
+
+// MongoWatcher follows the outbox with a change stream, so it sees the events written by every replica,
+// the cursor is the change stream resume token
+type MongoWatcher struct {
+	*Mongo
+}
+
+// NewMongoWatcher follows the outbox through m, sharing its client
+func NewMongoWatcher(m *Mongo) *MongoWatcher {
+	return &MongoWatcher{
+		Mongo: m,
+	}
+}
+
+func NewWatcher(m *Mongo) events.Watcher {
+	if !m.Config.Events.Watch {
+		return nil
+	}
+
+	return NewMongoWatcher(m)
+}
+
+// Watch reopens the change stream from where it got to whenever the client is reopened with reloaded
+// credentials, as the open stream would otherwise carry on with the old ones
+func (m *MongoWatcher) Watch(ctx context.Context, f events.Filter, cursor string, fn func(e events.Event, cursor string) error) error {
+	for {
+		changed := m.clientChanged()
+		streamCtx, cancel := context.WithCancel(ctx)
+		go func() {
+			select {
+			case <-changed:
+				cancel()
+			case <-streamCtx.Done():
+			}
+		}()
+
+		err := m.watch(streamCtx, f, &cursor, fn)
+		cancel()
+		if ctx.Err() != nil {
+			return err
+		}
+		select {
+		case <-changed:
+			_ = level.Info(logging.FromContext(ctx, m.Logger)).Log("msg", "mongo credentials changed, reopening change stream")
+		default:
+			return err
+		}
+	}
+}
+
+func (m *MongoWatcher) watch(ctx context.Context, f events.Filter, cursor *string, fn func(e events.Event, cursor string) error) error {
+	match := bson.D{{Key: "operationType", Value: "insert"}}
+	if f.Owner != "" {
+		match = append(match, bson.E{Key: "fullDocument.owner", Value: f.Owner})
+	}
+	if f.KeyType != "" {
+		match = append(match, bson.E{Key: "fullDocument.key_type", Value: f.KeyType})
+	}
+
+	opts := options.ChangeStream()
+	if *cursor != "" {
+		token, err := base64.RawURLEncoding.DecodeString(*cursor)
+		if err != nil {
+			return events.ErrInvalidCursor
+		}
+		opts.SetResumeAfter(bson.Raw(token))
+	}
+
+	client, err := m.getConnection(ctx)
+	if err != nil {
+		return err
+	}
+	defer m.disconnect(ctx, client)
+
+	stream, err := outboxCollection(client, m.Config).Watch(ctx, mongo.Pipeline{{{Key: "$match", Value: match}}}, opts)
+	if err != nil {
+		var cmdErr mongo.CommandError
+		if errors.As(err, &cmdErr) && cmdErr.Code == changeStreamHistoryLost {
+			return events.ErrCursorExpired
+		}
+		return err
+	}
+	defer func() {
+		_ = stream.Close(tracing.Detach(ctx))
+	}()
+
+	for stream.Next(ctx) {
+		var change struct {
+			FullDocument events.Event `bson:"fullDocument"`
+		}
+		if err := stream.Decode(&change); err != nil {
+			return err
+		}
+
+		*cursor = base64.RawURLEncoding.EncodeToString(stream.ResumeToken())
+		if err := fn(change.FullDocument, *cursor); err != nil {
+			return err
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return stream.Err()
+}
+
+func (s *Server) WatchKeys(r *kspb.WatchKeysRequest, stream kspb.ExtendedKeyService_WatchKeysServer) error {
+	if err := s.checkServiceKey(r.ServiceKey); err != nil {
+		return err
+	}
+
+	if s.Watcher == nil {
+		return rpcError(codes.Unimplemented, ReasonNotImplemented, "watching keys is not enabled")
+	}
+
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	closing := s.closingChan()
+	go func() {
+		select {
+		case <-closing:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	err := s.Watcher.Watch(ctx, events.Filter{
+		Owner:   r.CompanyId,
+		KeyType: r.KeyType,
+	}, r.Cursor, func(e events.Event, cursor string) error {
+		return stream.Send(&kspb.KeyEvent{
+			Id:      e.ID,
+			Type:    e.Type,
+			KeyType: e.KeyType,
+			Owner:   e.Owner,
+			KeyId:   e.KeyID,
+			Time:    timestamppb.New(e.Time),
+			Cursor:  cursor,
+		})
+	})
+	if cursorErr := cursorError(err); cursorErr != nil {
+		return cursorErr
+	}
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		// the subscriber went away
+		return nil
+	case err != nil:
+		_ = level.Error(s.logger(ctx)).Log("msg", "watching keys", "company_id", r.CompanyId, "err", err)
+		return systemError(ctx, err)
+	}
+
+	return nil
+}
+
+// principalStream tags the stream with the calling service once its request has been read
+type principalStream struct {
+	grpc.ServerStream
+	server *Server
+}
+
+func (p *principalStream) RecvMsg(m interface{}) error {
+	if err := p.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	if r, ok := m.(interface{ GetServiceKey() string }); ok {
+		if principal := p.server.Principal(r.GetServiceKey()); principal != "" {
+			logging.SetPrincipal(p.Context(), principal)
+		}
+	}
+
+	return nil
+}
+
+// StreamPrincipalInterceptor is the streaming version of PrincipalInterceptor
+func (s *Server) StreamPrincipalInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &principalStream{
+			ServerStream: stream,
+			server:       s,
+		})
+	}
+}
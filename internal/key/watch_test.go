@@ -0,0 +1,155 @@
+package key_test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/k8sdeploy/key-service/internal/config"
+	"github.com/k8sdeploy/key-service/internal/events"
+	kspb "github.com/k8sdeploy/key-service/internal/generated/keyservice/v1"
+	"github.com/k8sdeploy/key-service/internal/key"
+	"github.com/k8sdeploy/key-service/internal/logging"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type watchStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	cancel context.CancelFunc
+	want   int
+	sent   []*kspb.KeyEvent
+}
+
+func (w *watchStream) Context() context.Context {
+	return w.ctx
+}
+
+func (w *watchStream) Send(e *kspb.KeyEvent) error {
+	w.sent = append(w.sent, e)
+	if len(w.sent) == w.want {
+		w.cancel()
+	}
+
+	return nil
+}
+
+func newWatchStream(want int) *watchStream {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &watchStream{
+		ctx:    ctx,
+		cancel: cancel,
+		want:   want,
+	}
+}
+
+func TestServer_WatchKeys(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Orchestrator.Key = "orchestrator-key"
+
+	hub := events.NewHub(10)
+	evs := []events.Event{
+		events.New(events.KeyCreated, key.HooksKeyType, "company-1", "key-1"),
+		events.New(events.KeyCreated, key.HooksKeyType, "company-2", "key-2"),
+		events.New(events.KeyRevoked, key.HooksKeyType, "company-1", "key-1"),
+	}
+	for _, e := range evs {
+		_ = hub.Publish(context.Background(), e)
+	}
+
+	s := &key.Server{
+		Config:  cfg,
+		Watcher: hub,
+		Logger:  logging.New(io.Discard, false),
+	}
+
+	tests := []struct {
+		name     string
+		request  *kspb.WatchKeysRequest
+		want     int
+		wantIDs  []string
+		wantCode codes.Code
+	}{
+		{
+			name: "bad service key",
+			request: &kspb.WatchKeysRequest{
+				ServiceKey: "wrong",
+			},
+			wantCode: codes.PermissionDenied,
+		},
+		{
+			name: "company from the start",
+			request: &kspb.WatchKeysRequest{
+				ServiceKey: "orchestrator-key",
+				CompanyId:  "company-1",
+				Cursor:     "0",
+			},
+			want:    2,
+			wantIDs: []string{evs[0].ID, evs[2].ID},
+		},
+		{
+			name: "resumed",
+			request: &kspb.WatchKeysRequest{
+				ServiceKey: "orchestrator-key",
+				Cursor:     "2",
+			},
+			want:    1,
+			wantIDs: []string{evs[2].ID},
+		},
+		{
+			name: "bad cursor",
+			request: &kspb.WatchKeysRequest{
+				ServiceKey: "orchestrator-key",
+				Cursor:     "not-a-cursor",
+			},
+			wantCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := newWatchStream(tt.want)
+			if err := s.WatchKeys(tt.request, stream); status.Code(err) != tt.wantCode {
+				t.Fatalf("WatchKeys() = %v, want %v", err, tt.wantCode)
+			}
+			if len(stream.sent) != tt.want {
+				t.Fatalf("WatchKeys() sent %d events, want %d", len(stream.sent), tt.want)
+			}
+			for i, id := range tt.wantIDs {
+				if stream.sent[i].Id != id {
+					t.Errorf("event %d = %s, want %s", i, stream.sent[i].Id, id)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_ShutdownEndsWatch(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Orchestrator.Key = "orchestrator-key"
+
+	s := &key.Server{
+		Config:  cfg,
+		Watcher: events.NewHub(10),
+		Logger:  logging.New(io.Discard, false),
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- s.WatchKeys(&kspb.WatchKeysRequest{ServiceKey: "orchestrator-key"}, newWatchStream(1))
+	}()
+
+	s.Shutdown()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("WatchKeys() = %v, want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("WatchKeys() still running after Shutdown()")
+	}
+}
@@ -0,0 +1,107 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/kit/ctxkit"
+	grpc_ctxtags "github.com/grpc-ecosystem/go-grpc-middleware/tags"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	RequestIDHeader = "x-request-id"
+
+	RequestIDField = "request_id"
+	MethodField    = "method"
+	PeerField      = "peer.address"
+	PrincipalField = "principal"
+)
+
+// FromContext returns the logger with the request fields (request id, method, peer, principal) attached,
+// outside a request it is just the logger passed in
+func FromContext(ctx context.Context, logger log.Logger) log.Logger {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+
+	fields := ctxkit.TagsToFields(ctx)
+	if len(fields) == 0 {
+		return logger
+	}
+
+	return log.With(logger, fields...)
+}
+
+// SetPrincipal records who is making the request so every log line for it carries the caller
+func SetPrincipal(ctx context.Context, principal string) {
+	grpc_ctxtags.Extract(ctx).Set(PrincipalField, principal)
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+
+	return hex.EncodeToString(b)
+}
+
+func requestID(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(RequestIDHeader); len(ids) > 0 && ids[0] != "" {
+			return ids[0]
+		}
+	}
+
+	return newRequestID()
+}
+
+func tagRequest(ctx context.Context, method string) {
+	id := requestID(ctx)
+	grpc_ctxtags.Extract(ctx).
+		Set(RequestIDField, id).
+		Set(MethodField, method)
+
+	_ = grpc.SetHeader(ctx, metadata.Pairs(RequestIDHeader, id))
+}
+
+// UnaryServerInterceptor tags the call with its request id and method, it has to run after grpc_ctxtags
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		tagRequest(ctx, info.FullMethod)
+
+		return handler(ctx, req)
+	}
+}
+
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		tagRequest(stream.Context(), info.FullMethod)
+
+		return handler(srv, stream)
+	}
+}
+
+// Middleware does the same for http requests, it uses the id from chi's RequestID middleware so it has to come after it
+func Middleware(logger log.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tags := grpc_ctxtags.NewTags().
+				Set(RequestIDField, middleware.GetReqID(r.Context())).
+				Set(MethodField, r.Method+" "+r.URL.Path).
+				Set(PeerField, r.RemoteAddr)
+			ctx := grpc_ctxtags.SetInContext(r.Context(), tags)
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+
+			_ = level.Debug(FromContext(ctx, logger)).Log("msg", "finished http request")
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package logging_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-kit/log/level"
+	grpc_ctxtags "github.com/grpc-ecosystem/go-grpc-middleware/tags"
+	"github.com/k8sdeploy/key-service/internal/logging"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.New(&buf, false)
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(logging.RequestIDHeader, "request-1"))
+	ctx = peer.NewContext(ctx, &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234},
+	})
+	info := &grpc.UnaryServerInfo{FullMethod: "/key.v1.KeyService/ValidateHookKey"}
+
+	tags := grpc_ctxtags.UnaryServerInterceptor()
+	requests := logging.UnaryServerInterceptor()
+	_, err := tags(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return requests(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			logging.SetPrincipal(ctx, "hooks-service")
+			return nil, level.Info(logging.FromContext(ctx, logger)).Log("msg", "validated")
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("log line isn't json: %v, %s", err, buf.String())
+	}
+
+	for field, want := range map[string]string{
+		logging.RequestIDField: "request-1",
+		logging.MethodField:    info.FullMethod,
+		logging.PeerField:      "10.0.0.1:1234",
+		logging.PrincipalField: "hooks-service",
+		"level":                "info",
+	} {
+		if line[field] != want {
+			t.Errorf("%s = %v, want %v", field, line[field], want)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.New(&buf, false)
+
+	var requestID string
+	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
+	r.Use(logging.Middleware(logger))
+	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
+		requestID = middleware.GetReqID(r.Context())
+		_ = level.Info(logging.FromContext(r.Context(), logger)).Log("msg", "health")
+	})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("log line isn't json: %v, %s", err, buf.String())
+	}
+	if requestID == "" || line[logging.RequestIDField] != requestID {
+		t.Errorf("%s = %v, want %v", logging.RequestIDField, line[logging.RequestIDField], requestID)
+	}
+	if line[logging.MethodField] != "GET /health" {
+		t.Errorf("%s = %v, want %v", logging.MethodField, line[logging.MethodField], "GET /health")
+	}
+}
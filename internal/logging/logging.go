@@ -0,0 +1,63 @@
+package logging
+
+import (
+	"io"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+const Redacted = "[REDACTED]"
+
+// New builds the service logger, json lines with a timestamp, debug only comes through in development,
+// and anything logged under a key, secret or token field is redacted before it is written
+func New(w io.Writer, development bool) log.Logger {
+	l := log.NewJSONLogger(log.NewSyncWriter(w))
+	l = Redact(l)
+	l = log.With(l, "ts", log.DefaultTimestampUTC)
+
+	if development {
+		return level.NewFilter(l, level.AllowDebug())
+	}
+
+	return level.NewFilter(l, level.AllowInfo())
+}
+
+type redactor struct {
+	next log.Logger
+}
+
+func Redact(next log.Logger) log.Logger {
+	return &redactor{
+		next: next,
+	}
+}
+
+func (r *redactor) Log(keyvals ...interface{}) error {
+	clean := make([]interface{}, len(keyvals))
+	copy(clean, keyvals)
+
+	for i := 0; i+1 < len(clean); i += 2 {
+		if name, ok := clean[i].(string); ok && Sensitive(name) {
+			clean[i+1] = Redacted
+		}
+	}
+
+	return r.next.Log(clean...)
+}
+
+// Sensitive reports whether a field name looks like it holds key material,
+// key ids are fine to log, keys, secrets, tokens and passwords are not
+func Sensitive(name string) bool {
+	n := strings.ToLower(name)
+	n = strings.NewReplacer("_", "", "-", "", ".", "").Replace(n)
+
+	for _, s := range []string{"secret", "token", "password", "passwd", "signature", "nonce"} {
+		if strings.Contains(n, s) {
+			return true
+		}
+	}
+
+	return strings.HasSuffix(n, "key")
+}
@@ -0,0 +1,61 @@
+package logging_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log/level"
+	"github.com/k8sdeploy/key-service/internal/logging"
+)
+
+func TestRedact(t *testing.T) {
+	secret := "thisShouldNeverBeLogged"
+
+	tests := []struct {
+		name   string
+		field  string
+		redact bool
+	}{
+		{name: "key", field: "key", redact: true},
+		{name: "service key", field: "service_key", redact: true},
+		{name: "camel service key", field: "serviceKey", redact: true},
+		{name: "secret", field: "secret", redact: true},
+		{name: "hook secret", field: "hook_secret", redact: true},
+		{name: "token", field: "vault_token", redact: true},
+		{name: "password", field: "password", redact: true},
+		{name: "signature", field: "signature", redact: true},
+		{name: "key id", field: "key_id", redact: false},
+		{name: "company", field: "company_id", redact: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := logging.New(&buf, false)
+
+			if err := level.Info(logger).Log("msg", "testing", tt.field, secret); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := strings.Contains(buf.String(), secret); got == tt.redact {
+				t.Errorf("field %s logged as %s", tt.field, buf.String())
+			}
+		})
+	}
+}
+
+func TestNew_Levels(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.New(&buf, false)
+	_ = level.Debug(logger).Log("msg", "debug line")
+	if buf.Len() != 0 {
+		t.Errorf("debug logged outside development: %s", buf.String())
+	}
+
+	logger = logging.New(&buf, true)
+	_ = level.Debug(logger).Log("msg", "debug line")
+	if !strings.Contains(buf.String(), "debug line") {
+		t.Errorf("debug not logged in development")
+	}
+}
@@ -0,0 +1,123 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "key_service"
+
+// Outcomes
+const (
+	OK          = "ok"
+	Valid       = "valid"
+	Invalid     = "invalid"
+	Expired     = "expired"
+	Revoked     = "revoked"
+	SystemError = "system_error"
+)
+
+var (
+	RPCResults = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rpc_results_total",
+		Help:      "Results of key RPCs by outcome",
+	}, []string{"rpc", "outcome"})
+
+	KeysCreated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "keys_created_total",
+		Help:      "Keys created by type",
+	}, []string{"type"})
+
+	ActiveKeys = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_keys",
+		Help:      "Keys that would still validate by type, revoked and expired keys are not counted",
+	}, []string{"type"})
+
+	MongoDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "mongo_operation_duration_seconds",
+		Help:      "Time taken by each key store operation",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	VaultDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "vault_request_duration_seconds",
+		Help:      "Time taken by vault requests",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	VaultErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "vault_errors_total",
+		Help:      "Failed vault requests",
+	}, []string{"operation"})
+
+	EventsPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_published_total",
+		Help:      "Key lifecycle events published by type",
+	}, []string{"type"})
+
+	EventsFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_failed_total",
+		Help:      "Key lifecycle events that failed to publish by type",
+	}, []string{"type"})
+
+	EventsDeadLettered = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_dead_lettered_total",
+		Help:      "Key lifecycle events set aside after failing to publish too many times by type",
+	}, []string{"type"})
+
+	CacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "validation_cache_lookups_total",
+		Help:      "Validation cache lookups by result",
+	}, []string{"result"})
+)
+
+func Result(rpc, outcome string) {
+	RPCResults.WithLabelValues(rpc, outcome).Inc()
+}
+
+func KeyCreated(keyType string) {
+	KeysCreated.WithLabelValues(keyType).Inc()
+}
+
+// MongoTimer times a store operation, use it as defer metrics.MongoTimer("op").ObserveDuration()
+func MongoTimer(operation string) *prometheus.Timer {
+	return prometheus.NewTimer(MongoDuration.WithLabelValues(operation))
+}
+
+func VaultTimer(operation string) *prometheus.Timer {
+	return prometheus.NewTimer(VaultDuration.WithLabelValues(operation))
+}
+
+func VaultError(operation string) {
+	VaultErrors.WithLabelValues(operation).Inc()
+}
+
+func EventPublished(eventType string) {
+	EventsPublished.WithLabelValues(eventType).Inc()
+}
+
+func EventFailed(eventType string) {
+	EventsFailed.WithLabelValues(eventType).Inc()
+}
+
+func EventDeadLettered(eventType string) {
+	EventsDeadLettered.WithLabelValues(eventType).Inc()
+}
+
+func CacheLookup(hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	CacheLookups.WithLabelValues(result).Inc()
+}
@@ -0,0 +1,9 @@
+package ratelimit
+
+// Stored is how many counters and locks the backend is holding on to
+func Stored(m *Memory) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.counters) + len(m.locks)
+}
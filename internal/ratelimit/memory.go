@@ -0,0 +1,103 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type counter struct {
+	value   int64
+	expires time.Time
+}
+
+type Memory struct {
+	mu       sync.Mutex
+	counters map[string]counter
+	locks    map[string]time.Time
+	Now      func() time.Time
+	// SweepInterval is how often everything that has run out is dropped, anything touched in between
+	// is expired as it is used
+	SweepInterval time.Duration
+	swept         time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		counters:      make(map[string]counter),
+		locks:         make(map[string]time.Time),
+		Now:           time.Now,
+		SweepInterval: time.Minute,
+	}
+}
+
+func (m *Memory) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.Now()
+	m.sweep(now)
+
+	c, ok := m.counters[key]
+	if !ok || !c.expires.After(now) {
+		c = counter{expires: now.Add(ttl)}
+	}
+	c.value++
+	m.counters[key] = c
+
+	return c.value, nil
+}
+
+func (m *Memory) LockUntil(ctx context.Context, key string) (time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.Now()
+	m.sweep(now)
+
+	until, ok := m.locks[key]
+	if ok && !until.After(now) {
+		delete(m.locks, key)
+		return time.Time{}, nil
+	}
+
+	return until, nil
+}
+
+func (m *Memory) Lock(ctx context.Context, key string, until time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.locks[key] = until
+
+	return nil
+}
+
+func (m *Memory) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.counters, key)
+
+	return nil
+}
+
+// sweep drops every counter and lock that has run out, at most once every SweepInterval so the cost isn't
+// paid on each call
+func (m *Memory) sweep(now time.Time) {
+	if now.Sub(m.swept) < m.SweepInterval {
+		return
+	}
+	m.swept = now
+
+	for k, c := range m.counters {
+		if !c.expires.After(now) {
+			delete(m.counters, k)
+		}
+	}
+	for k, until := range m.locks {
+		if !until.After(now) {
+			delete(m.locks, k)
+		}
+	}
+}
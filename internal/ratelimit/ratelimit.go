@@ -0,0 +1,125 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+var (
+	ErrRateLimited = errors.New("rate limited")
+	ErrLockedOut   = errors.New("locked out")
+)
+
+// Backend holds the limiter counters, it has to be shared between replicas for the limits to mean anything
+type Backend interface {
+	// Incr adds one to the counter, creating it with the ttl if it doesn't exist, and returns the new value
+	Incr(ctx context.Context, key string, ttl time.Duration) (int64, error)
+	// LockUntil returns when the lock on key runs out, the zero time if there isn't one
+	LockUntil(ctx context.Context, key string) (time.Time, error)
+	Lock(ctx context.Context, key string, until time.Time) error
+	Delete(ctx context.Context, key string) error
+}
+
+type Limits struct {
+	CompanyRequests int
+	KeyRequests     int
+	Window          time.Duration
+
+	MaxFailures  int
+	FailureTTL   time.Duration
+	LockoutBase  time.Duration
+	LockoutLimit time.Duration
+}
+
+type Limiter struct {
+	Backend Backend
+	Limits  Limits
+	Now     func() time.Time
+}
+
+func NewLimiter(b Backend, l Limits) *Limiter {
+	return &Limiter{
+		Backend: b,
+		Limits:  l,
+		Now:     time.Now,
+	}
+}
+
+// Allow checks the company and key request rates and whether the key is currently locked out. the calls come
+// in through the other services on behalf of every tenant, so nothing is limited on where they come from or
+// one noisy tenant would throttle the rest
+func (l *Limiter) Allow(ctx context.Context, companyID, keyID string) error {
+	until, err := l.Backend.LockUntil(ctx, lockKey(companyID, keyID))
+	if err != nil {
+		return err
+	}
+	if until.After(l.Now()) {
+		return ErrLockedOut
+	}
+
+	window := l.Now().Truncate(l.Limits.Window).Unix()
+	if companyID != "" && l.Limits.CompanyRequests > 0 {
+		n, err := l.Backend.Incr(ctx, fmt.Sprintf("rate:company:%s:%d", companyID, window), l.Limits.Window)
+		if err != nil {
+			return err
+		}
+		if n > int64(l.Limits.CompanyRequests) {
+			return ErrRateLimited
+		}
+	}
+	if keyID != "" && l.Limits.KeyRequests > 0 {
+		n, err := l.Backend.Incr(ctx, fmt.Sprintf("rate:key:%s:%s:%d", companyID, keyID, window), l.Limits.Window)
+		if err != nil {
+			return err
+		}
+		if n > int64(l.Limits.KeyRequests) {
+			return ErrRateLimited
+		}
+	}
+
+	return nil
+}
+
+// Failure records a failed validation for the key, once there have been MaxFailures in a row the key is locked,
+// each further failure doubles the lockout up to LockoutLimit
+func (l *Limiter) Failure(ctx context.Context, companyID, keyID string) error {
+	if l.Limits.MaxFailures <= 0 {
+		return nil
+	}
+
+	n, err := l.Backend.Incr(ctx, failureKey(companyID, keyID), l.Limits.FailureTTL)
+	if err != nil {
+		return err
+	}
+	if n < int64(l.Limits.MaxFailures) {
+		return nil
+	}
+
+	return l.Backend.Lock(ctx, lockKey(companyID, keyID), l.Now().Add(l.lockout(n)))
+}
+
+// Success clears the failure count for the key
+func (l *Limiter) Success(ctx context.Context, companyID, keyID string) error {
+	return l.Backend.Delete(ctx, failureKey(companyID, keyID))
+}
+
+func (l *Limiter) lockout(failures int64) time.Duration {
+	over := float64(failures - int64(l.Limits.MaxFailures))
+	d := time.Duration(float64(l.Limits.LockoutBase) * math.Pow(2, over))
+	if d <= 0 || d > l.Limits.LockoutLimit {
+		return l.Limits.LockoutLimit
+	}
+
+	return d
+}
+
+func failureKey(companyID, keyID string) string {
+	return fmt.Sprintf("failures:%s:%s", companyID, keyID)
+}
+
+func lockKey(companyID, keyID string) string {
+	return fmt.Sprintf("lock:%s:%s", companyID, keyID)
+}
@@ -0,0 +1,231 @@
+package ratelimit_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/k8sdeploy/key-service/internal/ratelimit"
+)
+
+func newLimiter(now *time.Time) *ratelimit.Limiter {
+	backend := ratelimit.NewMemory()
+	backend.Now = func() time.Time { return *now }
+
+	l := ratelimit.NewLimiter(backend, ratelimit.Limits{
+		CompanyRequests: 3,
+		KeyRequests:     2,
+		Window:          time.Minute,
+		MaxFailures:     3,
+		FailureTTL:      15 * time.Minute,
+		LockoutBase:     30 * time.Second,
+		LockoutLimit:    2 * time.Minute,
+	})
+	l.Now = func() time.Time { return *now }
+
+	return l
+}
+
+func TestLimiter_Allow(t *testing.T) {
+	type request struct {
+		company string
+		key     string
+	}
+
+	tests := []struct {
+		name     string
+		requests []request
+		want     error
+	}{
+		{
+			name:     "under the company limit",
+			requests: []request{{"company", "a"}, {"company", "b"}, {"company", "a"}},
+		},
+		{
+			name:     "over the company limit",
+			requests: []request{{"company", "a"}, {"company", "b"}, {"company", "c"}, {"company", "d"}},
+			want:     ratelimit.ErrRateLimited,
+		},
+		{
+			name:     "over the key limit",
+			requests: []request{{"company", "a"}, {"company", "a"}, {"company", "a"}},
+			want:     ratelimit.ErrRateLimited,
+		},
+		{
+			// they all come through the same service, a busy tenant mustn't use up the others' limits
+			name: "companies don't share limits",
+			requests: []request{
+				{"a", "key"}, {"b", "key"}, {"c", "key"}, {"a", "other"}, {"b", "other"}, {"c", "other"}, {"d", "key"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Unix(1670000000, 0)
+			l := newLimiter(&now)
+
+			var err error
+			for _, r := range tt.requests {
+				err = l.Allow(context.Background(), r.company, r.key)
+			}
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Limiter.Allow() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLimiter_WindowResets(t *testing.T) {
+	now := time.Unix(1670000000, 0)
+	l := newLimiter(&now)
+
+	for i := 0; i < 4; i++ {
+		_ = l.Allow(context.Background(), "company", "key")
+	}
+	now = now.Add(time.Minute)
+
+	if err := l.Allow(context.Background(), "company", "key"); err != nil {
+		t.Errorf("Limiter.Allow() in new window = %v, want nil", err)
+	}
+}
+
+func TestLimiter_Lockout(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1670000000, 0)
+	l := newLimiter(&now)
+
+	for i := 0; i < 2; i++ {
+		if err := l.Failure(ctx, "company", "key"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Allow(ctx, "company", "key"); err != nil {
+		t.Errorf("Limiter.Allow() before lockout = %v, want nil", err)
+	}
+
+	if err := l.Failure(ctx, "company", "key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Allow(ctx, "company", "key"); !errors.Is(err, ratelimit.ErrLockedOut) {
+		t.Errorf("Limiter.Allow() after %d failures = %v, want %v", 3, err, ratelimit.ErrLockedOut)
+	}
+	if err := l.Allow(ctx, "company", "other"); err != nil {
+		t.Errorf("Limiter.Allow() for other key = %v, want nil", err)
+	}
+
+	now = now.Add(31 * time.Second)
+	if err := l.Allow(ctx, "company", "key"); err != nil {
+		t.Errorf("Limiter.Allow() after first lockout = %v, want nil", err)
+	}
+
+	// the next failure doubles the lockout
+	if err := l.Failure(ctx, "company", "key"); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(31 * time.Second)
+	if err := l.Allow(ctx, "company", "key"); !errors.Is(err, ratelimit.ErrLockedOut) {
+		t.Errorf("Limiter.Allow() during doubled lockout = %v, want %v", err, ratelimit.ErrLockedOut)
+	}
+	now = now.Add(30 * time.Second)
+	if err := l.Allow(ctx, "company", "key"); err != nil {
+		t.Errorf("Limiter.Allow() after doubled lockout = %v, want nil", err)
+	}
+}
+
+func TestLimiter_SuccessResetsFailures(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1670000000, 0)
+	l := newLimiter(&now)
+
+	for i := 0; i < 2; i++ {
+		_ = l.Failure(ctx, "company", "key")
+	}
+	if err := l.Success(ctx, "company", "key"); err != nil {
+		t.Fatal(err)
+	}
+	_ = l.Failure(ctx, "company", "key")
+
+	if err := l.Allow(ctx, "company", "key"); err != nil {
+		t.Errorf("Limiter.Allow() = %v, want nil", err)
+	}
+}
+
+func TestMemory_Expiry(t *testing.T) {
+	tests := []struct {
+		name       string
+		sweep      time.Duration
+		run        func(m *ratelimit.Memory, now *time.Time) error
+		wantStored int
+	}{
+		{
+			name:  "expired counter starts again",
+			sweep: time.Hour,
+			run: func(m *ratelimit.Memory, now *time.Time) error {
+				_, _ = m.Incr(context.Background(), "key", time.Minute)
+				*now = now.Add(2 * time.Minute)
+				if n, _ := m.Incr(context.Background(), "key", time.Minute); n != 1 {
+					return fmt.Errorf("Incr() after the ttl = %d, want 1", n)
+				}
+				return nil
+			},
+			wantStored: 1,
+		},
+		{
+			name:  "expired lock is dropped",
+			sweep: time.Hour,
+			run: func(m *ratelimit.Memory, now *time.Time) error {
+				_ = m.Lock(context.Background(), "key", now.Add(time.Minute))
+				*now = now.Add(2 * time.Minute)
+				if until, _ := m.LockUntil(context.Background(), "key"); !until.IsZero() {
+					return fmt.Errorf("LockUntil() after the lock = %s, want none", until)
+				}
+				return nil
+			},
+		},
+		{
+			name:  "sweep drops what wasn't touched",
+			sweep: time.Minute,
+			run: func(m *ratelimit.Memory, now *time.Time) error {
+				for _, k := range []string{"a", "b", "c"} {
+					_, _ = m.Incr(context.Background(), k, 30*time.Second)
+				}
+				*now = now.Add(2 * time.Minute)
+				_, _ = m.Incr(context.Background(), "d", 30*time.Second)
+				return nil
+			},
+			wantStored: 1,
+		},
+		{
+			name:  "no sweep until the interval",
+			sweep: time.Hour,
+			run: func(m *ratelimit.Memory, now *time.Time) error {
+				for _, k := range []string{"a", "b", "c"} {
+					_, _ = m.Incr(context.Background(), k, 30*time.Second)
+				}
+				*now = now.Add(2 * time.Minute)
+				_, _ = m.Incr(context.Background(), "d", 30*time.Second)
+				return nil
+			},
+			wantStored: 4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Unix(1670000000, 0)
+			m := ratelimit.NewMemory()
+			m.Now = func() time.Time { return now }
+			m.SweepInterval = tt.sweep
+
+			if err := tt.run(m, &now); err != nil {
+				t.Fatal(err)
+			}
+			if got := ratelimit.Stored(m); got != tt.wantStored {
+				t.Errorf("stored = %d, want %d", got, tt.wantStored)
+			}
+		})
+	}
+}
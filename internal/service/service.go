@@ -1,96 +1,409 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	bugLog "github.com/bugfixes/go-bugfixes/logs"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/cors"
+	"github.com/k8sdeploy/key-service/internal/audit"
 	"github.com/k8sdeploy/key-service/internal/config"
+	"github.com/k8sdeploy/key-service/internal/events"
+	kspb "github.com/k8sdeploy/key-service/internal/generated/keyservice/v1"
+	"github.com/k8sdeploy/key-service/internal/health"
 	"github.com/k8sdeploy/key-service/internal/key"
+	"github.com/k8sdeploy/key-service/internal/logging"
+	"github.com/k8sdeploy/key-service/internal/metrics"
+	"github.com/k8sdeploy/key-service/internal/ratelimit"
 	pb "github.com/k8sdeploy/protos/generated/key/v1"
-	"github.com/keloran/go-healthcheck"
-	"github.com/keloran/go-probe"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
 
 	kitlog "github.com/go-kit/log"
+	"github.com/go-kit/log/level"
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	"github.com/grpc-ecosystem/go-grpc-middleware/logging/kit"
+	grpc_ctxtags "github.com/grpc-ecosystem/go-grpc-middleware/tags"
+	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
 )
 
 type Service struct {
 	Config *config.Config
+	Logger kitlog.Logger
+
+	ready int32
+}
+
+// Ready is whether the service should be sent traffic, it goes false as soon as shutdown starts
+func (s *Service) Ready() bool {
+	return atomic.LoadInt32(&s.ready) == 1
+}
+
+func (s *Service) setReady(ready bool) {
+	var v int32
+	if ready {
+		v = 1
+	}
+	atomic.StoreInt32(&s.ready, v)
 }
 
-func (s *Service) Start() error {
-	errChan := make(chan error)
-	go startGRPC(s.Config.GRPCPort, errChan, s.Config)
+// Start runs the service until ctx is done or a server fails, either way it then shuts down cleanly
+func (s *Service) Start(ctx context.Context) error {
+	ks, store := s.keyServer()
 
+	var publisher events.Publisher
 	if !s.Config.Development {
-		go startHTTP(s.Config.HTTPPort, errChan)
+		p, err := key.NewPublisher(s.Config)
+		if err != nil {
+			return fmt.Errorf("events publisher: %w", err)
+		}
+		publisher = p
+	}
+
+	// the store, audit log, watcher and outbox all go through one pooled client, development keeps keys in
+	// memory so there is nothing to connect to or index
+	m, _ := store.(*key.Mongo)
+	var indexes *key.Indexes
+	if m != nil {
+		if err := m.Connect(ctx); err != nil {
+			return fmt.Errorf("mongo: %w", err)
+		}
+		indexes = key.NewIndexes(m, s.Logger)
+	}
+
+	// the limits are checked on every validate, so they share one pooled client rather than connecting each time
+	limits, _ := ks.Limiter.Backend.(*key.MongoLimits)
+	if limits != nil {
+		if err := limits.Connect(ctx); err != nil {
+			return fmt.Errorf("rate limit mongo: %w", err)
+		}
+	}
+
+	monitor := health.NewMonitor(s.Config.HealthInterval, s.Config.HealthTimeout, s.Ready, s.Logger, s.checks(store, indexes)...)
+	monitor.Services = []string{
+		pb.KeyService_ServiceDesc.ServiceName,
+		kspb.ExtendedKeyService_ServiceDesc.ServiceName,
+	}
+
+	p := fmt.Sprintf(":%d", s.Config.GRPCPort)
+	lis, err := net.Listen("tcp", p)
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+
+	errChan := make(chan error, 2)
+	gs := newGRPC(ks, monitor, s.Config.Development, s.Logger)
+	_ = level.Info(s.Logger).Log("msg", "starting key grpc", "address", lis.Addr().String())
+	go func() {
+		if err := gs.Serve(lis); err != nil {
+			errChan <- fmt.Errorf("failed to start grpc: %w", err)
+		}
+	}()
+
+	hs := newHTTP(s.Config.HTTPPort, monitor, s.Config.Development, s.Logger)
+	_ = level.Info(s.Logger).Log("msg", "starting key http", "address", hs.Addr)
+	go func() {
+		if err := hs.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errChan <- fmt.Errorf("failed to start http: %w", err)
+		}
+	}()
+
+	workCtx, stopWork := context.WithCancel(context.Background())
+	defer stopWork()
+	var workers sync.WaitGroup
+	work := func(f func(ctx context.Context)) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			f(workCtx)
+		}()
 	}
 
-	return <-errChan
+	work(func(ctx context.Context) {
+		s.Config.RenewVault(ctx, s.Logger)
+	})
+	work(func(ctx context.Context) {
+		s.Config.RenewMongoCredentials(ctx, s.Logger)
+	})
+	if !s.Config.Development {
+		work(func(ctx context.Context) {
+			s.Config.WatchSecrets(ctx, s.Logger)
+		})
+	}
+	if m != nil {
+		work(m.Run)
+	}
+	if indexes != nil {
+		work(indexes.Run)
+	}
+	if limits != nil {
+		work(limits.Run)
+	}
+	work(ks.InvalidateOnEvents)
+	work(func(ctx context.Context) {
+		countActiveKeys(ctx, store, s.Config.ActiveKeysInterval, s.Logger)
+	})
+	if s.Config.KeyLifetime > 0 {
+		work(func(ctx context.Context) {
+			expireKeys(ctx, store, s.Config.KeyExpiryInterval, s.Logger)
+		})
+	}
+	var relay *events.Relay
+	if publisher != nil && m != nil {
+		relay = key.NewRelay(m, publisher)
+		work(relay.Run)
+	}
+
+	s.setReady(true)
+	work(monitor.Run)
+
+	select {
+	case <-ctx.Done():
+		_ = level.Info(s.Logger).Log("msg", "shutting down")
+	case err = <-errChan:
+		_ = level.Error(s.Logger).Log("msg", "shutting down after server failure", "err", err)
+	}
+
+	// stop being sent new requests, then give the load balancer time to notice before closing listeners
+	s.setReady(false)
+	monitor.Shutdown()
+	time.Sleep(s.Config.ShutdownDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.Config.ShutdownTimeout)
+	defer cancel()
+
+	ks.Shutdown()
+	var servers sync.WaitGroup
+	servers.Add(2)
+	go func() {
+		defer servers.Done()
+		stopGRPC(shutdownCtx, gs)
+	}()
+	go func() {
+		defer servers.Done()
+		if err := hs.Shutdown(shutdownCtx); err != nil {
+			_ = level.Warn(s.Logger).Log("msg", "shutting down http", "err", err)
+		}
+	}()
+	servers.Wait()
+
+	stopWork()
+	workers.Wait()
+
+	// anything still in the outbox goes out now rather than waiting for another replica
+	if relay != nil {
+		if _, err := relay.Flush(shutdownCtx); err != nil {
+			_ = level.Warn(s.Logger).Log("msg", "flushing events", "err", err)
+		}
+	}
+	if publisher != nil {
+		if err := publisher.Close(); err != nil {
+			_ = level.Warn(s.Logger).Log("msg", "closing events publisher", "err", err)
+		}
+	}
+	// the outbox has been flushed, so nothing needs mongo any more
+	if m != nil {
+		m.Close(shutdownCtx)
+	}
+	if limits != nil {
+		limits.Close(shutdownCtx)
+	}
+	if c, ok := ks.Cache.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			_ = level.Warn(s.Logger).Log("msg", "closing validation cache", "err", err)
+		}
+	}
+
+	_ = level.Info(s.Logger).Log("msg", "stopped")
+
+	return err
+}
+
+// stopGRPC lets in flight rpcs finish, anything still running at the deadline is cut off
+func stopGRPC(ctx context.Context, gs *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		gs.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		gs.Stop()
+	}
 }
 
-func startGRPC(port int, errChan chan error, config *config.Config) {
-	kOpts := []kit.Option{
-		kit.WithDecider(func(methodFullName string, err error) bool {
-			if err != nil {
-				bugLog.Local().Infof("%s: %+v", methodFullName, err)
-				return false
-			}
-			return true
-		}),
+// keyServer builds the key server, in development everything it keeps is held in memory so nothing
+// outside the process is needed, the returned store is the one the background workers use
+func (s *Service) keyServer() (*key.Server, key.Store) {
+	if s.Config.Development {
+		hub := events.NewHub(1000)
+		store := key.NewMemoryStore(s.Config, hub)
+
+		return &key.Server{
+			Config:  s.Config,
+			Limiter: key.NewLimiter(s.Config, ratelimit.NewMemory()),
+			Audit:   audit.NewMemory([]byte(s.Config.Audit.HMACKey)),
+			Watcher: hub,
+			Cache:   key.NewCache(s.Config, s.Logger),
+			Store:   store,
+			Logger:  s.Logger,
+		}, store
+	}
+
+	m := key.NewMongo(s.Config)
+	m.Logger = s.Logger
+
+	return &key.Server{
+		Config:  s.Config,
+		Limiter: key.NewLimiter(s.Config, key.NewLimiterBackend(s.Config, s.Logger)),
+		Audit:   key.NewAudit(m),
+		Watcher: key.NewWatcher(m),
+		Cache:   key.NewCache(s.Config, s.Logger),
+		Store:   m,
+		Logger:  s.Logger,
+	}, m
+}
+
+// checks are what the service needs before it is ready, a bad mongo password or vault token shows up here
+func (s *Service) checks(store key.Store, indexes *key.Indexes) []health.Check {
+	checks := []health.Check{
+		{
+			Name: "store",
+			Run:  store.Ping,
+		},
+	}
+	if s.Config.Development {
+		return checks
+	}
+
+	if indexes != nil {
+		checks = append(checks, health.Check{
+			Name: "indexes",
+			Run:  indexes.Check,
+		})
 	}
+	if s.Config.UsesVault() {
+		checks = append(checks, health.Check{
+			Name: "vault",
+			Run:  s.Config.CheckVault,
+		})
+	}
+
+	return append(checks, health.Check{
+		Name: "service_keys",
+		Run: func(ctx context.Context) error {
+			return s.Config.CheckServiceKeys()
+		},
+	})
+}
+
+func newGRPC(ks *key.Server, monitor *health.Monitor, development bool, logger kitlog.Logger) *grpc.Server {
 	opts := []grpc.ServerOption{
 		grpc_middleware.WithStreamServerChain(
-			kit.StreamServerInterceptor(kitlog.NewNopLogger(), kOpts...),
+			otelgrpc.StreamServerInterceptor(),
+			grpc_ctxtags.StreamServerInterceptor(),
+			logging.StreamServerInterceptor(),
+			ks.StreamPrincipalInterceptor(),
+			grpc_prometheus.StreamServerInterceptor,
+			kit.StreamServerInterceptor(logger),
 		),
 		grpc_middleware.WithUnaryServerChain(
-			kit.UnaryServerInterceptor(kitlog.NewNopLogger(), kOpts...),
+			otelgrpc.UnaryServerInterceptor(),
+			grpc_ctxtags.UnaryServerInterceptor(),
+			logging.UnaryServerInterceptor(),
+			ks.PrincipalInterceptor(),
+			grpc_prometheus.UnaryServerInterceptor,
+			ks.MetricsInterceptor(),
+			kit.UnaryServerInterceptor(logger),
 		),
 	}
 
-	p := fmt.Sprintf(":%d", port)
-	bugLog.Local().Infof("Starting Key GRPC: %s", p)
-	lis, err := net.Listen("tcp", p)
-	if err != nil {
-		errChan <- bugLog.Errorf("failed to listen: %v", err)
-	}
 	gs := grpc.NewServer(opts...)
-	reflection.Register(gs)
-	pb.RegisterKeyServiceServer(gs, &key.Server{
-		Config: config,
-	})
-	if err := gs.Serve(lis); err != nil {
-		errChan <- bugLog.Errorf("failed to start grpc: %v", err)
+	// reflection lets grpcurl list everything, only wanted locally
+	if development {
+		reflection.Register(gs)
 	}
-}
+	pb.RegisterKeyServiceServer(gs, ks)
+	kspb.RegisterExtendedKeyServiceServer(gs, ks)
+	healthpb.RegisterHealthServer(gs, monitor.GRPC)
+	grpc_prometheus.EnableHandlingTimeHistogram()
+	grpc_prometheus.Register(gs)
 
-func startHTTP(port int, errChan chan error) {
-	p := fmt.Sprintf(":%d", port)
-	bugLog.Local().Infof("Starting Key HTTP: %s", p)
+	return gs
+}
 
+func newHTTP(port int, monitor *health.Monitor, development bool, logger kitlog.Logger) *http.Server {
 	r := chi.NewRouter()
+	if development {
+		r.Use(cors.AllowAll().Handler)
+	}
 	r.Use(middleware.Heartbeat("/ping"))
 	r.Use(middleware.RequestID)
-	r.Get("/health", healthcheck.HTTP)
-	r.Get("/probe", probe.HTTP)
+	r.Use(logging.Middleware(logger))
+	r.Get("/live", health.LiveHandler)
+	r.Get("/ready", monitor.ReadyHandler)
+	r.Handle("/metrics", promhttp.Handler())
 
-	srv := &http.Server{
-		Addr:              p,
-		Handler:           r,
+	return &http.Server{
+		Addr:              fmt.Sprintf(":%d", port),
+		Handler:           otelhttp.NewHandler(r, "key-service"),
 		ReadTimeout:       5 * time.Second,
 		ReadHeaderTimeout: 5 * time.Second,
 		WriteTimeout:      10 * time.Second,
 		IdleTimeout:       10 * time.Second,
 	}
-	if err := srv.ListenAndServe(); err != nil {
-		errChan <- bugLog.Errorf("failed to start http: %v", err)
+}
+
+func countActiveKeys(ctx context.Context, store key.Store, interval time.Duration, logger kitlog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		counts, err := store.CountKeys(ctx)
+		if err != nil {
+			_ = level.Warn(logger).Log("msg", "counting active keys", "err", err)
+		}
+		for keyType, n := range counts {
+			metrics.ActiveKeys.WithLabelValues(keyType).Set(float64(n))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func expireKeys(ctx context.Context, store key.Store, interval time.Duration, logger kitlog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		n, err := store.ExpireKeys(ctx)
+		if err != nil {
+			_ = level.Warn(logger).Log("msg", "expiring keys", "err", err)
+		}
+		if n > 0 {
+			_ = level.Info(logger).Log("msg", "expired keys", "count", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
 	}
 }
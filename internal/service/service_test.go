@@ -0,0 +1,55 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/k8sdeploy/key-service/internal/config"
+	"github.com/k8sdeploy/key-service/internal/service"
+)
+
+func TestService_Shutdown(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Development = true
+	cfg.ShutdownTimeout = time.Second
+	cfg.HealthInterval = time.Second
+	cfg.HealthTimeout = time.Second
+	cfg.ActiveKeysInterval = time.Second
+	cfg.RateLimit.Backend = "memory"
+	cfg.Cache.Backend = "none"
+
+	s := &service.Service{
+		Config: cfg,
+		Logger: log.NewNopLogger(),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- s.Start(ctx)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !s.Ready() {
+		if time.Now().After(deadline) {
+			t.Fatalf("service never became ready")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Start() = %v, want nil after a clean shutdown", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Start() didn't return after the context was cancelled")
+	}
+
+	if s.Ready() {
+		t.Errorf("Ready() = true after shutdown")
+	}
+}
@@ -0,0 +1,120 @@
+package signature
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/k8sdeploy/key-service/internal/compare"
+)
+
+var (
+	ErrMissingNonce     = errors.New("missing nonce")
+	ErrMissingSignature = errors.New("missing signature")
+	ErrOutsideWindow    = errors.New("timestamp outside replay window")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrReplayedNonce    = errors.New("nonce already used")
+)
+
+// NonceStore records nonces that have been seen, UseNonce returns false if the nonce was already used
+type NonceStore interface {
+	UseNonce(ctx context.Context, nonce string, expires time.Time) (bool, error)
+}
+
+type Request struct {
+	Payload   []byte
+	Timestamp int64
+	Nonce     string
+	Signature string
+}
+
+type Verifier struct {
+	Window time.Duration
+	Nonces NonceStore
+	Now    func() time.Time
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of "timestamp.nonce.payload" keyed with the secret,
+// senders use the same construction so the secret itself never has to be sent
+func Sign(secret string, timestamp int64, nonce string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(fmt.Sprintf("%d.%s.", timestamp, nonce)))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func NewVerifier(window time.Duration, nonces NonceStore) *Verifier {
+	return &Verifier{
+		Window: window,
+		Nonces: nonces,
+		Now:    time.Now,
+	}
+}
+
+func (v *Verifier) Verify(ctx context.Context, secret string, r Request) error {
+	if r.Nonce == "" {
+		return ErrMissingNonce
+	}
+	if r.Signature == "" {
+		return ErrMissingSignature
+	}
+
+	now := v.Now()
+	signed := time.Unix(r.Timestamp, 0)
+	if signed.Before(now.Add(-v.Window)) || signed.After(now.Add(v.Window)) {
+		return ErrOutsideWindow
+	}
+
+	expected := Sign(secret, r.Timestamp, r.Nonce, r.Payload)
+	if !compare.Equal(expected, r.Signature) {
+		return ErrInvalidSignature
+	}
+
+	// only burn the nonce once the signature is known to be good, otherwise anyone could use up a senders nonces
+	fresh, err := v.Nonces.UseNonce(ctx, r.Nonce, signed.Add(v.Window))
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return ErrReplayedNonce
+	}
+
+	return nil
+}
+
+type MemoryNonceStore struct {
+	sync.Mutex
+	nonces map[string]time.Time
+	Now    func() time.Time
+}
+
+func NewMemoryNonceStore() *MemoryNonceStore {
+	return &MemoryNonceStore{
+		nonces: make(map[string]time.Time),
+		Now:    time.Now,
+	}
+}
+
+func (m *MemoryNonceStore) UseNonce(ctx context.Context, nonce string, expires time.Time) (bool, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	now := m.Now()
+	for n, e := range m.nonces {
+		if e.Before(now) {
+			delete(m.nonces, n)
+		}
+	}
+
+	if _, ok := m.nonces[nonce]; ok {
+		return false, nil
+	}
+	m.nonces[nonce] = expires
+
+	return true, nil
+}
@@ -0,0 +1,148 @@
+package signature_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/k8sdeploy/key-service/internal/signature"
+)
+
+func TestVerifier_Verify(t *testing.T) {
+	secret := "tester-secret"
+	payload := []byte(`{"repo":"k8sdeploy/key-service"}`)
+	now := time.Unix(1670000000, 0)
+
+	tests := []struct {
+		name    string
+		secret  string
+		request signature.Request
+		want    error
+	}{
+		{
+			name:   "valid signature",
+			secret: secret,
+			request: signature.Request{
+				Payload:   payload,
+				Timestamp: now.Unix(),
+				Nonce:     "nonce-1",
+				Signature: signature.Sign(secret, now.Unix(), "nonce-1", payload),
+			},
+		},
+		{
+			name:   "wrong secret",
+			secret: secret,
+			request: signature.Request{
+				Payload:   payload,
+				Timestamp: now.Unix(),
+				Nonce:     "nonce-2",
+				Signature: signature.Sign("not-the-secret", now.Unix(), "nonce-2", payload),
+			},
+			want: signature.ErrInvalidSignature,
+		},
+		{
+			name:   "tampered payload",
+			secret: secret,
+			request: signature.Request{
+				Payload:   []byte(`{"repo":"k8sdeploy/other"}`),
+				Timestamp: now.Unix(),
+				Nonce:     "nonce-3",
+				Signature: signature.Sign(secret, now.Unix(), "nonce-3", payload),
+			},
+			want: signature.ErrInvalidSignature,
+		},
+		{
+			name:   "too old",
+			secret: secret,
+			request: signature.Request{
+				Payload:   payload,
+				Timestamp: now.Add(-10 * time.Minute).Unix(),
+				Nonce:     "nonce-4",
+				Signature: signature.Sign(secret, now.Add(-10*time.Minute).Unix(), "nonce-4", payload),
+			},
+			want: signature.ErrOutsideWindow,
+		},
+		{
+			name:   "too far in the future",
+			secret: secret,
+			request: signature.Request{
+				Payload:   payload,
+				Timestamp: now.Add(10 * time.Minute).Unix(),
+				Nonce:     "nonce-5",
+				Signature: signature.Sign(secret, now.Add(10*time.Minute).Unix(), "nonce-5", payload),
+			},
+			want: signature.ErrOutsideWindow,
+		},
+		{
+			name:   "missing nonce",
+			secret: secret,
+			request: signature.Request{
+				Payload:   payload,
+				Timestamp: now.Unix(),
+				Signature: signature.Sign(secret, now.Unix(), "", payload),
+			},
+			want: signature.ErrMissingNonce,
+		},
+		{
+			name:   "missing signature",
+			secret: secret,
+			request: signature.Request{
+				Payload:   payload,
+				Timestamp: now.Unix(),
+				Nonce:     "nonce-6",
+			},
+			want: signature.ErrMissingSignature,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := signature.NewVerifier(5*time.Minute, signature.NewMemoryNonceStore())
+			v.Now = func() time.Time { return now }
+
+			if err := v.Verify(context.Background(), tt.secret, tt.request); !errors.Is(err, tt.want) {
+				t.Errorf("Verifier.Verify() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifier_Replay(t *testing.T) {
+	secret := "tester-secret"
+	payload := []byte("payload")
+	now := time.Unix(1670000000, 0)
+
+	nonces := signature.NewMemoryNonceStore()
+	nonces.Now = func() time.Time { return now }
+	v := signature.NewVerifier(5*time.Minute, nonces)
+	v.Now = func() time.Time { return now }
+
+	r := signature.Request{
+		Payload:   payload,
+		Timestamp: now.Unix(),
+		Nonce:     "nonce",
+		Signature: signature.Sign(secret, now.Unix(), "nonce", payload),
+	}
+	if err := v.Verify(context.Background(), secret, r); err != nil {
+		t.Errorf("first Verifier.Verify() = %v, want nil", err)
+	}
+	if err := v.Verify(context.Background(), secret, r); !errors.Is(err, signature.ErrReplayedNonce) {
+		t.Errorf("replayed Verifier.Verify() = %v, want %v", err, signature.ErrReplayedNonce)
+	}
+}
+
+func TestMemoryNonceStore_Expiry(t *testing.T) {
+	now := time.Unix(1670000000, 0)
+	s := signature.NewMemoryNonceStore()
+	s.Now = func() time.Time { return now }
+
+	if fresh, _ := s.UseNonce(context.Background(), "nonce", now.Add(time.Minute)); !fresh {
+		t.Errorf("UseNonce() = false, want true")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if fresh, _ := s.UseNonce(context.Background(), "nonce", now.Add(time.Minute)); !fresh {
+		t.Errorf("UseNonce() after expiry = false, want true")
+	}
+}
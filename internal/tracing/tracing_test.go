@@ -0,0 +1,118 @@
+package tracing_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/k8sdeploy/key-service/internal/tracing"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestGRPCPropagation(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := tracing.NewProvider(exporter, "key-service", "test", 1)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	lis := bufconn.Listen(1024 * 1024)
+	gs := grpc.NewServer(grpc.ChainUnaryInterceptor(
+		otelgrpc.UnaryServerInterceptor(),
+		func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			ctx, span := tracing.Start(ctx, "child")
+			defer span.End()
+			return handler(ctx, req)
+		},
+	))
+	healthpb.RegisterHealthServer(gs, health.NewServer())
+	go func() {
+		_ = gs.Serve(lis)
+	}()
+	defer gs.Stop()
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(otelgrpc.UnaryClientInterceptor()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	if _, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tp.ForceFlush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := exporter.GetSpans()
+	byName := make(map[string]tracetest.SpanStub)
+	for _, s := range spans {
+		byName[s.Name+"/"+s.SpanKind.String()] = s
+	}
+
+	client, ok := byName["grpc.health.v1.Health/Check/client"]
+	if !ok {
+		t.Fatalf("no client span in %d spans", len(spans))
+	}
+	server, ok := byName["grpc.health.v1.Health/Check/server"]
+	if !ok {
+		t.Fatalf("no server span in %d spans", len(spans))
+	}
+	child, ok := byName["child/internal"]
+	if !ok {
+		t.Fatalf("no child span in %d spans", len(spans))
+	}
+
+	if server.Parent.SpanID() != client.SpanContext.SpanID() {
+		t.Errorf("server span isn't a child of the client span")
+	}
+	if child.Parent.SpanID() != server.SpanContext.SpanID() {
+		t.Errorf("child span isn't a child of the server span")
+	}
+	if child.SpanContext.TraceID() != client.SpanContext.TraceID() {
+		t.Errorf("trace id wasn't propagated")
+	}
+}
+
+func TestDetach(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := tracing.NewProvider(exporter, "key-service", "test", 1)
+	otel.SetTracerProvider(tp)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx, parent := tracing.Start(ctx, "parent")
+	detached := tracing.Detach(ctx)
+	cancel()
+
+	if detached.Err() != nil {
+		t.Errorf("detached context was cancelled with its parent")
+	}
+
+	_, child := tracing.Start(detached, "child")
+	child.End()
+	parent.End()
+	if err := tp.ForceFlush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range exporter.GetSpans() {
+		if s.Name == "child" && s.Parent.SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("detached child isn't parented to the original span")
+		}
+	}
+}